@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// adbAttempts is how many times a command is run while adb fails to reach
+// the device in a way that retrying may remedy.
+const adbAttempts = 3
+
+// adbFailure is a way adb fails to reach the device, rather than the command
+// run on it failing, recognized by what adb prints.
+type adbFailure struct {
+	match  string
+	code   string
+	state  string
+	advice string
+	// remedy readies the device for the command to be run again, or is nil
+	// when running it again would not help.
+	remedy func(d device)
+}
+
+var adbFailures = []adbFailure{
+	{"device unauthorized", errDeviceUnauthorized, "unauthorized", "accept the \"Allow USB debugging?\" dialog on the device to trust this computer's RSA key, and if no dialog shows, reconnect the device or revoke USB debugging authorizations in its developer options", func(d device) {
+		time.Sleep(5 * time.Second)
+	}},
+	{"device offline", errDeviceOffline, "offline", "reconnect the device, or restart it or adb with `blade adb kill-server` if it stays offline", func(d device) {
+		exec.Command(d.adbPath(), "reconnect", "offline").Run()
+		time.Sleep(2 * time.Second)
+	}},
+	{"cannot connect to daemon", errADBServer, "unreachable through the adb server", "check that nothing but the adb server listens on port 5037", restartADBServer},
+	{"protocol fault", errADBServer, "unreachable through the adb server", "check that no other version of adb, such as that of another SDK, is running", restartADBServer},
+	{"no devices/emulators found", errNoDevice, "not connected", "connect a device with USB debugging enabled, start an emulator with `blade emulator`, or connect to one over wireless debugging with `blade connect`", nil},
+	{"more than one device/emulator", errAmbiguousDevice, "one of several connected", "choose which with -device or $ANDROID_SERIAL, from those listed by `blade adb devices`", nil},
+	{"error: device '", errNoDevice, "not connected", "check its serial against those listed by `blade adb devices`, and connect to it again with `blade connect` if it was connected over wireless debugging", nil},
+}
+
+// restartADBServer restarts the adb server, which adb may fail to talk to
+// once it has wedged.
+func restartADBServer(d device) {
+	exec.Command(d.adbPath(), "kill-server").Run()
+	exec.Command(d.adbPath(), "start-server").Run()
+}
+
+// command runs adb against the device, with the command set up by prepare
+// for each attempt, and what it prints to standard error printed as it goes.
+// While adb fails to reach the device, the command is retried, if retry is
+// set, once what may remedy the failure has been done, and failing that an
+// error explaining what to do is returned. Otherwise the command's error is
+// returned, such as an *exec.ExitError with its exit status.
+func (d device) command(args []string, retry bool, prepare func(cmd *exec.Cmd)) error {
+	for attempt := 1; ; attempt++ {
+		var stderr bytes.Buffer
+		cmd := exec.Command(d.adbPath(), d.adbArgs(args)...)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+		prepare(cmd)
+		err := cmd.Run()
+		if _, ok := err.(*exec.ExitError); !ok {
+			return err
+		}
+		var failure *adbFailure
+		for i, f := range adbFailures {
+			if strings.Contains(stderr.String(), f.match) {
+				failure = &adbFailures[i]
+				break
+			}
+		}
+		if failure == nil {
+			return err
+		}
+		device := "the device"
+		if d.serial != "" {
+			device = "device '" + d.serial + "'"
+		}
+		if !retry || failure.remedy == nil || attempt == adbAttempts {
+			return withCode(failure.code, fmt.Errorf("adb could not run %v on %v, as it is %v, so %v", strings.Join(args, " "), device, failure.state, failure.advice))
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", yellow(fmt.Sprintf("%v is %v, so %v; retrying (%v/%v)", device, failure.state, failure.advice, attempt+1, adbAttempts)))
+		failure.remedy(d)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// appConfig holds the app's version and SDK levels, declared in blade.toml as:
+//
+//	[app]
+//	version_code = 12
+//	version_name = "1.4.0"
+//	min_sdk = 21
+//	target_sdk = 34
+//
+// in lieu of the manifest, as the Android Gradle Plugin now requires. They are
+// set on the processed manifest, and `blade migrate` moves them out of an
+// existing manifest.
+type appConfig struct {
+	versionCode int
+	versionName string
+	minSDK      int
+	targetSDK   int
+}
+
+func newAppConfig(t map[string]interface{}) (appConfig, error) {
+	a := appConfig{}
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid [app] config: %v", err)
+	}
+	var err error
+	if a.versionCode, err = intValue(t, "version_code"); err != nil {
+		return a, wrap(err)
+	}
+	if a.versionName, err = stringValue(t, "version_name"); err != nil {
+		return a, wrap(err)
+	}
+	if a.minSDK, err = intValue(t, "min_sdk"); err != nil {
+		return a, wrap(err)
+	}
+	if a.targetSDK, err = intValue(t, "target_sdk"); err != nil {
+		return a, wrap(err)
+	}
+	return a, nil
+}
+
+// appSetting is a manifest attribute that can instead be declared in the
+// [app] table of the config.
+type appSetting struct {
+	element string
+	attr    string
+	key     string
+	integer bool
+}
+
+var appSettings = []appSetting{
+	{"manifest", "android:versionCode", "version_code", true},
+	{"manifest", "android:versionName", "version_name", false},
+	{"uses-sdk", "android:minSdkVersion", "min_sdk", true},
+	{"uses-sdk", "android:targetSdkVersion", "target_sdk", true},
+}
+
+// values returns the configured values of the app's settings by key, as they
+// are written in the manifest.
+func (a appConfig) values() map[string]string {
+	v := make(map[string]string)
+	if a.versionCode != 0 {
+		v["version_code"] = strconv.Itoa(a.versionCode)
+	}
+	if a.versionName != "" {
+		v["version_name"] = a.versionName
+	}
+	if a.minSDK != 0 {
+		v["min_sdk"] = strconv.Itoa(a.minSDK)
+	}
+	if a.targetSDK != 0 {
+		v["target_sdk"] = strconv.Itoa(a.targetSDK)
+	}
+	return v
+}
+
+// override sets the configured version and SDK levels of the app on m, which
+// must not declare any of the settings itself, so that no two places
+// disagree.
+func (a appConfig) override(m *manifest) error {
+	declared := map[string]string{
+		"version_code": m.VersionCode,
+		"version_name": m.VersionName,
+		"min_sdk":      m.UsesSDK.MinSDKVersion,
+		"target_sdk":   m.UsesSDK.TargetSDKVersion,
+	}
+	values := a.values()
+	for _, s := range appSettings {
+		if _, ok := values[s.key]; ok && declared[s.key] != "" {
+			return fmt.Errorf("%v is declared both in the manifest and as %v under [app] in config, run `blade migrate` to remove it from the manifest", s.attr, s.key)
+		}
+	}
+	if v, ok := values["version_code"]; ok {
+		m.VersionCode = v
+	}
+	if v, ok := values["version_name"]; ok {
+		m.VersionName = v
+	}
+	if v, ok := values["min_sdk"]; ok {
+		m.UsesSDK.MinSDKVersion = v
+	}
+	if v, ok := values["target_sdk"]; ok {
+		m.UsesSDK.TargetSDKVersion = v
+	}
+	return nil
+}
+
+var usesSDKElement = regexp.MustCompile(`<uses-sdk\b`)
+
+// apply sets the configured settings on the manifest, adding a uses-sdk
+// element for the SDK levels if the manifest has none.
+func (a appConfig) apply(manifest string) (string, error) {
+	values := a.values()
+	var err error
+	for _, s := range appSettings {
+		v, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		if s.element == "uses-sdk" && !usesSDKElement.MatchString(manifest) {
+			loc := elementTag("manifest").FindStringIndex(manifest)
+			if loc == nil {
+				return "", fmt.Errorf("no manifest element found in manifest to add uses-sdk to")
+			}
+			manifest = manifest[:loc[1]] + "\n    <uses-sdk />" + manifest[loc[1]:]
+		}
+		if manifest, err = setElementAttribute(manifest, s.element, s.attr, escapeAttribute(v)); err != nil {
+			return "", err
+		}
+	}
+	return manifest, nil
+}
+
+var attributeEscapes = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;")
+
+func escapeAttribute(s string) string {
+	return attributeEscapes.Replace(s)
+}
+
+var emptyUsesSDK = regexp.MustCompile(`(?m)^[ \t]*<uses-sdk\s*/>[ \t]*\n?|<uses-sdk\s*/>`)
+
+// migration is a manifest attribute moved to the config.
+type migration struct {
+	setting appSetting
+	value   string
+}
+
+// tomlLine returns the migrated setting as a line of the [app] table.
+func (m migration) tomlLine() string {
+	if m.setting.integer {
+		return fmt.Sprintf("%v = %v", m.setting.key, m.value)
+	}
+	return fmt.Sprintf("%v = %v", m.setting.key, strconv.Quote(m.value))
+}
+
+// migrateManifest removes from the manifest the attributes that can be
+// declared in the [app] table of the config, except for those whose values
+// are placeholders or resource references, which the config cannot hold.
+// It returns the rewritten manifest, what was removed, and why anything was
+// left in place.
+func migrateManifest(manifest string) (string, []migration, []string) {
+	migrated := make([]migration, 0)
+	skipped := make([]string, 0)
+	for _, s := range appSettings {
+		loc := elementTag(s.element).FindStringIndex(manifest)
+		if loc == nil {
+			continue
+		}
+		tag := manifest[loc[0]:loc[1]]
+		m := attribute(s.attr).FindStringSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		v := m[1][1 : len(m[1])-1]
+		if s.integer {
+			if _, err := strconv.Atoi(v); err != nil {
+				skipped = append(skipped, fmt.Sprintf("%v=%q is not a number", s.attr, v))
+				continue
+			}
+		} else if strings.HasPrefix(v, "@") || strings.Contains(v, "${") || strings.Contains(v, "&") {
+			skipped = append(skipped, fmt.Sprintf("%v=%q is not a plain value", s.attr, v))
+			continue
+		}
+		tag = strings.Replace(tag, m[0], "", 1)
+		manifest = manifest[:loc[0]] + tag + manifest[loc[1]:]
+		migrated = append(migrated, migration{s, v})
+	}
+	manifest = emptyUsesSDK.ReplaceAllString(manifest, "")
+	return manifest, migrated, skipped
+}
+
+var appTableHeader = regexp.MustCompile(`(?m)^[ \t]*\[app\][ \t]*(#.*)?$`)
+
+// addToAppTable adds the lines to the config's [app] table, which is
+// appended to the config if it has none.
+func addToAppTable(config string, lines []string) string {
+	added := strings.Join(lines, "\n")
+	if loc := appTableHeader.FindStringIndex(config); loc != nil {
+		return config[:loc[1]] + "\n" + added + config[loc[1]:]
+	}
+	if config != "" && !strings.HasSuffix(config, "\n") {
+		config += "\n"
+	}
+	if config != "" {
+		config += "\n"
+	}
+	return config + "[app]\n" + added + "\n"
+}
+
+// migrate moves the version and SDK levels of the app out of its manifest
+// and into the [app] table of its config.
+func migrate(arguments []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	manifestFilepath := fs.String("manifest", "AndroidManifest.xml", manifestDesc)
+	configFilepath := fs.String("config", defaultConfigFilepath, configDesc)
+	dryRun := fs.Bool("dry-run", false, "Print what would be moved without changing any file")
+	fs.Parse(arguments)
+
+	b, err := ioutil.ReadFile(*manifestFilepath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read manifest at '%v' due to error: %v\n", *manifestFilepath, err)
+		os.Exit(1)
+	}
+	c, err := loadConfig(*configFilepath, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not load config due to error: %v\n", err)
+		os.Exit(1)
+	}
+	manifest, migrated, skipped := migrateManifest(string(b))
+	for _, s := range skipped {
+		fmt.Fprintf(os.Stderr, "%v\n", yellow(fmt.Sprintf("left %v in the manifest, which config cannot hold", s)))
+	}
+	if len(migrated) == 0 {
+		fmt.Println("nothing in the manifest to migrate")
+		return
+	}
+
+	configured := c.app.values()
+	lines := make([]string, 0, len(migrated))
+	for _, m := range migrated {
+		v, ok := configured[m.setting.key]
+		switch {
+		case !ok:
+			lines = append(lines, m.tomlLine())
+		case v != m.value:
+			fmt.Fprintf(os.Stderr, "%v=%q in the manifest disagrees with %v under [app] in config, which must be resolved by hand\n", m.setting.attr, m.value, m.setting.key)
+			os.Exit(1)
+		}
+		fmt.Printf("%v=%q -> %v\n", m.setting.attr, m.value, m.tomlLine())
+	}
+	if *dryRun {
+		return
+	}
+
+	if len(lines) > 0 {
+		existing, err := ioutil.ReadFile(c.path)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "could not read config file at '%v' due to error: %v\n", c.path, err)
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(c.path, []byte(addToAppTable(string(existing), lines)), 0664); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write config file at '%v' due to error: %v\n", c.path, err)
+			os.Exit(1)
+		}
+	}
+	if err := ioutil.WriteFile(*manifestFilepath, []byte(manifest), 0664); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write manifest at '%v' due to error: %v\n", *manifestFilepath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("moved %v settings from %v to [app] in %v\n", len(migrated), *manifestFilepath, c.path)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// appData saves the app's private data from a device to a file, or restores
+// it from one, so that the app can be returned to a known state:
+//
+//	blade app-data save logged-in.tar
+//	blade app-data restore logged-in.tar
+//
+// Debuggable builds are copied as a tar archive with run-as, which needs no
+// confirmation on the device. Other builds can use adb backup with -backup,
+// which the user must confirm on the device and apps can opt out of.
+func appData(arguments []string) {
+	usage := "Usage: blade app-data save|restore [flags] <file>\n"
+	if len(arguments) < 1 || (arguments[0] != "save" && arguments[0] != "restore") {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var backup *bool
+	d, pkg, rest := appCommand("app-data "+arguments[0], arguments[1:], func(fs *flag.FlagSet) {
+		backup = fs.Bool("backup", false, "Use adb backup and restore, for builds that are not debuggable")
+	})
+	if len(rest) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	path := rest[0]
+
+	var err error
+	switch {
+	case arguments[0] == "save" && *backup:
+		err = d.run("backup", "-f", path, "-noapk", pkg)
+	case arguments[0] == "restore" && *backup:
+		err = d.run("restore", path)
+	case arguments[0] == "save":
+		err = saveAppData(d, pkg, path)
+	default:
+		err = restoreAppData(d, pkg, path)
+	}
+	if err != nil {
+		exitWithError(fmt.Errorf("could not %v data of '%v' due to error: %v", arguments[0], pkg, err))
+	}
+	fmt.Printf("%vd data of %v\n", arguments[0], pkg)
+}
+
+// saveAppData writes a tar archive of the app's data directory to path.
+func saveAppData(d device, pkg, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// exec-out, unlike shell, does not mangle binary output with a pty.
+	err = d.pipe(nil, f, "exec-out", "run-as", pkg, "tar", "-cf", "-", ".")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// restoreAppData replaces the app's data directory with the tar archive at
+// path, stopping the app first so that it does not write over it.
+func restoreAppData(d device, pkg, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := d.adb("shell", "am", "force-stop", pkg); err != nil {
+		return err
+	}
+	// Data from after the archive was saved must not survive the restore.
+	if _, err := d.adb("shell", "run-as", pkg, "sh", "-c", "'rm -rf ./* ./.[!.]*'"); err != nil {
+		return err
+	}
+	return d.pipe(f, nil, "exec-in", "run-as", pkg, "tar", "-xf", "-")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	defaultArchiveRoot = "archive"
+	defaultArchivePath = "{application_id}/{version_name}-{version_code}-{variant}-{date}"
+)
+
+// archiveConfig is where builds run with -archive are kept, declared in
+// blade.toml as:
+//
+//	[archive]
+//	root = "/srv/builds"
+//	path = "{application_id}/{version_name}-{version_code}-{variant}-{date}"
+//	zip = true
+//
+// Each build is archived under root in a directory, or zip file if zip is
+// true, at path as templated in template.go, by default named after the app
+// and stamped with its version, variant and time, such as
+// com.example.app/1.4.0-12-release-20240501-153000, which keeps a local
+// history of the builds released from a machine.
+type archiveConfig struct {
+	// root is resolved against the config file's directory, and is by
+	// default the archive directory beside it.
+	root string
+	path string
+	zip  bool
+}
+
+func newArchiveConfig(c *config, t map[string]interface{}) (archiveConfig, error) {
+	a := archiveConfig{}
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid [archive] config: %v", err)
+	}
+	var err error
+	if a.root, err = stringValue(t, "root"); err != nil {
+		return a, wrap(err)
+	}
+	if a.root != "" {
+		a.root = c.resolve(a.root)
+	}
+	if a.path, err = stringValue(t, "path"); err != nil {
+		return a, wrap(err)
+	}
+	if a.path == "" {
+		a.path = defaultArchivePath
+	}
+	if a.zip, err = boolValue(t, "zip"); err != nil {
+		return a, wrap(err)
+	}
+	return a, nil
+}
+
+// archive copies the artifacts of the finished build, and the report at
+// report if not empty, to a new entry of the archive, returning its path.
+func (b *build) archive(report string) (string, error) {
+	name, err := b.expand("path under [archive] in config", b.config.archive.path, nil)
+	if err != nil {
+		return "", err
+	}
+	root := b.config.archive.root
+	if root == "" {
+		root = b.config.resolve(defaultArchiveRoot)
+	}
+	p := filepath.Join(root, name)
+	files := b.artifacts()
+	if report != "" {
+		files = append(files, report)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0774); err != nil {
+		return "", fmt.Errorf("could not create archive directory due to error: %v", err)
+	}
+	if b.config.archive.zip {
+		p += ".zip"
+		return p, writeArchiveZip(p, files)
+	}
+	for _, f := range files {
+		if err := copyTree(f, filepath.Join(p, filepath.Base(f)), nil); err != nil {
+			return "", err
+		}
+	}
+	return p, nil
+}
+
+// writeArchiveZip writes files to a new zip file at path, by their names.
+func writeArchiveZip(path string, files []string) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0664)
+	if err != nil {
+		return fmt.Errorf("could not create archive '%v' due to error: %v", path, err)
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	for _, f := range files {
+		in, err := os.Open(f)
+		if err != nil {
+			return fmt.Errorf("could not read '%v' to archive due to error: %v", f, err)
+		}
+		info, err := in.Stat()
+		var h *zip.FileHeader
+		if err == nil {
+			h, err = zip.FileInfoHeader(info)
+		}
+		var e io.Writer
+		if err == nil {
+			h.Method = zip.Deflate
+			e, err = w.CreateHeader(h)
+		}
+		if err == nil {
+			_, err = io.Copy(e, in)
+		}
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("could not archive '%v' due to error: %v", f, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("could not write archive '%v' due to error: %v", path, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aoeu/blade/internal/androidxml"
+)
+
+// badging holds the release properties of an APK, read from its compiled
+// manifest and the native libraries it packages.
+type badging struct {
+	Package     string   `json:"package"`
+	VersionCode string   `json:"versionCode"`
+	VersionName string   `json:"versionName"`
+	MinSDK      string   `json:"minSdk"`
+	TargetSDK   string   `json:"targetSdk"`
+	Debuggable  bool     `json:"debuggable"`
+	Permissions []string `json:"permissions"`
+	Activities  []string `json:"activities"`
+	// LaunchableActivity is the activity the launcher starts, if any.
+	LaunchableActivity string   `json:"launchableActivity"`
+	Services           []string `json:"services"`
+	NativeCode         []string `json:"nativeCode"`
+}
+
+// readBadging reads the badging of the APK at p without aapt, by parsing
+// its AndroidManifest.xml natively.
+func readBadging(p string) (*badging, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not open APK '%v' due to error: %v", p, err)
+	}
+	defer zr.Close()
+	var root *androidxml.Element
+	abis := make(map[string]bool)
+	for _, f := range zr.File {
+		if ss := strings.Split(f.Name, "/"); len(ss) == 3 && ss[0] == "lib" && strings.HasSuffix(ss[2], ".so") {
+			abis[ss[1]] = true
+		}
+		if f.Name != "AndroidManifest.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("could not open manifest of '%v' due to error: %v", p, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read manifest of '%v' due to error: %v", p, err)
+		}
+		if root, err = androidxml.ParseBinary(b); err != nil {
+			return nil, fmt.Errorf("could not parse manifest of '%v' due to error: %v", p, err)
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no AndroidManifest.xml found in '%v', which may not be an APK", p)
+	}
+
+	g := &badging{
+		Package:     root.Attrs["package"],
+		VersionCode: root.Attrs["versionCode"],
+		VersionName: root.Attrs["versionName"],
+		Permissions: make([]string, 0),
+		Activities:  make([]string, 0),
+		Services:    make([]string, 0),
+		NativeCode:  make([]string, 0),
+	}
+	for _, e := range root.Find("uses-sdk") {
+		g.MinSDK, g.TargetSDK = e.Attrs["minSdkVersion"], e.Attrs["targetSdkVersion"]
+	}
+	for _, name := range []string{"uses-permission", "uses-permission-sdk-23"} {
+		for _, e := range root.Find(name) {
+			g.Permissions = append(g.Permissions, e.Attrs["name"])
+		}
+	}
+	for _, e := range root.Find("application") {
+		g.Debuggable = e.Attrs["debuggable"] == "true"
+	}
+	for _, name := range []string{"activity", "activity-alias"} {
+		for _, e := range root.Find("application", name) {
+			g.Activities = append(g.Activities, className(g.Package, e.Attrs["name"]))
+		}
+	}
+	g.LaunchableActivity = launchableActivity(root)
+	for _, e := range root.Find("application", "service") {
+		g.Services = append(g.Services, className(g.Package, e.Attrs["name"]))
+	}
+	for abi := range abis {
+		g.NativeCode = append(g.NativeCode, abi)
+	}
+	sort.Strings(g.Permissions)
+	sort.Strings(g.NativeCode)
+	return g, nil
+}
+
+// className returns the name of a component declared in the manifest of pkg,
+// which may be relative to the package.
+func className(pkg, name string) string {
+	if strings.HasPrefix(name, ".") {
+		return pkg + name
+	}
+	if !strings.Contains(name, ".") {
+		return pkg + "." + name
+	}
+	return name
+}
+
+// badgingCommand prints the package, version, SDK levels, permissions,
+// components and native ABIs of an APK, as text or as JSON for CI to
+// assert release properties with.
+func badgingCommand(arguments []string) {
+	fs := flag.NewFlagSet("badging", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the badging as JSON")
+	fs.Parse(arguments)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: blade badging [flags] <app.apk>\n")
+		os.Exit(2)
+	}
+	g, err := readBadging(fs.Arg(0))
+	if err != nil {
+		exitWithError(err)
+	}
+	if *asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(g); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+	fmt.Printf("package: %v\n", g.Package)
+	fmt.Printf("versionCode: %v\n", g.VersionCode)
+	fmt.Printf("versionName: %v\n", g.VersionName)
+	fmt.Printf("minSdk: %v\n", g.MinSDK)
+	fmt.Printf("targetSdk: %v\n", g.TargetSDK)
+	fmt.Printf("debuggable: %v\n", g.Debuggable)
+	if g.LaunchableActivity != "" {
+		fmt.Printf("launchable-activity: %v\n", g.LaunchableActivity)
+	}
+	for _, l := range []struct {
+		name  string
+		items []string
+	}{
+		{"permissions", g.Permissions},
+		{"activities", g.Activities},
+		{"services", g.Services},
+		{"native-code", g.NativeCode},
+	} {
+		fmt.Printf("%v:", l.name)
+		if len(l.items) == 0 {
+			fmt.Printf(" (none)")
+		}
+		fmt.Println()
+		for _, item := range l.items {
+			fmt.Printf("  %v\n", item)
+		}
+	}
+}
@@ -1,174 +1,428 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	outputDirForGeneratedSourceFiles = "generated_java_sources"
+	outputDirForGeneratedProtoFiles  = "generated_proto_sources"
 	outputDirForBytecode             = "java_virtual_machine_bytecode"
 	outputDexFilepath                = "classes.dex"
-	filepathOfAPK                    = "app.apk"
-	filepathOfUnalignedAPK           = "app.apk.unaligned"
+	defaultOutputName                = "app"
 )
 
 // Descriptions of flags with corresponding names:
 const (
-	sdkDesc      = "The location of the Android SDK to use in lieu of the environment variable $ANDROID_HOME (default)"
-	manifestDesc = "The location of the AndroidManifest.xml of the app to build in lieu of the current directory"
-	xmlDesc      = "The parent-folder location of XML resources files (commonly named 'res') for the app to be bulit with"
-	javaDesc     = "The parent-folder location Java source files for the app to be built with"
-	outDesc      = "The directory to output temporary built artifacts and final APK file, in lieu of the current directory"
+	sdkDesc       = "The location of the Android SDK to use in lieu of the environment variable $ANDROID_HOME (default)"
+	manifestDesc  = "The location of the AndroidManifest.xml of the app to build in lieu of the current directory"
+	xmlDesc       = "The parent-folder location of XML resources files (commonly named 'res') for the app to be bulit with"
+	javaDesc      = "The parent-folder location Java source files for the app to be built with"
+	outDesc       = "The directory to output temporary built artifacts and final APK file, in lieu of the current directory"
+	releaseDesc   = "The Java language release to compile, against the platform's Java APIs rather than the JDK's (e.g. 8, 11, 17, of which releases after 8 need platforms;android-30 or later)"
+	protoDesc     = "The parent-folder location of .proto files to generate Java lite sources from with protoc, if any"
+	protobufDesc  = "The location of the protobuf-javalite runtime jar to compile and dex the generated protobuf sources against"
+	configDesc    = "The location of the blade.toml config file declaring additional build settings, if any"
+	variantDesc   = "The build variant to build, either debug, release, or one declared in config as [variant.<name>]"
+	renameDesc    = "The package name to give the built app in lieu of the manifest's package and any variant's application_id_suffix"
+	densityDesc   = "A comma-separated list of screen densities (e.g. mdpi,hdpi,xhdpi) to additionally build an APK for each of"
+	remoteDesc    = "The [user@]host to compile, dex and package on over SSH, which must have the SDK and JDK at the same paths as locally"
+	containerDesc = "The Docker or Podman image to run every build tool in, in which case -sdk is a path inside the image (default $ANDROID_HOME of the image)"
+	googleDesc    = "The location of the google-services.json file to generate Firebase resources from (default one beside the manifest, if any)"
+	zopfliDesc    = "Recompress the APK's deflated entries with zopfli for a few percent smaller downloads, which is slow so best left for release builds"
+	signSumsDesc  = "Sign the checksums.txt written beside the APKs with either gpg (to checksums.txt.asc) or sigstore (to checksums.txt.sigstore.json, using cosign)"
+	rawDesc       = "The parent-folder location of files to package at the root of the APK as they are, such as kotlin/ metadata or META-INF/ descriptors, if any"
+	prebuiltDesc  = "A comma-separated list of prebuilt .dex files, or jars of them, to merge into the app's dex as they are; without a -java directory the app is packaged from these alone"
+	nativeDesc    = "Compress native libraries in the APK for installs to extract, as for a minSdk below 23, in lieu of storing them uncompressed and page-aligned for the platform to load from the APK"
+	sizeDesc      = "Fail the build if any APK grew by more than this, such as 5% or 100KB, since the last build of the variant recorded in the size history of the output directory"
+	schemesDesc   = "A comma-separated list of the APK signature schemes, of v1, v2, v3 and v4, to sign with the debug key by, where v4 writes a signature for incremental installs beside each APK as <apk>.idsig"
+	publishDesc   = "Upload the APKs, metadata, build info and checksums with each publisher declared as [[publish]] in config once the build succeeds"
+	colorDesc     = "Whether to color diagnostics: auto (when writing to a terminal and NO_COLOR is unset), always, or never"
+	jobsDesc      = "The number of independent stages of the build to run at once"
+	archiveDesc   = "Copy the APKs, metadata, build info, checksums and any report into a new entry of the archive declared as [archive] in config once the build succeeds"
+	reportDesc    = "The location to write an HTML report of the build to, covering stage timings, diagnostics, APK sizes and dependencies, whether or not the build succeeds, which may be templated with {variant}, {date} and the like"
+	deviceDesc    = "The serial of the device to run on, as listed by `adb devices`, or the name given to it with `blade connect -name` (default $ANDROID_SERIAL, or the only device connected)"
+	grantDesc     = "Grant the app every runtime permission it requests once installed, so that permission dialogs do not interrupt it"
+	keystoreDesc  = "The keystore to sign APKs with the debug key from (default $BLADE_DEBUG_KEYSTORE, debug_keystore in config, then debug.keystore in $ANDROID_USER_HOME or $HOME/.android)"
+	packageDesc   = "The package of the app on the device, in lieu of the application ID of the build described by the build flags"
 )
 
-func main() {
-	args := struct {
-		androidHome             string
-		androidManifestFilepath string
-		xmlResourcesFilepath    string
-		javaSourcesFilepath     string
-		outputDir               string
-	}{}
-	flag.StringVar(&args.androidHome, "sdk", "", sdkDesc)
-	flag.StringVar(&args.androidManifestFilepath, "manifest", "AndroidManifest.xml", manifestDesc)
-	flag.StringVar(&args.xmlResourcesFilepath, "xml", "xml", xmlDesc)
-	flag.StringVar(&args.javaSourcesFilepath, "java", "java", javaDesc)
-	flag.StringVar(&args.outputDir, "out", "", outDesc)
-	flag.Parse()
-	fmt.Println("aoeu", args.javaSourcesFilepath, args.xmlResourcesFilepath)
-	if args.androidHome == "" {
+// buildArgs holds the flags that describe how to build the app, which are
+// shared by the build itself and subcommands that inspect it.
+type buildArgs struct {
+	androidHome             string
+	androidManifestFilepath string
+	xmlResourcesFilepath    string
+	javaSourcesFilepath     string
+	outputDir               string
+	javaRelease             string
+	protoSourcesFilepath    string
+	protobufRuntimeFilepath string
+	configFilepath          string
+	explicitConfig          bool
+	variant                 string
+	renameManifestPackage   string
+	densitySplits           string
+	remote                  string
+	container               string
+	googleServicesFilepath  string
+	rawFilesFilepath        string
+	prebuiltDex             string
+	legacyNativePackaging   bool
+	keystore                string
+	signSchemes             string
+	zopfli                  bool
+	signChecksums           string
+	publish                 bool
+	failOnSizeIncrease      string
+	// arguments are those the flags were parsed from.
+	arguments []string
+}
+
+func (args *buildArgs) register(fs *flag.FlagSet) {
+	fs.StringVar(&args.androidHome, "sdk", "", sdkDesc)
+	fs.StringVar(&args.androidManifestFilepath, "manifest", "AndroidManifest.xml", manifestDesc)
+	fs.StringVar(&args.xmlResourcesFilepath, "xml", "xml", xmlDesc)
+	fs.StringVar(&args.javaSourcesFilepath, "java", "java", javaDesc)
+	fs.StringVar(&args.outputDir, "out", "", outDesc)
+	fs.StringVar(&args.javaRelease, "java-release", "8", releaseDesc)
+	fs.StringVar(&args.protoSourcesFilepath, "proto", "", protoDesc)
+	fs.StringVar(&args.protobufRuntimeFilepath, "protobuf-runtime", "", protobufDesc)
+	fs.StringVar(&args.configFilepath, "config", defaultConfigFilepath, configDesc)
+	fs.StringVar(&args.variant, "variant", "debug", variantDesc)
+	fs.StringVar(&args.renameManifestPackage, "rename-manifest-package", "", renameDesc)
+	fs.StringVar(&args.densitySplits, "density-splits", "", densityDesc)
+	fs.StringVar(&args.remote, "remote", "", remoteDesc)
+	fs.StringVar(&args.container, "container", "", containerDesc)
+	fs.StringVar(&args.googleServicesFilepath, "google-services", "", googleDesc)
+	fs.StringVar(&args.rawFilesFilepath, "raw", "", rawDesc)
+	fs.StringVar(&args.prebuiltDex, "prebuilt-dex", "", prebuiltDesc)
+	fs.StringVar(&args.keystore, "keystore", "", keystoreDesc)
+	fs.StringVar(&args.signSchemes, "sign-schemes", defaultSignSchemes, schemesDesc)
+	fs.BoolVar(&args.zopfli, "zopfli", false, zopfliDesc)
+	fs.BoolVar(&args.legacyNativePackaging, "legacy-native-packaging", false, nativeDesc)
+	fs.StringVar(&args.signChecksums, "sign-checksums", "", signSumsDesc)
+	fs.BoolVar(&args.publish, "publish", false, publishDesc)
+	fs.StringVar(&args.failOnSizeIncrease, "fail-on-size-increase", "", sizeDesc)
+}
+
+// parse parses the flags in fs, which must have been registered with
+// register, from arguments.
+func (args *buildArgs) parse(fs *flag.FlagSet, arguments []string) {
+	fs.Parse(arguments)
+	args.arguments = arguments
+	fs.Visit(func(f *flag.Flag) { args.explicitConfig = args.explicitConfig || f.Name == "config" })
+}
+
+// validate checks that the flags are usable together, and resolves the SDK
+// location from the environment if it was not provided.
+func (args *buildArgs) validate() error {
+	if args.remote != "" && args.container != "" {
+		return withCode(errInvalidFlags, fmt.Errorf("a build can either run remotely or in a container, but not both"))
+	}
+	if _, ok := checksumSigners[args.signChecksums]; args.signChecksums != "" && !ok {
+		return withCode(errInvalidFlags, fmt.Errorf("checksums can be signed with either gpg or sigstore, not '%v'", args.signChecksums))
+	}
+	if _, err := javaReleaseVersion(args.javaRelease); args.javaRelease != "" && err != nil {
+		return withCode(errInvalidFlags, err)
+	}
+	if err := validateSignSchemes(args.signSchemes); err != nil {
+		return withCode(errInvalidFlags, err)
+	}
+	if _, err := parseSizeLimit(args.failOnSizeIncrease); err != nil {
+		return withCode(errInvalidFlags, err)
+	}
+	if args.androidHome == "" && args.container == "" {
 		var envExists bool
 		args.androidHome, envExists = os.LookupEnv("ANDROID_HOME")
 		switch {
 		case !envExists:
-			fmt.Fprintf(os.Stderr, "ANDROID_HOME must be set as an environment variable or the SDK location must be provided manually as a flag\n")
-			flag.Usage()
-			os.Exit(1)
+			return withCode(errSDKNotFound, fmt.Errorf("ANDROID_HOME must be set as an environment variable or the SDK location must be provided manually as a flag"))
 		case args.androidHome == "":
-			fmt.Fprintf(os.Stderr, "ANDROID_HOME is set as an empty enviroment variable and must be non-empty, or the SDK location must be provided manually as a flag\n")
-			flag.Usage()
-			os.Exit(1)
+			return withCode(errSDKNotFound, fmt.Errorf("ANDROID_HOME is set as an empty enviroment variable and must be non-empty, or the SDK location must be provided manually as a flag"))
 		}
 	}
-	p, err := filepath.Abs(args.androidManifestFilepath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not find AndroidManifest.xml at filepath '%v' due to error: '%v'\n", args.androidManifestFilepath, err)
-		os.Exit(1)
-	} else {
-		args.androidManifestFilepath = p
-	}
+	return nil
+}
 
-	p, err = filepath.Abs(args.outputDir)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not locate output directory at filepath '%v' due to error: %v\n", args.outputDir, err)
-		os.Exit(1)
-	} else {
-		args.outputDir = p
+func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands()[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
 	}
 
-	home, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not locate user's home directory to find signing keystore: %v", err)
-		os.Exit(1)
+	args := &buildArgs{}
+	args.register(flag.CommandLine)
+	jobs := flag.Int("j", runtime.NumCPU(), jobsDesc)
+	color := registerColor(flag.CommandLine)
+	report := flag.String("report", "", reportDesc)
+	archive := flag.Bool("archive", false, archiveDesc)
+	args.parse(flag.CommandLine, os.Args[1:])
+	if flag.NArg() > 0 {
+		runPlugin(args, flag.Arg(0), flag.Args()[1:])
+		return
 	}
-	keystorePath := fmt.Sprintf("%v/.android/debug.keystore", home)
-	info, err := os.Stat(keystorePath)
-	switch {
-	case err != nil:
-		fmt.Fprintf(os.Stderr, "could not find signing keystore: '%v'", err)
-		fmt.Fprintln(os.Stderr, keystoreCreationCmd)
-		os.Exit(1)
-	case info.IsDir():
-		fmt.Fprintf(os.Stderr, "expected signing keystore file at '%v' but was directory", keystorePath)
-		fmt.Fprintln(os.Stderr, keystoreCreationCmd)
-		os.Exit(1)
-	case info.Size() == 0:
-		fmt.Fprintf(os.Stderr, "signing keystore file at '%v' is empty", keystorePath )
-		fmt.Fprintln(os.Stderr, keystoreCreationCmd)
+	fmt.Println("aoeu", args.javaSourcesFilepath, args.xmlResourcesFilepath)
+	applyColor(flag.CommandLine, *color)
+	if err := args.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", red(err.Error()))
+		flag.Usage()
+		fmt.Fprint(os.Stderr, explainHint(err))
 		os.Exit(1)
 	}
 
-	t, err := newToolchain(args.androidHome)
+	b, err := newBuild(args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not ascertain toolchain due to error: %v\n", err)
-		os.Exit(1)
+		exitWithError(err)
 	}
-	tmpDirs := []string{outputDirForGeneratedSourceFiles, outputDirForBytecode}
-	if err := makeOutputDirs(tmpDirs...); err != nil {
-		fmt.Fprintf(os.Stderr, "could not create output directories due to error: %v\n", err)
-		os.Exit(1)
+	if *report != "" {
+		if *report, err = b.expand("-report", *report, nil); err != nil {
+			exitWithError(err)
+		}
+		*report = absPath(*report)
+	}
+	if err := b.preflight(); err != nil {
+		exitWithError(err)
+	}
+	if err := b.initToolchain(); err != nil {
+		exitWithError(err)
+	}
+	if args.remote != "" {
+		// Mirroring outputs back from the remote host would overwrite
+		// whatever stages running locally at the same time had written.
+		*jobs = 1
+	}
+	ss := b.stages()
+	p := newProgress(os.Stderr, len(ss))
+	st := newBuildStats(args.variant)
+	r := newBuildReport()
+	err = runStages(ss, *jobs, func(s *stage) error {
+		p.started(s.name)
+		start := time.Now()
+		var output bytes.Buffer
+		skipped, err := b.runIncrementally(s, b.toolchain.withOutput(&output))
+		st.record(s, start, skipped)
+		p.finished(s.name, skipped, output.Bytes(), err)
+		r.add(s.name, output.Bytes(), err)
+		if err != nil {
+			if werr := writeLastFailure(args.outputDir, s.name, output.Bytes(), err); werr != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", yellow(werr.Error()))
+			}
+		}
+		return err
+	})
+	p.close()
+	if err := st.append(args.outputDir, err); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", yellow(err.Error()))
 	}
-	if err = t.generateJavaFileForAndroidResources(args.outputDir+"/"+outputDirForGeneratedSourceFiles, args.androidManifestFilepath, args.xmlResourcesFilepath); err != nil {
-		fmt.Fprintf(os.Stderr, "could not create Java file from Android XML resources files due to error: %v\n", err)
-		os.Exit(1)
+	if *report != "" {
+		if err := r.write(*report, b, st, err); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", yellow(err.Error()))
+		}
 	}
-
-	err = t.compileJavaSourceFilesToJavaVirtualMachineBytecode(args.javaSourcesFilepath, outputDirForGeneratedSourceFiles, outputDirForBytecode)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not compile java source files to bytecode due to error: %v\n", err)
-		os.Exit(1)
+		exitWithError(err)
 	}
 
-	err = t.translateJavaVirtualMachineMBytecodeToAndroidRuntimeBytecode(outputDexFilepath, outputDirForBytecode)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not translate bytecode with dexer due to error: %v\n", err)
-		os.Exit(1)
+	remove(b.tmpFiles...)
+
+	if *archive {
+		p, err := b.archive(*report)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf("archived build to %v\n", p)
 	}
 
-	err = t.createUnalignedAndroidApplicationPackage(args.androidManifestFilepath, args.xmlResourcesFilepath, filepathOfUnalignedAPK)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not create unaligned APK file due to error: %v\n", err)
-		os.Exit(1)
+	if b.config.cache.isSet() {
+		dirs, err := cacheDirs(args.outputDir)
+		if err == nil {
+			_, _, err = b.config.cache.gc(dirs, false)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", yellow(fmt.Sprintf("could not apply the cache policy due to error: %v", err)))
+		}
 	}
+}
 
-	err = t.addAndroidRuntimeBytecodeToAndroidApplicationPackage(filepathOfUnalignedAPK, outputDexFilepath)
+// exitWithError prints err, and how to find out more about it if it has an
+// error code, before exiting.
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "%v\n%v", red(err.Error()), explainHint(err))
+	os.Exit(1)
+}
+
+// initToolchain checks for a signing keystore, enters the output directory,
+// finds the tools to run the build's stages with, and creates the
+// directories the stages write to.
+func (b *build) initToolchain() error {
+	args := b.args
+	keystorePath, source, err := debugKeystore(args, b.config)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not add android runtime bytecode to APK due to error: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	info, err := os.Stat(keystorePath)
+	switch {
+	case len(b.variant.signCommand) > 0:
+		// The debug key is not needed when the variant signs APKs itself.
+	case err != nil:
+		return withCode(errKeystoreNotFound, fmt.Errorf("could not find signing keystore chosen by %v: '%v'%v", source, err, fmt.Sprintf(keystoreCreationCmd, keystorePath)))
+	case info.IsDir():
+		return withCode(errKeystoreNotFound, fmt.Errorf("expected signing keystore file at '%v' but was directory%v", keystorePath, fmt.Sprintf(keystoreCreationCmd, keystorePath)))
+	case info.Size() == 0:
+		return withCode(errKeystoreNotFound, fmt.Errorf("signing keystore file at '%v' is empty%v", keystorePath, fmt.Sprintf(keystoreCreationCmd, keystorePath)))
+	default:
+		fmt.Fprintf(os.Stderr, "signing with debug keystore %v, as chosen by %v\n", keystorePath, source)
 	}
 
-	err = t.signAndroidApplicationPackageWithDebugKey(filepathOfUnalignedAPK)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not sign APK due to error: %v\n", err)
-		os.Exit(1)
+	// Stages write to paths relative to the working directory, so nothing is
+	// written next to the sources unless they are the output directory.
+	if err := os.MkdirAll(args.outputDir, 0774); err != nil {
+		return fmt.Errorf("could not create output directory '%v' due to error: %v", args.outputDir, err)
+	}
+	if err := os.Chdir(args.outputDir); err != nil {
+		return fmt.Errorf("could not enter output directory '%v' due to error: %v", args.outputDir, err)
 	}
 
-	err = t.alignUncompressedDataInZipFileToFourByteBoundariesForFasterMemoryMappingAtRuntime(filepathOfUnalignedAPK, filepathOfAPK)
+	var c *container
+	if args.container != "" {
+		dirs, err := b.toolDirs()
+		if err != nil {
+			return err
+		}
+		if c, err = newContainer(args.container, append(dirs, filepath.Dir(keystorePath))); err != nil {
+			return withCode(errRemoteOrContainer, err)
+		}
+		if args.androidHome == "" {
+			if args.androidHome, err = c.env("ANDROID_HOME"); err != nil {
+				return withCode(errRemoteOrContainer, err)
+			}
+		}
+	}
+	b.toolchain, err = newToolchain(args.androidHome, c)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could align bytes of APK file due to error: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("could not ascertain toolchain due to error: %v", err)
 	}
-
-	remove(append([]string{outputDexFilepath, filepathOfUnalignedAPK}, tmpDirs...)...)
+	b.toolchain.useTools(b.config.tools, b.config.isolateJavaOptions)
+	b.toolchain.keystore = keystorePath
+	if args.remote != "" {
+		dirs, err := b.toolDirs()
+		if err != nil {
+			return err
+		}
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("could not determine working directory due to error: %v", err)
+		}
+		outputDirs := []string{wd, args.outputDir}
+		if b.config.room.schemaDir != "" {
+			outputDirs = append(outputDirs, b.config.room.schemaDir)
+		}
+		b.toolchain.remote = newRemote(args.remote, dirs, outputDirs)
+		if b.config.isolateJavaOptions {
+			b.toolchain.remote.unset = javaOptionsVariables
+		}
+	}
+	if err := makeOutputDirs(b.intermediateDirs...); err != nil {
+		return fmt.Errorf("could not create output directories due to error: %v", err)
+	}
+	return nil
 }
 
 func (t toolchain) alignUncompressedDataInZipFileToFourByteBoundariesForFasterMemoryMappingAtRuntime(filepathOfUnalignedAPK, filepathOfAPK string) error {
-	return t.run(fmt.Sprintf("%v -f 4 %v %v", t.buildTools+"/zipalign", filepathOfUnalignedAPK, filepathOfAPK))
+	// Shared libraries stored uncompressed are page-aligned, for the platform
+	// to map them from the APK.
+	return t.run(t.zipalignBin(), "-f", "-p", "4", filepathOfUnalignedAPK, filepathOfAPK)
 }
 
-func (t toolchain) signAndroidApplicationPackageWithDebugKey(filepathOfUnalignedAPK string) error {
+// signAndroidApplicationPackageWithDebugKey signs the aligned APK at
+// filepathOfAlignedAPK to filepathOfAPK by each of schemes, as apksigner
+// signs the whole file from v2 on and so must run after zipalign. v4 writes
+// its signature beside the APK, as <apk>.idsig.
+//
+// apksigner rejects the options of schemes newer than itself, so those are
+// only given to the build-tools that have them. Of the default schemes, v3 is
+// left out before build-tools 28, while schemes given with -sign-schemes that
+// the build-tools cannot sign by fail.
+func (t toolchain) signAndroidApplicationPackageWithDebugKey(filepathOfAlignedAPK, filepathOfAPK string, schemes []string) error {
 	// keytool -genkey -v -keystore debug.keystore -alias androiddebugkey -keyalg RSA -keysize 2048 -validity 10000 && mv debug.keystore $HOME/.android/
-	return t.run(fmt.Sprintf("jarsigner -keystore %v/.android/debug.keystore -storepass android %v androiddebugkey", os.Getenv("HOME"), filepathOfUnalignedAPK))
+	args := append([]string{t.apksignerBin(), "sign"}, t.toolArgs("apksigner")...)
+	args = append(args, "--ks", t.keystore, "--ks-pass", "pass:android", "--ks-key-alias", "androiddebugkey")
+	version := t.apksignerVersion()
+	for _, s := range signatureSchemes {
+		enabled := false
+		for _, ss := range schemes {
+			enabled = enabled || s == ss
+		}
+		if since := signatureSchemeBuildTools[s]; version > 0 && version < since {
+			if enabled && strings.Join(schemes, ",") != defaultSignSchemes {
+				return fmt.Errorf("signature scheme %v needs the apksigner of build-tools %v or later, but that of build-tools %v is installed", s, since, version)
+			}
+			continue
+		}
+		args = append(args, fmt.Sprintf("--%v-signing-enabled", s), strconv.FormatBool(enabled))
+	}
+	return t.run(append(args, "--out", filepathOfAPK, filepathOfAlignedAPK)...)
+}
+
+// signatureSchemes are the APK signature schemes apksigner signs by.
+var signatureSchemes = []string{"v1", "v2", "v3", "v4"}
+
+// signatureSchemeBuildTools are the major versions of build-tools whose
+// apksigner first signs by each scheme newer than v2, and so first accepts
+// its --vN-signing-enabled option.
+var signatureSchemeBuildTools = map[string]int{"v3": 28, "v4": 30}
+
+// apksignerVersion returns the major version of the build-tools apksigner is
+// from, as named by the directory it is in, or 0 if that is not a version,
+// such as for an apksigner configured under [tools].
+func (t toolchain) apksignerVersion() int {
+	dir := filepath.Base(filepath.Dir(t.apksignerBin()))
+	v, err := strconv.Atoi(strings.SplitN(dir, ".", 2)[0])
+	if err != nil {
+		return 0
+	}
+	return v
 }
 
 func (t toolchain) addAndroidRuntimeBytecodeToAndroidApplicationPackage(filepathOfUnalignedAPK, outputDexFilepath string) error {
-	return t.run(fmt.Sprintf("%v add %v %v", t.aaptBin, filepathOfUnalignedAPK, outputDexFilepath))
+	return t.runRemotable(t.aaptBin, "add", filepathOfUnalignedAPK, outputDexFilepath)
 }
 
-func (t toolchain) createUnalignedAndroidApplicationPackage(androidManifestFilepath, xmlResourcesFilepath, filepathOfUnalignedAPK string) error {
-	return t.run(fmt.Sprintf("%v package -f -M %v -S %v -I %v -F %v", t.aaptBin, androidManifestFilepath, xmlResourcesFilepath, t.androidLib, filepathOfUnalignedAPK))
+func (t toolchain) createUnalignedAndroidApplicationPackage(androidManifestFilepath string, resourceDirs []string, packageName string, extraArgs []string, filepathOfUnalignedAPK, rawFilesDir string) error {
+	args := append([]string{t.aaptBin, "package", "-f", "-M", androidManifestFilepath}, resourceDirArgs(resourceDirs)...)
+	args = append(args, "-I", t.androidLib)
+	if packageName != "" {
+		// Only the packaged manifest is renamed, so R.java and the app's
+		// classes keep the package name they were compiled with.
+		args = append(args, "--rename-manifest-package", packageName)
+	}
+	args = append(append(args, extraArgs...), "-F", filepathOfUnalignedAPK)
+	if rawFilesDir != "" {
+		// The raw files directory is aapt's only positional argument.
+		args = append(args, rawFilesDir)
+	}
+	return t.runRemotable(args...)
 
 }
 
 var classFilename = regexp.MustCompile(`.*\.class$`)
 
-func (t toolchain) translateJavaVirtualMachineMBytecodeToAndroidRuntimeBytecode(outputDexFilepath, outputDirForBytecode string) error {
+func (t toolchain) translateJavaVirtualMachineMBytecodeToAndroidRuntimeBytecode(outputDexFilepath, outputDirForBytecode string, libraries, prebuiltDex []string, extraArgs []string) error {
 	classFiles := make([]string, 0)
 	err := filepath.Walk(outputDirForBytecode, func(path string, info os.FileInfo, err error) error {
 		switch {
@@ -184,21 +438,69 @@ func (t toolchain) translateJavaVirtualMachineMBytecodeToAndroidRuntimeBytecode(
 		s := "could not walk dir '%v' for a list of class files due to error: %v"
 		return fmt.Errorf(s, outputDirForBytecode, err)
 	}
-	s := strings.Join(classFiles, " ")
-	return t.run(fmt.Sprintf("%v %v", t.d8Bin, s))
+	inputs, err := argfileIfTooLong(d8Argfile, append(append(classFiles, prebuiltDex...), libraries...))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(d8Argfile)
+	// D8 needs android.jar as a library to desugar language features newer
+	// than Java 8 (e.g. default and static interface methods, nest-based access).
+	args := append(append([]string{t.d8Bin}, t.toolArgs("d8")...), extraArgs...)
+	return t.runRemotable(append(append(args, "--lib", t.androidLib), inputs...)...)
 }
 
-func (t toolchain) compileJavaSourceFilesToJavaVirtualMachineBytecode(javaSourcesFilepath, outputDirForGeneratedSourceFiles, outputDirForBytecode string) error {
-	j, err := findJavaSourceFiles(javaSourcesFilepath)
+func (t toolchain) compileJavaSourceFilesToJavaVirtualMachineBytecode(javaRelease string, javaSourceDirs []string, outputDirForBytecode string, libraries []string, extraArgs []string) error {
+	j := make([]string, 0)
+	for _, dir := range javaSourceDirs {
+		jj, err := findJavaSourceFiles(dir)
+		if err != nil {
+			return fmt.Errorf("could not find java source files to compile due to error: %v", err)
+		}
+		j = append(j, jj...)
+	}
+	javaFiles, err := argfileIfTooLong(javacArgfile, j)
 	if err != nil {
-		return fmt.Errorf("could not find java source files to compile due to error: %v", err)
+		return err
 	}
-	jj, err := findJavaSourceFiles(outputDirForGeneratedSourceFiles)
+	defer os.Remove(javacArgfile)
+	platformArgs, classpath, err := t.javacPlatformArgs(javaRelease)
 	if err != nil {
-		return fmt.Errorf("could not find java source files to compile due to error: %v", err)
+		return err
+	}
+	args := append(append([]string{t.bin("javac")}, t.toolArgs("javac")...), extraArgs...)
+	args = append(args, platformArgs...)
+	if classpath = append(classpath, libraries...); len(classpath) > 0 {
+		args = append(args, "-classpath", strings.Join(classpath, ":"))
 	}
-	javaFiles := strings.Join(append(j, jj...), " ")
-	return t.run(fmt.Sprintf("javac -classpath %v -sourcepath %v -d %v -target 1.8 -source 1.8 %v", t.androidLib, javaSourcesFilepath+":"+outputDirForGeneratedSourceFiles, outputDirForBytecode, javaFiles))
+	args = append(args, "-sourcepath", strings.Join(javaSourceDirs, ":"), "-d", outputDirForBytecode)
+	return t.runRemotable(append(args, javaFiles...)...)
+}
+
+var protoFilename = regexp.MustCompile(`.*\.proto$`)
+
+func (t toolchain) generateJavaFilesForProtocolBuffers(protoSourcesFilepath, outputDirForGeneratedSourceFiles string) error {
+	protoFiles := make([]string, 0)
+	err := filepath.Walk(protoSourcesFilepath, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir():
+		case protoFilename.MatchString(info.Name()):
+			protoFiles = append(protoFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		s := "could not walk dir '%v' for a list of proto files due to error: %v"
+		return fmt.Errorf(s, protoSourcesFilepath, err)
+	}
+	if len(protoFiles) == 0 {
+		return fmt.Errorf("no .proto files found under '%v'", protoSourcesFilepath)
+	}
+	// The "lite" option of protoc's built-in Java generator emits code for the
+	// protobuf-javalite runtime, which is the runtime recommended for Android.
+	args := []string{t.bin("protoc"), "--proto_path=" + protoSourcesFilepath, "--java_out=lite:" + outputDirForGeneratedSourceFiles}
+	return t.run(append(args, protoFiles...)...)
 }
 
 var javaFilename = regexp.MustCompile(`.*\.java$`)
@@ -222,7 +524,7 @@ func findJavaSourceFiles(rootDir string) ([]string, error) {
 	return paths, err
 }
 
-func (t toolchain) generateJavaFileForAndroidResources(outputDirForGeneratedSourceFiles, manifestFilepath, resourcesFilepath string) error {
+func (t toolchain) generateJavaFileForAndroidResources(outputDirForGeneratedSourceFiles, manifestFilepath string, resourceDirs []string) error {
 	// aapt package
 	//
 	//	Package the android resources.  It will read assets and resources that are
@@ -237,28 +539,99 @@ func (t toolchain) generateJavaFileForAndroidResources(outputDirForGeneratedSour
 	M := manifestFilepath
 	//	-S  directory in which to find resources.  Multiple directories will be scanned
 	//		and the first match found (left to right) will take precedence.
-	S := resourcesFilepath
+	S := resourceDirArgs(resourceDirs)
 	//	-I	add an existing package to base include set
 	I := t.androidLib
 	//
 	// aapt package -f -m -J "$outputDirForGeneratedSourceFiles" -M "$manifestFilepath" -S "$resourcesFilepath" -I "$androidLib"
-	return t.run(fmt.Sprintf("%v package -f -m -J %v -M %v -S %v -I %v", t.aaptBin, J, M, S, I))
+	args := append([]string{t.aaptBin, "package", "-f", "-m", "-J", J, "-M", M}, S...)
+	return t.runRemotable(append(args, "-I", I)...)
+
+}
 
+// resourceDirArgs returns the aapt arguments for scanning each of dirs for
+// resources, in order of precedence. Resources only found in the later
+// directories are added to the package rather than rejected as overlays.
+func resourceDirArgs(dirs []string) []string {
+	args := make([]string, 0, 2*len(dirs)+1)
+	for _, d := range dirs {
+		args = append(args, "-S", d)
+	}
+	if len(dirs) > 1 {
+		args = append(args, "--auto-add-overlay")
+	}
+	return args
 }
 
-func (t toolchain) run(command string) error {
-	s := strings.Split(spaces.ReplaceAllString(command, " "), " ")
+// run runs the command of args, each passed to it as is, so that paths may
+// have spaces in them.
+func (t toolchain) run(args ...string) error {
+	s := args
+	if t.container != nil {
+		s = t.container.command(s)
+	}
 	cmd := exec.Command(s[0], s[1:]...)
+	cmd.Env = t.environ()
 	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = t.stdout()
+	cmd.Stderr = t.stderr()
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error when running command %v : %v\n", command, err)
+		return fmt.Errorf("error when running command %v : %v\n", strings.Join(args, " "), err)
 	}
 	return nil
 }
 
-var spaces = regexp.MustCompile(`\s+`)
+// runRemotable runs the command of args like run, but on the remote host if the
+// toolchain has one, for commands heavy enough to be worth offloading.
+func (t toolchain) runRemotable(args ...string) error {
+	if t.remote != nil {
+		return t.remote.run(args, t.stdout(), t.stderr())
+	}
+	return t.run(args...)
+}
+
+const (
+	// maxArgsLength is kept well below the limits operating systems place on
+	// the length of a command, the lowest being 32767 characters on Windows.
+	maxArgsLength = 16384
+	javacArgfile  = "javac.args"
+	d8Argfile     = "d8.args"
+)
+
+// argfileIfTooLong returns args as they are, unless they would be too long
+// for a command, in which case they are written one per line to the file at
+// path and an @path argument to read them from is returned. Both javac and
+// d8 expand such arguments, though javac splits lines on whitespace, so its
+// arguments are quoted.
+func argfileIfTooLong(path string, args []string) ([]string, error) {
+	n := 0
+	for _, a := range args {
+		n += len(a) + 1
+	}
+	if n <= maxArgsLength {
+		return args, nil
+	}
+	lines := args
+	if path == javacArgfile {
+		lines = make([]string, len(args))
+		for i, a := range args {
+			lines[i] = javacArgfileQuote(a)
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0664); err != nil {
+		return nil, fmt.Errorf("could not write arguments to file '%v' due to error: %v", path, err)
+	}
+	return []string{"@" + path}, nil
+}
+
+// javacArgfileQuote quotes s for a javac @file if it has whitespace, quotes
+// or backslashes in it, which javac would otherwise take apart.
+func javacArgfileQuote(s string) string {
+	if !strings.ContainsAny(s, " \t\"'\\#") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
 
 func remove(paths ...string) error {
 	for _, s := range paths {
@@ -293,14 +666,46 @@ type toolchain struct {
 	androidLib string
 	aaptBin    string
 	d8Bin      string
+	remote     *remote
+	container  *container
+	// tools holds the configured arguments of tools, by name.
+	tools              map[string]tool
+	isolateJavaOptions bool
+	// keystore is the keystore to sign APKs with the debug key from.
+	keystore string
+	// w is what tools write their output to, in lieu of stdout and stderr.
+	w io.Writer
 }
 
-func newToolchain(SDKPath string) (*toolchain, error) {
-	t := &toolchain{}
+// withOutput returns a copy of the toolchain whose tools write to w.
+func (t *toolchain) withOutput(w io.Writer) *toolchain {
+	c := *t
+	c.w = w
+	return &c
+}
+
+func (t toolchain) stdout() io.Writer {
+	if t.w != nil {
+		return t.w
+	}
+	return os.Stdout
+}
+
+func (t toolchain) stderr() io.Writer {
+	if t.w != nil {
+		return t.w
+	}
+	return os.Stderr
+}
+
+// newToolchain finds the tools of the SDK at SDKPath, which is a path inside
+// the container if one is given.
+func newToolchain(SDKPath string, c *container) (*toolchain, error) {
+	t := &toolchain{container: c}
 	var err error
 	t.sdk, err = filepath.Abs(SDKPath)
 	if err != nil {
-		return t, fmt.Errorf("no valid directory has been found as $ANDROID_HOME due to error: %v", err)
+		return t, withCode(errSDKNotFound, fmt.Errorf("no valid directory has been found as $ANDROID_HOME due to error: %v", err))
 	}
 
 	p := t.sdk + "/tools"
@@ -322,11 +727,11 @@ $ ` + SDKPath + `/tools/bin/sdkmanager --install 'build-tools;28.0.3' 'platforms
 `
 
 	if err := t.initBuildTools(); err != nil {
-		return t, fmt.Errorf("%v\n%v", err, hint)
+		return t, withCode(errMissingBuildTools, fmt.Errorf("%v\n%v", err, hint))
 	}
 
 	if err := t.initPlatforms(); err != nil {
-		return t, fmt.Errorf("%v\n%v", err, hint)
+		return t, withCode(errMissingPlatform, fmt.Errorf("%v\n%v", err, hint))
 	}
 
 	return t, nil
@@ -338,19 +743,15 @@ func (t *toolchain) initBuildTools() (err error) {
 	if err != nil {
 		return fmt.Errorf("no build-tools directory found under '%v' due to error: %v", p, err)
 	}
-	d, err := os.Open(p)
-	if err != nil {
-		return fmt.Errorf("could not find build-tools found under '%v' due to error: %v", p, err)
-	}
-	ff, err := d.Readdir(0)
+	ff, err := t.readDirNames(p)
 	if err != nil {
 		return fmt.Errorf("could not read build-tools dir under '%v' due to error: %v", p, err)
 	}
 	if len(ff) < 1 {
-		return fmt.Errorf("no build tools found under '%v'", len(ff))
+		return fmt.Errorf("no build tools found under '%v'", p)
 	}
 	indexOfMostRecentBuildToolsVersion := len(ff) - 1
-	t.buildTools, err = filepath.Abs(p + "/" + ff[indexOfMostRecentBuildToolsVersion].Name())
+	t.buildTools, err = filepath.Abs(p + "/" + ff[indexOfMostRecentBuildToolsVersion])
 	if err != nil {
 		return fmt.Errorf("received error when selecting most modern build-tools version: '%v'", err)
 	}
@@ -369,6 +770,23 @@ func (t *toolchain) initBuildTools() (err error) {
 	return nil
 }
 
+// readDirNames returns the sorted names of the entries of the SDK directory
+// at path, which is inside the container when the toolchain has one.
+func (t *toolchain) readDirNames(path string) ([]string, error) {
+	if t.container != nil {
+		return t.container.readDirNames(path)
+	}
+	ff, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(ff))
+	for i, f := range ff {
+		names[i] = f.Name()
+	}
+	return names, nil
+}
+
 func (t *toolchain) initPlatforms() (err error) {
 	p := t.sdk + "/platforms"
 	_, err = filepath.Abs(p)
@@ -376,21 +794,16 @@ func (t *toolchain) initPlatforms() (err error) {
 		return fmt.Errorf("no valid platform found under '%v' due to error: %v", p, err)
 	}
 
-	d, err := os.Open(p)
-	if err != nil {
-		return fmt.Errorf("could not find platforms under '%v' due to error: %v", p, err)
-	}
-
-	ff, err := d.Readdir(0)
+	ff, err := t.readDirNames(p)
 	if err != nil {
 		return fmt.Errorf("could not find platforms under '%v' due to error: %v", p, err)
 	}
 	if len(ff) < 1 {
-		return fmt.Errorf("no contents found in platform dir found under '%v'", d.Name())
+		return fmt.Errorf("no contents found in platform dir found under '%v'", p)
 	}
 
 	indexOfMostRecentPlatformVersion := len(ff) - 1
-	t.platform, err = filepath.Abs(p + "/" + ff[indexOfMostRecentPlatformVersion].Name())
+	t.platform, err = filepath.Abs(p + "/" + ff[indexOfMostRecentPlatformVersion])
 	if err != nil {
 		return fmt.Errorf("received error when selecting most modern platform: '%v'", err)
 	}
@@ -403,13 +816,11 @@ func (t *toolchain) initPlatforms() (err error) {
 	return nil
 }
 
-
 const (
-
 	keystoreCreationCmd = `
 try (modifying if wanted and) executing:
 $ keytool -genkey -v \
-	-keystore $HOME/.android/debug.keystore \
+	-keystore %v \
 	-alias androiddebugkey \
 	-storepass android \
 	-keypass android \
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeTools are scripts standing in for the tools of the SDK and JDK, which
+// write just enough of what the real tools do for the stages after them.
+var fakeTools = map[string]string{
+	"sdk/build-tools/28.0.3/aapt": `prev=""
+for a; do
+  [ "$prev" = "-F" ] && echo apk > "$a"
+  [ "$prev" = "-J" ] && mkdir -p "$a/com/example/app" && echo "package com.example.app; class R {}" > "$a/com/example/app/R.java"
+  prev="$a"
+done`,
+	"sdk/build-tools/28.0.3/d8": `echo dex > classes.dex`,
+	"sdk/build-tools/28.0.3/zipalign": `for a; do :; done
+eval in=\${$(($#-1))}
+cp "$in" "$a"`,
+	// Like that of build-tools 28.0.3, apksigner rejects the options of
+	// v4, which it predates.
+	"sdk/build-tools/28.0.3/apksigner": `[ "$1" = sign ] || exit 2
+shift
+while [ $# -gt 1 ]; do
+  case "$1" in
+  --ks|--ks-pass|--ks-key-alias|--v1-signing-enabled|--v2-signing-enabled|--v3-signing-enabled) shift ;;
+  --out) out=$2; shift ;;
+  *) echo "Unsupported option: $1" >&2; exit 2 ;;
+  esac
+  shift
+done
+cp "$1" "$out"`,
+	"bin/javac": `prev=""
+for a; do [ "$prev" = "-d" ] && mkdir -p "$a" && echo class > "$a/MainActivity.class"; prev="$a"; done
+exit 0`,
+}
+
+// writeFiles writes each file of files, by path under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string, mode os.FileMode) {
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setenv sets the environment variable for the rest of the test.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// snapshot returns the paths, modes and modification times of the files
+// under dir.
+func snapshot(t *testing.T, dir string) string {
+	lines := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		lines = append(lines, path+" "+info.Mode().String()+" "+info.ModTime().String())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestBuildFromReadOnlyProject(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tools are shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "blade-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tools := make(map[string]string)
+	for name, script := range fakeTools {
+		tools[name] = "#!/bin/sh\n" + script + "\n"
+	}
+	writeFiles(t, dir, tools, 0755)
+	writeFiles(t, dir, map[string]string{
+		"home/.android/debug.keystore": "keystore",
+		"proj/AndroidManifest.xml": `<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.example.app" android:versionCode="1" android:versionName="1.0">
+    <uses-sdk android:minSdkVersion="21" android:targetSdkVersion="30" />
+    <application android:label="@string/app_name" />
+</manifest>
+`,
+		"proj/java/com/example/app/MainActivity.java": "package com.example.app;\npublic class MainActivity {}\n",
+		"proj/xml/values/strings.xml":                 "<resources><string name=\"app_name\">App</string></resources>\n",
+	}, 0644)
+	if err := os.MkdirAll(filepath.Join(dir, "sdk/platforms/android-30"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := os.Create(filepath.Join(dir, "sdk/platforms/android-30/android.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zip.NewWriter(jar).Close()
+	jar.Close()
+
+	for _, restore := range []func(){
+		setenv("HOME", filepath.Join(dir, "home")),
+		setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache")),
+		setenv("PATH", filepath.Join(dir, "bin")+string(os.PathListSeparator)+os.Getenv("PATH")),
+		setenv("BLADE_DEBUG_KEYSTORE", ""),
+	} {
+		defer restore()
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	proj := filepath.Join(dir, "proj")
+	filepath.Walk(proj, func(path string, info os.FileInfo, err error) error {
+		return os.Chmod(path, info.Mode()&^0222)
+	})
+	defer filepath.Walk(proj, func(path string, info os.FileInfo, err error) error {
+		return os.Chmod(path, info.Mode()|0200)
+	})
+	before := snapshot(t, proj)
+	if err := os.Chdir(proj); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	args := &buildArgs{}
+	fs := flag.NewFlagSet("blade", flag.ContinueOnError)
+	args.register(fs)
+	args.parse(fs, []string{"-sdk", filepath.Join(dir, "sdk"), "-out", out})
+	if err := args.validate(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := newBuild(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.preflight(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.initToolchain(); err != nil {
+		t.Fatal(err)
+	}
+	err = runStages(b.stages(), 1, func(s *stage) error {
+		_, err := b.runIncrementally(s, b.toolchain.withOutput(ioutil.Discard))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{"app.apk", buildInfoFilepath, checksumsFilepath} {
+		if !exist(filepath.Join(out, f)) {
+			t.Errorf("the build wrote no %v to its output directory", f)
+		}
+	}
+	if after := snapshot(t, proj); after != before {
+		t.Errorf("the build changed the project directory, which was:\n%v\nbut is now:\n%v", before, after)
+	}
+}
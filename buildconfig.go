@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const outputDirForBuildConfigSources = "generated_build_config_sources"
+
+var (
+	javaIdentifier            = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+	standardBuildConfigFields = []string{"DEBUG", "APPLICATION_ID", "BUILD_TYPE", "VERSION_CODE", "VERSION_NAME"}
+)
+
+// buildConfigField is a constant of the generated BuildConfig class.
+type buildConfigField struct {
+	typ   string
+	name  string
+	value string
+}
+
+// newBuildConfigFields reads the custom fields of BuildConfig from the
+// build_config table of a variant, typed after their values: booleans as
+// boolean, integers as int, floats as double and strings as String.
+func newBuildConfigFields(t map[string]interface{}) ([]buildConfigField, error) {
+	tt, err := table(t, "build_config")
+	if err != nil || tt == nil {
+		return nil, err
+	}
+	fields := make([]buildConfigField, 0, len(tt))
+	for name, val := range tt {
+		if !javaIdentifier.MatchString(name) {
+			return nil, fmt.Errorf("build_config.%v must be named as a Java identifier", name)
+		}
+		if contains(standardBuildConfigFields, name) {
+			return nil, fmt.Errorf("build_config.%v is generated already, so cannot be declared", name)
+		}
+		f := buildConfigField{name: name}
+		switch v := val.(type) {
+		case bool:
+			f.typ, f.value = "boolean", strconv.FormatBool(v)
+		case int64:
+			if int64(int32(v)) != v {
+				f.typ, f.value = "long", strconv.FormatInt(v, 10)+"L"
+			} else {
+				f.typ, f.value = "int", strconv.FormatInt(v, 10)
+			}
+		case float64:
+			f.typ, f.value = "double", strconv.FormatFloat(v, 'g', -1, 64)
+			if !strings.ContainsAny(f.value, ".eE") {
+				f.value += ".0"
+			}
+		case string:
+			f.typ, f.value = "String", javaString(v)
+		default:
+			return nil, fmt.Errorf("build_config.%v must be a boolean, number or string but was '%v'", name, val)
+		}
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return fields, nil
+}
+
+// javaString returns s as a Java string literal.
+func javaString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r < ' ' || r > '~':
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04x`, u)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// writeBuildConfig writes the BuildConfig class of the build to dir, in the
+// package of the manifest as R is, with the fields that AGP generates followed
+// by the variant's custom fields.
+func (b *build) writeBuildConfig(dir string) error {
+	versionCode, err := b.manifest.versionCode()
+	if err != nil {
+		return err
+	}
+	fields := []buildConfigField{
+		{"boolean", "DEBUG", strconv.FormatBool(b.variant.name != "release")},
+		{"String", "APPLICATION_ID", javaString(b.applicationID)},
+		{"String", "BUILD_TYPE", javaString(b.variant.name)},
+		{"int", "VERSION_CODE", strconv.Itoa(versionCode)},
+		{"String", "VERSION_NAME", javaString(b.manifest.VersionName)},
+	}
+	var w strings.Builder
+	fmt.Fprintf(&w, "package %v;\n\n", b.manifest.Package)
+	fmt.Fprintf(&w, "/** Generated by blade for the %v variant. */\n", b.variant.name)
+	w.WriteString("public final class BuildConfig {\n")
+	for _, f := range append(fields, b.variant.buildConfigFields...) {
+		fmt.Fprintf(&w, "    public static final %v %v = %v;\n", f.typ, f.name, f.value)
+	}
+	w.WriteString("}\n")
+	p := filepath.Join(dir, filepath.FromSlash(strings.Replace(b.manifest.Package, ".", "/", -1)), "BuildConfig.java")
+	if err := os.MkdirAll(filepath.Dir(p), 0774); err != nil {
+		return fmt.Errorf("could not create directory for BuildConfig due to error: %v", err)
+	}
+	if err := ioutil.WriteFile(p, []byte(w.String()), 0664); err != nil {
+		return fmt.Errorf("could not write BuildConfig to '%v' due to error: %v", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+const buildInfoFilepath = "buildinfo.json"
+
+// buildEnvironmentVariables are the environment variables that can change
+// what the build's tools output, besides the JVM options variables.
+var buildEnvironmentVariables = []string{"ANDROID_HOME", "JAVA_HOME", "LANG", "LC_ALL", "TZ"}
+
+// buildInfo records everything relevant to reproducing a build, for audits
+// that rebuild an APK and compare it to the one released. It is written as
+// buildinfo.json beside the APKs and read by `blade rebuild`.
+type buildInfo struct {
+	Version int      `json:"version"`
+	Blade   string   `json:"blade"`
+	Args    []string `json:"args"`
+	Source  struct {
+		Commit string `json:"commit"`
+		Dirty  bool   `json:"dirty"`
+	} `json:"source"`
+	Host struct {
+		OS   string `json:"os"`
+		Arch string `json:"arch"`
+	} `json:"host"`
+	// Env holds the build environment variables that were set.
+	Env   map[string]string `json:"env"`
+	Tools []toolInfo        `json:"tools"`
+	// Outputs are the SHA-256 digests of the APKs, by split rather than
+	// name, which may be templated with the date of the build.
+	Outputs map[string]string `json:"outputs"`
+}
+
+type toolInfo struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// newBuildInfo describes the finished build. The files of tools are only
+// hashed for local builds, as they are elsewhere for remote and container
+// builds, and those missing from older build-tools are left unhashed.
+func (b *build) newBuildInfo(t *toolchain) (*buildInfo, error) {
+	info := &buildInfo{Version: 1, Blade: "(devel)", Args: b.args.arguments, Env: make(map[string]string), Outputs: make(map[string]string)}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		info.Blade = bi.Main.Version
+	}
+	if out, err := gitIn(b.sourceDir, "rev-parse", "HEAD"); err == nil {
+		info.Source.Commit = strings.TrimSpace(out)
+		status, _ := gitIn(b.sourceDir, "status", "--porcelain", "--untracked-files=no")
+		info.Source.Dirty = strings.TrimSpace(status) != ""
+	}
+	info.Host.OS, info.Host.Arch = runtime.GOOS, runtime.GOARCH
+	vars := append([]string(nil), buildEnvironmentVariables...)
+	if !t.isolateJavaOptions {
+		vars = append(vars, javaOptionsVariables...)
+	}
+	for _, v := range vars {
+		if value, ok := os.LookupEnv(v); ok {
+			info.Env[v] = value
+		}
+	}
+
+	info.Tools = make([]toolInfo, 0)
+	if t.remote == nil && t.container == nil {
+		files := []toolInfo{
+			{Name: "aapt", Path: t.aaptBin},
+			{Name: "apksigner", Path: toolPath(t.tools, "apksigner", filepath.Join(t.buildTools, "lib", "apksigner.jar"))},
+			{Name: "d8", Path: toolPath(t.tools, "d8", filepath.Join(t.buildTools, "lib", "d8.jar"))},
+			{Name: "zipalign", Path: t.zipalignBin()},
+			{Name: "android.jar", Path: t.androidLib},
+		}
+		if exe, err := os.Executable(); err == nil {
+			files = append(files, toolInfo{Name: "blade", Path: exe})
+		}
+		for _, f := range files {
+			sum, err := sha256File(f.Path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("could not hash %v at '%v' due to error: %v", f.Name, f.Path, err)
+			}
+			f.SHA256 = sum
+			info.Tools = append(info.Tools, f)
+		}
+		javac := toolInfo{Name: "javac"}
+		if out, err := exec.Command(t.bin("javac"), "-version").CombinedOutput(); err == nil {
+			javac.Version = strings.TrimSpace(string(out))
+		}
+		info.Tools = append(info.Tools, javac)
+	}
+
+	for _, o := range b.outputs {
+		sum, err := sha256File(o.filepath)
+		if err != nil {
+			return nil, fmt.Errorf("could not hash APK '%v' due to error: %v", o.filepath, err)
+		}
+		info.Outputs[o.split()] = sum
+	}
+	return info, nil
+}
+
+func (info *buildInfo) write(path string) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0664); err != nil {
+		return fmt.Errorf("could not write build info to '%v' due to error: %v", path, err)
+	}
+	return nil
+}
+
+func readBuildInfo(path string) (*buildInfo, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read build info at '%v' due to error: %v", path, err)
+	}
+	info := &buildInfo{}
+	if err := json.Unmarshal(b, info); err != nil {
+		return nil, fmt.Errorf("could not parse build info at '%v' due to error: %v", path, err)
+	}
+	if info.Version != 1 {
+		return nil, fmt.Errorf("build info at '%v' is of version %v, which this blade cannot read", path, info.Version)
+	}
+	return info, nil
+}
+
+// differences returns how the build described by got differs from that
+// described by want, in its tools and then its outputs.
+func (want *buildInfo) differences(got *buildInfo) (tools, outputs []string) {
+	tools, outputs = make([]string, 0), make([]string, 0)
+	wantTools := make(map[string]toolInfo)
+	for _, t := range want.Tools {
+		wantTools[t.Name] = t
+	}
+	for _, t := range got.Tools {
+		w, ok := wantTools[t.Name]
+		switch {
+		case !ok:
+		case w.SHA256 != t.SHA256:
+			tools = append(tools, fmt.Sprintf("%v has SHA-256 %v but was %v", t.Name, t.SHA256, w.SHA256))
+		case w.Version != t.Version:
+			tools = append(tools, fmt.Sprintf("%v is version %q but was %q", t.Name, t.Version, w.Version))
+		}
+	}
+	names := make([]string, 0, len(want.Outputs))
+	for name := range want.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if got.Outputs[name] != want.Outputs[name] {
+			outputs = append(outputs, fmt.Sprintf("%v APK has SHA-256 %v but was %v", name, got.Outputs[name], want.Outputs[name]))
+		}
+	}
+	return tools, outputs
+}
+
+// outputDirOf returns the -out of a build run with arguments, which is
+// where it writes its buildinfo.json.
+func outputDirOf(arguments []string) string {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	args := &buildArgs{}
+	args.register(fs)
+	// The flags of the build alone, which main registers itself.
+	fs.Int("j", 0, jobsDesc)
+	fs.String("color", "", colorDesc)
+	fs.String("report", "", reportDesc)
+	fs.Bool("archive", false, archiveDesc)
+	fs.Parse(arguments)
+	return args.outputDir
+}
+
+// rebuild runs the build described by a buildinfo.json again, with the same
+// flags and build environment variables, from the same commit, and reports
+// whether it reproduced the same APKs. The new build writes its own
+// buildinfo.json, so the one given had best be a copy kept elsewhere.
+func rebuild(arguments []string) {
+	fs := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	color := registerColor(fs)
+	fs.Parse(arguments)
+	applyColor(fs, *color)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: blade rebuild <buildinfo.json>\n")
+		os.Exit(2)
+	}
+	want, err := readBuildInfo(fs.Arg(0))
+	if err != nil {
+		exitWithError(err)
+	}
+	if want.Host.OS != runtime.GOOS || want.Host.Arch != runtime.GOARCH {
+		fmt.Fprintf(os.Stderr, "%v\n", yellow(fmt.Sprintf("rebuilding on %v/%v what was built on %v/%v", runtime.GOOS, runtime.GOARCH, want.Host.OS, want.Host.Arch)))
+	}
+	if want.Source.Dirty {
+		fmt.Fprintf(os.Stderr, "%v\n", yellow("the build was of uncommitted changes, which cannot be reproduced exactly"))
+	}
+	if want.Source.Commit != "" {
+		out, err := git("rev-parse", "HEAD")
+		if err != nil {
+			exitWithError(err)
+		}
+		if head := strings.TrimSpace(out); head != want.Source.Commit {
+			exitWithError(fmt.Errorf("the build was of commit %v but %v is checked out, try: git checkout %v", want.Source.Commit, head, want.Source.Commit))
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		exitWithError(fmt.Errorf("could not locate blade executable due to error: %v", err))
+	}
+	env := make([]string, 0)
+	recorded := append(append([]string(nil), buildEnvironmentVariables...), javaOptionsVariables...)
+	for _, e := range os.Environ() {
+		if !contains(recorded, strings.SplitN(e, "=", 2)[0]) {
+			env = append(env, e)
+		}
+	}
+	for k, v := range want.Env {
+		env = append(env, k+"="+v)
+	}
+	// Publishing again is not part of reproducing the build.
+	args := make([]string, 0, len(want.Args))
+	for _, a := range want.Args {
+		if name := strings.TrimLeft(strings.SplitN(a, "=", 2)[0], "-"); name != "publish" {
+			args = append(args, a)
+		}
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		exitWithStatus(err)
+	}
+
+	got, err := readBuildInfo(filepath.Join(outputDirOf(args), buildInfoFilepath))
+	if err != nil {
+		exitWithError(err)
+	}
+	tools, outputs := want.differences(got)
+	for _, d := range tools {
+		fmt.Fprintf(os.Stderr, "%v\n", yellow(d))
+	}
+	if len(outputs) > 0 {
+		for _, d := range outputs {
+			fmt.Fprintf(os.Stderr, "%v\n", red(d))
+		}
+		fmt.Fprintf(os.Stderr, "the rebuild did not reproduce %v of %v APKs\n", len(outputs), len(want.Outputs))
+		os.Exit(1)
+	}
+	fmt.Printf("the rebuild reproduced all %v APKs\n", len(want.Outputs))
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cachePolicy limits how much disk blade's caches take up, declared in
+// blade.toml as:
+//
+//	[cache]
+//	max_size = "2GB"
+//	max_age = "30d"
+//
+// Files not used for longer than max_age are removed, and then the least
+// recently used files until the caches take up no more than max_size. The
+// policy is applied after every build, and by `blade cache gc`.
+type cachePolicy struct {
+	maxSize int64
+	maxAge  time.Duration
+}
+
+func newCachePolicy(t map[string]interface{}) (cachePolicy, error) {
+	p := cachePolicy{}
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid [cache] config: %v", err)
+	}
+	s, err := stringValue(t, "max_size")
+	if err != nil {
+		return p, wrap(err)
+	}
+	if p.maxSize, err = parseSize(s); err != nil {
+		return p, wrap(err)
+	}
+	if s, err = stringValue(t, "max_age"); err != nil {
+		return p, wrap(err)
+	}
+	if p.maxAge, err = parseAge(s); err != nil {
+		return p, wrap(err)
+	}
+	return p, nil
+}
+
+func (p cachePolicy) isSet() bool {
+	return p.maxSize > 0 || p.maxAge > 0
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+// parseSize parses sizes such as "500MB" or "2GB", in which units are
+// powers of 1024, or "" as no limit.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	unit := int64(1)
+	n := strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(n, u.suffix) {
+			n, unit = strings.TrimSpace(strings.TrimSuffix(n, u.suffix)), u.bytes
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("size '%v' must be a number of bytes, optionally followed by KB, MB or GB", s)
+	}
+	return int64(f * float64(unit)), nil
+}
+
+// parseAge parses durations such as "30d" or "12h", or "" as no limit.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("age '%v' must be a number of days such as 30d, or a duration such as 12h", s)
+	}
+	return d, nil
+}
+
+// userCacheDir returns the directory of the cache blade shares between
+// builds of every project.
+func userCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate the user's cache directory due to error: %v", err)
+	}
+	return filepath.Join(dir, "blade"), nil
+}
+
+// cacheDirs returns the directories of blade's caches for builds that
+// output to outputDir: the R.java cache of the user's cache, and the stamps
+// and depfiles that let a build skip stages, which builds only regenerate.
+// The rest of the output directory's .blade, such as the startup profile
+// and the size history, is kept however old, as it cannot be regenerated.
+func cacheDirs(outputDir string) ([]string, error) {
+	dir, err := userCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return []string{filepath.Join(dir, rJavaCacheDir), filepath.Join(outputDir, stampsDir), filepath.Join(outputDir, depfilesDir)}, nil
+}
+
+// Entries of the user's cache, which builds running at once share, keep their
+// files under cacheFilesDir, beside cacheSumsFilename listing the SHA-256 of
+// each, so that entries that are corrupt, or that the cache policy removed
+// files of, are found rather than restored.
+const (
+	cacheFilesDir     = "files"
+	cacheSumsFilename = "sha256sums"
+)
+
+// lockCacheEntry locks the cache entry at dir, shared to read it or
+// exclusively to remove files from it, until the returned file is closed.
+// Locks are taken with flock, so they are released if blade dies holding one.
+func lockCacheEntry(dir string, exclusive bool) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not lock cache entry '%v' due to error: %v", dir, err)
+	}
+	return f, nil
+}
+
+// writeCacheSums lists the checksums of the files of the cache entry at dir.
+func writeCacheSums(dir string) error {
+	files, err := filesUnder(filepath.Join(dir, cacheFilesDir))
+	if err != nil {
+		return err
+	}
+	var w strings.Builder
+	for _, f := range files {
+		sum, err := sha256File(f)
+		if err != nil {
+			return fmt.Errorf("could not checksum '%v' due to error: %v", f, err)
+		}
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&w, "%v  %v\n", sum, filepath.ToSlash(rel))
+	}
+	return ioutil.WriteFile(filepath.Join(dir, cacheSumsFilename), []byte(w.String()), 0664)
+}
+
+// verifyCacheEntry checks the files of the cache entry at dir against their
+// checksums.
+func verifyCacheEntry(dir string) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, cacheSumsFilename))
+	if err != nil {
+		return fmt.Errorf("cache entry '%v' has no checksums", dir)
+	}
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		ss := strings.SplitN(l, "  ", 2)
+		if len(ss) != 2 {
+			return fmt.Errorf("cache entry '%v' has malformed checksums", dir)
+		}
+		if sum, err := sha256File(filepath.Join(dir, filepath.FromSlash(ss[1]))); err != nil || sum != ss[0] {
+			return fmt.Errorf("cache entry '%v' has a missing or corrupt file '%v'", dir, ss[1])
+		}
+	}
+	return nil
+}
+
+// removeCorruptCacheEntry removes the cache entry at dir, which failed
+// verification with cause, once no build is reading it.
+func removeCorruptCacheEntry(dir string, cause error) error {
+	lock, err := lockCacheEntry(dir, true)
+	if os.IsNotExist(err) {
+		return cause
+	} else if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("%v, and could not be removed due to error: %v", cause, err)
+	}
+	return fmt.Errorf("%v, so it was removed to be stored anew", cause)
+}
+
+// removeCacheFile removes the cached file at path, holding the lock of the
+// entry of the user's cache it is in, if any, so that no build is reading
+// the entry meanwhile.
+func removeCacheFile(path string) error {
+	if cache, err := userCacheDir(); err == nil {
+		parent := filepath.Join(cache, rJavaCacheDir)
+		if rel, err := filepath.Rel(parent, path); err == nil && !strings.HasPrefix(rel, "..") {
+			entry := filepath.Join(parent, strings.Split(filepath.ToSlash(rel), "/")[0])
+			if lock, err := lockCacheEntry(entry, true); err == nil {
+				defer lock.Close()
+			}
+		}
+	}
+	return os.Remove(path)
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// gc removes files from dirs as the policy dictates, returning how many
+// files and bytes it removed, or would remove if dryRun is set.
+func (p cachePolicy) gc(dirs []string, dryRun bool) (int, int64, error) {
+	files := make([]cacheFile, 0)
+	var total int64
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			switch {
+			case os.IsNotExist(err) && path == dir:
+			case err != nil:
+				return err
+			case !info.IsDir():
+				files = append(files, cacheFile{path, info.Size(), info.ModTime()})
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not walk cache directory '%v' due to error: %v", dir, err)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	removed, freed := 0, int64(0)
+	now := time.Now()
+	for _, f := range files {
+		expired := p.maxAge > 0 && now.Sub(f.modTime) > p.maxAge
+		tooBig := p.maxSize > 0 && total-freed > p.maxSize
+		if !expired && !tooBig {
+			// Files are oldest first, so no later ones are expired either.
+			break
+		}
+		if !dryRun {
+			if err := removeCacheFile(f.path); err != nil && !os.IsNotExist(err) {
+				return removed, freed, fmt.Errorf("could not remove cached file '%v' due to error: %v", f.path, err)
+			}
+		}
+		removed++
+		freed += f.size
+	}
+	return removed, freed, nil
+}
+
+// cache runs the subcommand of `blade cache` named by the first argument.
+func cache(arguments []string) {
+	if len(arguments) < 1 || arguments[0] != "gc" {
+		fmt.Fprintf(os.Stderr, "Usage: blade cache gc [flags]\n")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("cache gc", flag.ExitOnError)
+	out := fs.String("out", "", outDesc)
+	configFilepath := fs.String("config", defaultConfigFilepath, configDesc)
+	maxSize := fs.String("max-size", "", "The most disk space, such as 500MB or 2GB, that caches may take up, in lieu of max_size under [cache] in config")
+	maxAge := fs.String("max-age", "", "How long, such as 30d or 12h, cached files may go unused for, in lieu of max_age under [cache] in config")
+	dryRun := fs.Bool("dry-run", false, "Print what would be removed without removing anything")
+	fs.Parse(arguments[1:])
+	explicit := false
+	fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+
+	c, err := loadConfig(*configFilepath, explicit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not load config due to error: %v\n", err)
+		os.Exit(1)
+	}
+	p := c.cache
+	if *maxSize != "" {
+		if p.maxSize, err = parseSize(*maxSize); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *maxAge != "" {
+		if p.maxAge, err = parseAge(*maxAge); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+	if !p.isSet() {
+		fmt.Fprintf(os.Stderr, "no limit on the size or age of caches is set in config or as a flag\n")
+		os.Exit(2)
+	}
+	outputDir, err := filepath.Abs(*out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not locate output directory at filepath '%v' due to error: %v\n", *out, err)
+		os.Exit(1)
+	}
+	dirs, err := cacheDirs(outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	n, size, err := p.gc(dirs, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	fmt.Printf("%v %v files taking up %.1f MB\n", verb, n, float64(size)/(1<<20))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const checksumsFilepath = "checksums.txt"
+
+// writeChecksums writes the SHA-256 digests of files to path in the format
+// of sha256sum, so that `sha256sum -c checksums.txt` verifies them.
+func writeChecksums(path string, files []string) error {
+	var w strings.Builder
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("could not open '%v' to checksum due to error: %v", p, err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("could not read '%v' to checksum due to error: %v", p, err)
+		}
+		fmt.Fprintf(&w, "%x  %v\n", h.Sum(nil), p)
+	}
+	if err := ioutil.WriteFile(path, []byte(w.String()), 0664); err != nil {
+		return fmt.Errorf("could not write checksums to '%v' due to error: %v", path, err)
+	}
+	return nil
+}
+
+// checksumSigners are the commands that sign the checksums file at path,
+// by the name given to -sign-checksums, which run on the host rather than
+// remotely or in a container since that is where signing keys are kept.
+var checksumSigners = map[string]func(path string) []string{
+	// Verified with `gpg --verify checksums.txt.asc checksums.txt`.
+	"gpg": func(p string) []string {
+		return []string{"gpg", "--batch", "--yes", "--armor", "--detach-sign", "--output", p + ".asc", p}
+	},
+	// Verified with `cosign verify-blob --bundle checksums.txt.sigstore.json ...`.
+	"sigstore": func(p string) []string {
+		return []string{"cosign", "sign-blob", "--yes", "--bundle", p + ".sigstore.json", p}
+	},
+}
+
+func signChecksums(signer, path string, stdout, stderr io.Writer) error {
+	s := checksumSigners[signer](path)
+	cmd := exec.Command(s[0], s[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error when running command %v : %v", strings.Join(s, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// colored is whether diagnostics are colored, as decided by setColor.
+var colored bool
+
+// setColor decides whether diagnostics written to f are colored, which for
+// mode "auto" is when f is a terminal and the NO_COLOR environment variable
+// is empty, as https://no-color.org asks of command-line tools.
+func setColor(mode string, f *os.File) error {
+	c, err := useColor(mode, f)
+	if err != nil {
+		return err
+	}
+	colored = c
+	return nil
+}
+
+// useColor reports whether what is written to f is colored for mode.
+func useColor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return isTerminal(f) && os.Getenv("NO_COLOR") == "", nil
+	}
+	return false, fmt.Errorf("color must be auto, always or never, not '%v'", mode)
+}
+
+// registerColor registers -color on fs, for applyColor once fs is parsed.
+func registerColor(fs *flag.FlagSet) *string {
+	return fs.String("color", "auto", colorDesc)
+}
+
+// applyColor colors diagnostics as mode says, exiting with the usage of fs
+// if mode is invalid.
+func applyColor(fs *flag.FlagSet, mode string) {
+	if err := setColor(mode, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// isTerminal reports whether f is a terminal that understands escape codes.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+func paint(code, s string) string {
+	if !colored {
+		return s
+	}
+	return escape(code, s)
+}
+
+func escape(code, s string) string {
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+func red(s string) string    { return paint("31", s) }
+func green(s string) string  { return paint("32", s) }
+func yellow(s string) string { return paint("33", s) }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// subcommands returns the functions that run each subcommand, by name, given
+// the arguments following the subcommand's name.
+func subcommands() map[string]func(arguments []string) {
+	return map[string]func(arguments []string){
+		"adb":            adbCommand,
+		"app-data":       appData,
+		"badging":        badgingCommand,
+		"cache":          cache,
+		"clear-data":     clearData,
+		"connect":        connectCommand,
+		"dexdump":        dexdump,
+		"emulator":       emulator,
+		"explain":        explain,
+		"fdroid":         fdroidCommand,
+		"fingerprint":    fingerprintCommand,
+		"generate":       generate,
+		"graph":          graph,
+		"inspect":        inspect,
+		"measure":        measure,
+		"migrate":        migrate,
+		"package":        packageCommand,
+		"pair":           pair,
+		"pull-apk":       pullAPK,
+		"rebuild":        rebuild,
+		"release":        release,
+		"run":            runCommand,
+		"shell":          shell,
+		"sign":           signCommand,
+		"size":           size,
+		"stage":          runStage,
+		"stats":          stats,
+		"support-bundle": supportBundle,
+		"upgrade-test":   upgradeTest,
+		"verify":         verify,
+		"watch":          watch,
+	}
+}
+
+// graph prints the stages of the build described by the build flags and
+// which stages each depends on, either one stage per line or in the DOT
+// language of Graphviz.
+func graph(arguments []string) {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	dot := fs.Bool("dot", false, "Print the graph in the DOT language of Graphviz, e.g. for piping into `dot -Tsvg`")
+	args := &buildArgs{}
+	args.register(fs)
+	args.parse(fs, arguments)
+
+	b, err := newBuild(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	ss := b.stages()
+	if !*dot {
+		for _, s := range ss {
+			fmt.Printf("%v:", s.name)
+			for _, d := range s.deps {
+				fmt.Printf(" %v", d)
+			}
+			fmt.Println()
+		}
+		return
+	}
+	fmt.Println("digraph blade {")
+	fmt.Println("\trankdir=LR;")
+	for _, s := range ss {
+		fmt.Printf("\t%q;\n", s.name)
+		for _, d := range s.deps {
+			fmt.Printf("\t%q -> %q;\n", d, s.name)
+		}
+	}
+	fmt.Println("}")
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const defaultConfigFilepath = "blade.toml"
+
+// config holds the settings read from a blade.toml file.
+type config struct {
+	// path is the absolute location of the config file, which need not exist.
+	path string
+	// dir is the directory containing the config file, against which
+	// relative paths in the config are resolved.
+	dir        string
+	generators []generator
+	publishers []publisher
+	variants   map[string]variant
+	tools      map[string]tool
+	room       room
+	cache      cachePolicy
+	app        appConfig
+	release    releaseConfig
+	fdroid     fdroidConfig
+	runPresets map[string]runPreset
+	archive    archiveConfig
+	// outputName is the template of the name of the APKs, without the .apk
+	// extension, as in template.go, such as
+	// "app-{variant}-{version_name}-{git_sha}".
+	outputName string
+	// debugKeystore is the keystore to sign APKs with the debug key from,
+	// unless -keystore or $BLADE_DEBUG_KEYSTORE is given.
+	debugKeystore string
+	// startupProfile is the startup profile that builds lay out the dex by,
+	// in lieu of the one collected by `blade run -startup-profile`.
+	startupProfile string
+	// minFreeSpace is the least free disk space, in bytes, that the output
+	// and cache directories must have for a build to start, or 0 for any.
+	minFreeSpace int64
+	// isolateJavaOptions keeps JVM options in the environment from tools.
+	isolateJavaOptions bool
+	// checkLayouts runs the check-layouts stage, unless check_layouts is
+	// false for apps whose layouts it reports problems in wrongly.
+	checkLayouts bool
+}
+
+// loadConfig reads the blade.toml file at path. A missing file is only an
+// error when the path was provided explicitly, otherwise an empty config is
+// returned so that projects without a blade.toml keep building.
+func loadConfig(path string, explicit bool) (*config, error) {
+	c := &config{variants: make(map[string]variant), tools: make(map[string]tool), runPresets: make(map[string]runPreset), release: defaultReleaseConfig, fdroid: defaultFDroidConfig, minFreeSpace: defaultMinFreeSpace, checkLayouts: true}
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return c, fmt.Errorf("could not locate config file at '%v' due to error: %v", path, err)
+	}
+	c.path = p
+	c.dir = filepath.Dir(p)
+	b, err := ioutil.ReadFile(p)
+	switch {
+	case os.IsNotExist(err) && !explicit:
+		return c, nil
+	case err != nil:
+		return c, fmt.Errorf("could not read config file at '%v' due to error: %v", p, err)
+	}
+	t, err := parseTOML(string(b))
+	if err != nil {
+		return c, fmt.Errorf("could not parse config file at '%v' due to error: %v", p, err)
+	}
+	if c.outputName, err = stringValue(t, "output_name"); err != nil {
+		return c, err
+	}
+	if c.debugKeystore, err = stringValue(t, "debug_keystore"); err != nil {
+		return c, err
+	}
+	if c.debugKeystore != "" {
+		c.debugKeystore = c.resolve(c.debugKeystore)
+	}
+	if c.startupProfile, err = stringValue(t, "startup_profile"); err != nil {
+		return c, err
+	}
+	if c.startupProfile != "" {
+		c.startupProfile = c.resolve(c.startupProfile)
+	}
+	if s, err := stringValue(t, "min_free_space"); err != nil {
+		return c, err
+	} else if s != "" {
+		if c.minFreeSpace, err = parseSize(s); err != nil {
+			return c, fmt.Errorf("min_free_space: %v", err)
+		}
+	}
+	if _, ok := t["check_layouts"]; ok {
+		if c.checkLayouts, err = boolValue(t, "check_layouts"); err != nil {
+			return c, err
+		}
+	}
+	tt, err := tableList(t, "generator")
+	if err != nil {
+		return c, err
+	}
+	for _, g := range tt {
+		gen, err := newGenerator(g)
+		if err != nil {
+			return c, err
+		}
+		c.generators = append(c.generators, gen)
+	}
+	tt, err = tableList(t, "publish")
+	if err != nil {
+		return c, err
+	}
+	for _, p := range tt {
+		pub, err := newPublisher(p)
+		if err != nil {
+			return c, err
+		}
+		c.publishers = append(c.publishers, pub)
+	}
+	vv, err := table(t, "variant")
+	if err != nil {
+		return c, err
+	}
+	for name := range vv {
+		v, err := table(vv, name)
+		if err != nil {
+			return c, err
+		}
+		c.variants[name], err = newVariant(name, v)
+		if err != nil {
+			return c, err
+		}
+	}
+	tools, err := table(t, "tools")
+	if err != nil {
+		return c, err
+	}
+	if c.tools, c.isolateJavaOptions, err = newTools(c, tools); err != nil {
+		return c, err
+	}
+	r, err := table(t, "room")
+	if err != nil {
+		return c, err
+	}
+	if c.room, err = newRoom(c, r); err != nil {
+		return c, err
+	}
+	cc, err := table(t, "cache")
+	if err != nil {
+		return c, err
+	}
+	if c.cache, err = newCachePolicy(cc); err != nil {
+		return c, err
+	}
+	a, err := table(t, "app")
+	if err != nil {
+		return c, err
+	}
+	if c.app, err = newAppConfig(a); err != nil {
+		return c, err
+	}
+	rel, err := table(t, "release")
+	if err != nil {
+		return c, err
+	}
+	if c.release, err = newReleaseConfig(rel); err != nil {
+		return c, err
+	}
+	fd, err := table(t, "fdroid")
+	if err != nil {
+		return c, err
+	}
+	if c.fdroid, err = newFDroidConfig(fd); err != nil {
+		return c, err
+	}
+	ar, err := table(t, "archive")
+	if err != nil {
+		return c, err
+	}
+	if c.archive, err = newArchiveConfig(c, ar); err != nil {
+		return c, err
+	}
+	rr, err := table(t, "run")
+	if err != nil {
+		return c, err
+	}
+	for name := range rr {
+		r, err := table(rr, name)
+		if err != nil {
+			return c, err
+		}
+		if c.runPresets[name], err = newRunPreset(name, r); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// resolve returns path made absolute relative to the config file's directory.
+func (c *config) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.dir, path)
+}
+
+func stringValue(t map[string]interface{}, key string) (string, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("config key '%v' must be a string but was '%v'", key, v)
+	}
+}
+
+func boolValue(t map[string]interface{}, key string) (bool, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	default:
+		return false, fmt.Errorf("config key '%v' must be true or false but was '%v'", key, v)
+	}
+}
+
+func intValue(t map[string]interface{}, key string) (int, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return int(v), nil
+	default:
+		return 0, fmt.Errorf("config key '%v' must be an integer but was '%v'", key, v)
+	}
+}
+
+func stringList(t map[string]interface{}, key string) ([]string, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for _, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("config key '%v' must be an array of strings but contained '%v'", key, e)
+			}
+			ss = append(ss, s)
+		}
+		return ss, nil
+	default:
+		return nil, fmt.Errorf("config key '%v' must be an array of strings but was '%v'", key, v)
+	}
+}
+
+func stringMap(t map[string]interface{}, key string) (map[string]string, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		m := make(map[string]string, len(v))
+		for k, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("config key '%v.%v' must be a string but was '%v'", key, k, e)
+			}
+			m[k] = s
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("config key '%v' must be a table of strings, e.g. [%v]", key, key)
+	}
+}
+
+func table(t map[string]interface{}, key string) (map[string]interface{}, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("config key '%v' must be a table, e.g. [%v]", key, key)
+	}
+}
+
+func tableList(t map[string]interface{}, key string) ([]map[string]interface{}, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		tt := make([]map[string]interface{}, 0, len(v))
+		for _, e := range v {
+			m, ok := e.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("config key '%v' must be an array of tables", key)
+			}
+			tt = append(tt, m)
+		}
+		return tt, nil
+	default:
+		return nil, fmt.Errorf("config key '%v' must be an array of tables, e.g. [[%v]]", key, key)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// container runs toolchain commands inside a Docker or Podman container of
+// an image providing the Android SDK and a JDK, so that builds do not depend
+// on what is installed on the host.
+//
+// The directories commands read from and write to are mounted into the
+// container at the same paths as on the host, so commands run unchanged.
+type container struct {
+	engine string
+	image  string
+	mounts []string
+}
+
+func newContainer(image string, dirs []string) (*container, error) {
+	c := &container{image: image, mounts: outermostDirs(dirs)}
+	for _, engine := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(engine); err == nil {
+			c.engine = engine
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("neither docker nor podman were found on the PATH to run image '%v' with", image)
+}
+
+// command returns the command that runs s inside the container from the
+// current working directory.
+func (c *container) command(s []string) []string {
+	cmd := []string{c.engine, "run", "--rm", "--interactive"}
+	if c.engine == "docker" {
+		// Podman maps the host user into rootless containers itself, while
+		// Docker would otherwise leave root-owned outputs behind.
+		cmd = append(cmd, "--user", fmt.Sprintf("%v:%v", os.Getuid(), os.Getgid()))
+	}
+	for _, m := range c.mounts {
+		cmd = append(cmd, "--volume", m+":"+m)
+	}
+	if wd, err := os.Getwd(); err == nil {
+		cmd = append(cmd, "--workdir", wd)
+	}
+	cmd = append(cmd, "--env", "HOME="+os.Getenv("HOME"), c.image)
+	return append(cmd, s...)
+}
+
+// output runs s inside the container and returns what it printed.
+func (c *container) output(s ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := c.command(s)
+	x := exec.Command(cmd[0], cmd[1:]...)
+	x.Stderr = &stderr
+	b, err := x.Output()
+	if err != nil {
+		return "", fmt.Errorf("error when running command %v in image '%v' : %v\n%v", strings.Join(s, " "), c.image, err, stderr.String())
+	}
+	return string(b), nil
+}
+
+// env returns the value of the environment variable named key in the image.
+func (c *container) env(key string) (string, error) {
+	s, err := c.output("sh", "-c", "echo $"+key)
+	if err != nil {
+		return "", err
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("%v is not set in image '%v', so the SDK location inside it must be provided as a flag", key, c.image)
+	}
+	return s, nil
+}
+
+// readDirNames returns the sorted names of the entries of the directory at
+// path inside the container.
+func (c *container) readDirNames(path string) ([]string, error) {
+	s, err := c.output("ls", "-1", path)
+	if err != nil {
+		return nil, err
+	}
+	names := strings.Fields(s)
+	sort.Strings(names)
+	return names, nil
+}
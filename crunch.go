@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const outputDirForZopfliResources = "generated_zopfli_resources"
+
+// recompressPNGs writes each PNG under resourceDirs, other than nine-patches
+// which aapt must still process, recompressed with zopflipng to the same
+// relative path under outDir. As with aapt, a PNG in an earlier directory
+// takes precedence over one at the same path in a later directory.
+func (t toolchain) recompressPNGs(resourceDirs []string, outDir string) error {
+	for _, dir := range resourceDirs {
+		files, err := filesUnder(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if !strings.HasSuffix(f, ".png") || strings.HasSuffix(f, ".9.png") {
+				continue
+			}
+			rel, err := filepath.Rel(dir, f)
+			if err != nil {
+				return fmt.Errorf("could not find path of '%v' within '%v' due to error: %v", f, dir, err)
+			}
+			out := filepath.Join(outDir, rel)
+			if _, err := os.Stat(out); err == nil {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(out), 0774); err != nil {
+				return fmt.Errorf("could not create directory for recompressed PNG '%v' due to error: %v", out, err)
+			}
+			if err := t.run(t.bin("zopflipng"), "-y", f, out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
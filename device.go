@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// device is a device or emulator that commands are run on with the adb of
+// the SDK's platform-tools, so that no separately installed adb is needed.
+type device struct {
+	sdk string
+	// serial is that of the device, or empty for adb to pick the device
+	// named by $ANDROID_SERIAL or the only one connected.
+	serial string
+	// adbBin is the adb that blade.toml overrides that of the SDK with, if
+	// any.
+	adbBin string
+}
+
+// sdkHome returns the SDK location given as a flag, or else $ANDROID_HOME.
+func sdkHome(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if h := os.Getenv("ANDROID_HOME"); h != "" {
+		return h, nil
+	}
+	return "", withCode(errSDKNotFound, fmt.Errorf("ANDROID_HOME must be set as an environment variable or the SDK location must be provided manually as a flag"))
+}
+
+func (d device) adbPath() string {
+	if d.adbBin != "" {
+		return d.adbBin
+	}
+	return filepath.Join(d.sdk, "platform-tools", "adb")
+}
+
+func (d device) adbArgs(args []string) []string {
+	if d.serial != "" {
+		return append([]string{"-s", d.serial}, args...)
+	}
+	return args
+}
+
+// adb runs adb against the device, returning what it printed.
+func (d device) adb(args ...string) (string, error) {
+	var out bytes.Buffer
+	err := d.command(args, true, func(cmd *exec.Cmd) {
+		out.Reset()
+		cmd.Stdout = &out
+	})
+	if _, ok := err.(*exec.ExitError); ok {
+		return out.String(), fmt.Errorf("error when running command adb %v : %v", strings.Join(args, " "), err)
+	}
+	return out.String(), err
+}
+
+// run runs adb against the device attached to blade's standard streams,
+// for commands that are interactive or print as they go.
+func (d device) run(args ...string) error {
+	return d.command(args, true, func(cmd *exec.Cmd) {
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+	})
+}
+
+// pipe runs adb against the device with the given standard input and
+// output, for streaming files to and from it.
+func (d device) pipe(stdin io.Reader, stdout io.Writer, args ...string) error {
+	// What adb read of stdin before failing could not be read again.
+	err := d.command(args, stdin == nil, func(cmd *exec.Cmd) {
+		cmd.Stdin = stdin
+		cmd.Stdout = stdout
+	})
+	if _, ok := err.(*exec.ExitError); ok {
+		return fmt.Errorf("error when running command adb %v : %v", strings.Join(args, " "), err)
+	}
+	return err
+}
+
+// waitForBoot waits until the device has finished booting.
+func (d device) waitForBoot(timeout time.Duration) error {
+	if _, err := d.adb("wait-for-device"); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		out, err := d.adb("shell", "getprop", "sys.boot_completed")
+		if err == nil && strings.TrimSpace(out) == "1" {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("device '%v' did not finish booting within %v", d.serial, timeout)
+}
+
+// checkCompatible checks that the APK at apk can run on the device, as its
+// API level is at least the APK's minSdk and it supports one of the ABIs the
+// APK packages native libraries for, if any, so that installing it does not
+// fail with the less clear INSTALL_FAILED errors of pm.
+func (d device) checkCompatible(apk string) error {
+	g, err := readBadging(apk)
+	if err != nil {
+		return err
+	}
+	out, err := d.adb("shell", "getprop", "ro.build.version.sdk")
+	if err != nil {
+		return err
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return fmt.Errorf("could not read the API level of the device from '%v'", strings.TrimSpace(out))
+	}
+	// A minSdk that is the codename of a preview is left for pm to check.
+	if minSDK, err := strconv.Atoi(g.MinSDK); err == nil && level < minSDK {
+		return withCode(errIncompatibleDevice, fmt.Errorf("this build cannot run on this device, as the device has API level %v and the app's minSdk is %v", level, minSDK))
+	}
+	if len(g.NativeCode) == 0 {
+		return nil
+	}
+	out, err = d.adb("shell", "getprop", "ro.product.cpu.abilist")
+	if err != nil {
+		return err
+	}
+	abis := strings.Split(strings.TrimSpace(out), ",")
+	for _, abi := range abis {
+		for _, native := range g.NativeCode {
+			if abi == native {
+				return nil
+			}
+		}
+	}
+	return withCode(errIncompatibleDevice, fmt.Errorf("this build cannot run on this device, as the device supports the ABIs %v and the app only has native libraries for %v", strings.Join(abis, ", "), strings.Join(g.NativeCode, ", ")))
+}
+
+// registerDevice registers the flags that choose the device to run on, from
+// which newDevice makes it.
+func registerDevice(fs *flag.FlagSet) (sdk, serial *string) {
+	return fs.String("sdk", "", sdkDesc), fs.String("device", "", deviceDesc)
+}
+
+func newDevice(sdk, serial string) (device, error) {
+	h, err := sdkHome(sdk)
+	if err != nil {
+		return device{}, err
+	}
+	d := device{sdk: h, serial: serial}
+	// Not every device command takes -config, so the adb run is that of the
+	// blade.toml where blade runs.
+	if c, err := loadConfig(defaultConfigFilepath, false); err == nil {
+		d.adbBin = toolPath(c.tools, "adb", "")
+	}
+	if _, err := os.Stat(d.adbPath()); err != nil {
+		return d, fmt.Errorf("could not find adb at '%v', which is installed with `sdkmanager --install platform-tools`, or is the path of [tools.adb] in config", d.adbPath())
+	}
+	if serial == "" {
+		return d, nil
+	}
+	// A device known by name is connected to in case adb has since been
+	// restarted, which forgets devices connected over wireless debugging.
+	devices, err := knownDevices()
+	if err != nil {
+		return d, err
+	}
+	if address, ok := devices[serial]; ok {
+		d.serial = ""
+		if err := d.connect(address); err != nil {
+			return d, fmt.Errorf("%v, and if the device's address has changed, reconnect with `blade connect -name %v <ip:port>`", err, serial)
+		}
+		d.serial = address
+	}
+	return d, nil
+}
+
+// exitWithStatus exits with the exit status of the command that failed with
+// err, or else reports err.
+func exitWithStatus(err error) {
+	if exit, ok := err.(*exec.ExitError); ok {
+		os.Exit(exit.ExitCode())
+	}
+	exitWithError(err)
+}
+
+// adbCommand runs adb with the arguments following its flags, such as
+// `blade adb -device emulator-5554 root`.
+func adbCommand(arguments []string) {
+	fs := flag.NewFlagSet("adb", flag.ExitOnError)
+	sdk, serial := registerDevice(fs)
+	color := registerColor(fs)
+	fs.Parse(arguments)
+	applyColor(fs, *color)
+	d, err := newDevice(*sdk, *serial)
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := d.run(fs.Args()...); err != nil {
+		exitWithStatus(err)
+	}
+}
+
+// shell runs the command following its flags on the device, or opens an
+// interactive shell on it if none is given.
+func shell(arguments []string) {
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	sdk, serial := registerDevice(fs)
+	color := registerColor(fs)
+	fs.Parse(arguments)
+	applyColor(fs, *color)
+	d, err := newDevice(*sdk, *serial)
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := d.run(append([]string{"shell"}, fs.Args()...)...); err != nil {
+		exitWithStatus(err)
+	}
+}
+
+// appCommand parses the flags of a command run against the app on a device,
+// returning the device and the app's package, which unless given with
+// -package is the application ID of the build described by the build flags.
+func appCommand(name string, arguments []string, register func(fs *flag.FlagSet)) (device, string, []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	args := &buildArgs{}
+	args.register(fs)
+	serial := fs.String("device", "", deviceDesc)
+	pkg := fs.String("package", "", packageDesc)
+	color := registerColor(fs)
+	if register != nil {
+		register(fs)
+	}
+	args.parse(fs, arguments)
+	applyColor(fs, *color)
+	d, err := newDevice(args.androidHome, *serial)
+	if err != nil {
+		exitWithError(err)
+	}
+	if *pkg == "" {
+		b, err := newBuild(args)
+		if err != nil {
+			exitWithError(err)
+		}
+		*pkg = b.applicationID
+	}
+	return d, *pkg, fs.Args()
+}
+
+// pullAPK copies the installed APKs of the app, including any splits, from
+// the device to a directory.
+func pullAPK(arguments []string) {
+	var dir *string
+	d, pkg, _ := appCommand("pull-apk", arguments, func(fs *flag.FlagSet) {
+		dir = fs.String("o", ".", "The directory to copy the APKs to")
+	})
+	out, err := d.adb("shell", "pm", "path", pkg)
+	if err != nil {
+		exitWithError(err)
+	}
+	paths := make([]string, 0)
+	for _, l := range strings.Split(out, "\n") {
+		if p := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "package:")); strings.HasSuffix(p, ".apk") {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		exitWithError(fmt.Errorf("'%v' is not installed on the device", pkg))
+	}
+	if err := os.MkdirAll(*dir, 0774); err != nil {
+		exitWithError(fmt.Errorf("could not create directory '%v' due to error: %v", *dir, err))
+	}
+	for _, p := range paths {
+		// Splits are named like split_config.xxhdpi.apk alongside base.apk.
+		dst := filepath.Join(*dir, pkg+"-"+filepath.Base(p))
+		if filepath.Base(p) == "base.apk" {
+			dst = filepath.Join(*dir, pkg+".apk")
+		}
+		if _, err := d.adb("pull", p, dst); err != nil {
+			exitWithError(err)
+		}
+		fmt.Println(dst)
+	}
+}
+
+// clearData clears the app's data and caches on the device, as uninstalling
+// and reinstalling it would, without doing so.
+func clearData(arguments []string) {
+	d, pkg, _ := appCommand("clear-data", arguments, nil)
+	out, err := d.adb("shell", "pm", "clear", pkg)
+	if err != nil {
+		exitWithError(err)
+	}
+	if strings.TrimSpace(out) != "Success" {
+		exitWithError(fmt.Errorf("could not clear data of '%v': %v", pkg, strings.TrimSpace(out)))
+	}
+	fmt.Printf("cleared data of %v\n", pkg)
+}
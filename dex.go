@@ -0,0 +1,279 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// dexFile holds the identifiers that a dex file defines and references, as
+// described at https://source.android.com/docs/core/runtime/dex-format.
+type dexFile struct {
+	name    string
+	strings []string
+	types   []string
+	// methods and fields are references such as Lpkg/Class;->name, and
+	// methodClass holds the class of each method.
+	methods      []string
+	methodClass  []string
+	fields       []string
+	classes      []string
+	classMethods map[string]int
+}
+
+type dexReader struct {
+	b   []byte
+	err error
+}
+
+func (r *dexReader) u32(off uint32) uint32 {
+	if r.err != nil || int(off)+4 > len(r.b) {
+		r.fail(off)
+		return 0
+	}
+	return binary.LittleEndian.Uint32(r.b[off:])
+}
+
+func (r *dexReader) u16(off uint32) uint16 {
+	if r.err != nil || int(off)+2 > len(r.b) {
+		r.fail(off)
+		return 0
+	}
+	return binary.LittleEndian.Uint16(r.b[off:])
+}
+
+// uleb128 returns the unsigned LEB128 value at off and the offset after it.
+func (r *dexReader) uleb128(off uint32) (uint32, uint32) {
+	var v uint32
+	for i := uint(0); i < 5; i++ {
+		if r.err != nil || int(off) >= len(r.b) {
+			r.fail(off)
+			return 0, off
+		}
+		c := r.b[off]
+		off++
+		v |= uint32(c&0x7f) << (7 * i)
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	return v, off
+}
+
+func (r *dexReader) fail(off uint32) {
+	if r.err == nil {
+		r.err = fmt.Errorf("offset %#x is past the end of the file", off)
+	}
+}
+
+// str returns the string_data_item at off, whose MUTF-8 encoding is close
+// enough to UTF-8 for the identifiers it holds.
+func (r *dexReader) str(off uint32) string {
+	_, off = r.uleb128(off)
+	end := off
+	for r.err == nil && int(end) < len(r.b) && r.b[end] != 0 {
+		end++
+	}
+	if r.err != nil || int(end) >= len(r.b) {
+		r.fail(end)
+		return ""
+	}
+	return string(r.b[off:end])
+}
+
+func (r *dexReader) index(ss []string, i uint32) string {
+	if int(i) >= len(ss) {
+		if r.err == nil {
+			r.err = fmt.Errorf("index %v is out of range of %v", i, len(ss))
+		}
+		return ""
+	}
+	return ss[i]
+}
+
+// parseDex parses the identifiers of the dex file in b.
+func parseDex(name string, b []byte) (*dexFile, error) {
+	if len(b) < 0x70 || string(b[:4]) != "dex\n" {
+		return nil, fmt.Errorf("'%v' is not a dex file", name)
+	}
+	r := &dexReader{b: b}
+	d := &dexFile{name: name, classMethods: make(map[string]int)}
+
+	n, off := r.u32(0x38), r.u32(0x3c)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		d.strings = append(d.strings, r.str(r.u32(off+4*i)))
+	}
+	n, off = r.u32(0x40), r.u32(0x44)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		d.types = append(d.types, r.index(d.strings, r.u32(off+4*i)))
+	}
+	n, off = r.u32(0x50), r.u32(0x54)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		item := off + 8*i
+		d.fields = append(d.fields, r.index(d.types, uint32(r.u16(item)))+"->"+r.index(d.strings, r.u32(item+4)))
+	}
+	n, off = r.u32(0x58), r.u32(0x5c)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		item := off + 8*i
+		class := r.index(d.types, uint32(r.u16(item)))
+		d.methodClass = append(d.methodClass, class)
+		d.methods = append(d.methods, class+"->"+r.index(d.strings, r.u32(item+4)))
+	}
+	n, off = r.u32(0x60), r.u32(0x64)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		item := off + 32*i
+		class := r.index(d.types, r.u32(item))
+		d.classes = append(d.classes, class)
+		if dataOff := r.u32(item + 24); dataOff != 0 {
+			// class_data_item begins with the counts of static and instance
+			// fields, and of direct and virtual methods.
+			_, p := r.uleb128(dataOff)
+			_, p = r.uleb128(p)
+			direct, p := r.uleb128(p)
+			virtual, _ := r.uleb128(p)
+			d.classMethods[class] = int(direct + virtual)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("could not parse dex file '%v' due to error: %v", name, r.err)
+	}
+	return d, nil
+}
+
+// readDexFiles reads the dex file at p, or each classes*.dex of the APK at p.
+func readDexFiles(p string) ([]*dexFile, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not read '%v' due to error: %v", p, err)
+		}
+		d, err := parseDex(p, b)
+		if err != nil {
+			return nil, err
+		}
+		return []*dexFile{d}, nil
+	}
+	defer zr.Close()
+	dd := make([]*dexFile, 0)
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "classes") || path.Ext(f.Name) != ".dex" || strings.Contains(f.Name, "/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("could not open '%v' in '%v' due to error: %v", f.Name, p, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read '%v' in '%v' due to error: %v", f.Name, p, err)
+		}
+		d, err := parseDex(f.Name, b)
+		if err != nil {
+			return nil, err
+		}
+		dd = append(dd, d)
+	}
+	if len(dd) == 0 {
+		return nil, fmt.Errorf("no dex files found in '%v'", p)
+	}
+	return dd, nil
+}
+
+// javaName returns the Java name of a type descriptor such as Lpkg/Class;.
+func javaName(descriptor string) string {
+	if strings.HasPrefix(descriptor, "L") && strings.HasSuffix(descriptor, ";") {
+		return strings.Replace(descriptor[1:len(descriptor)-1], "/", ".", -1)
+	}
+	return descriptor
+}
+
+// javaPackage returns the Java package of a type descriptor.
+func javaPackage(descriptor string) string {
+	name := javaName(descriptor)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return "(default)"
+}
+
+// dexdump prints what the dex files of an APK, or a dex file, define and
+// reference: method and field reference counts, which the 65536 limit of a
+// dex file applies to, methods referenced per package, the classes defined,
+// and references to the APIs given with -find.
+func dexdump(arguments []string) {
+	fs := flag.NewFlagSet("dexdump", flag.ExitOnError)
+	classes := fs.Bool("classes", false, "List the classes defined and how many methods each declares")
+	find := fs.String("find", "", "A comma-separated list of classes or packages, such as android/hardware/Camera, to list the references to")
+	top := fs.Int("top", 20, "The number of packages with the most method references to list")
+	fs.Parse(arguments)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: blade dexdump [flags] <app.apk|classes.dex>\n")
+		os.Exit(2)
+	}
+	dd, err := readDexFiles(fs.Arg(0))
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if *find != "" {
+		found := 0
+		for _, q := range strings.Split(*find, ",") {
+			q = "L" + strings.Replace(strings.TrimSpace(q), ".", "/", -1)
+			for _, d := range dd {
+				for _, ref := range append(append([]string(nil), d.methods...), d.fields...) {
+					if strings.HasPrefix(ref, q) {
+						fmt.Printf("%v: %v\n", d.name, javaName(ref[:strings.Index(ref, "->")])+"."+ref[strings.Index(ref, "->")+2:])
+						found++
+					}
+				}
+			}
+		}
+		if found == 0 {
+			fmt.Fprintf(os.Stderr, "no references to %v found\n", *find)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *classes {
+		for _, d := range dd {
+			for _, c := range d.classes {
+				fmt.Printf("%v %v\n", javaName(c), d.classMethods[c])
+			}
+		}
+		return
+	}
+
+	perPackage := make(map[string]int)
+	for _, d := range dd {
+		fmt.Printf("%v: %v classes, %v method references, %v field references (of at most 65536 each)\n", d.name, len(d.classes), len(d.methods), len(d.fields))
+		for _, c := range d.methodClass {
+			perPackage[javaPackage(c)]++
+		}
+	}
+	packages := make([]string, 0, len(perPackage))
+	for p := range perPackage {
+		packages = append(packages, p)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if perPackage[packages[i]] != perPackage[packages[j]] {
+			return perPackage[packages[i]] > perPackage[packages[j]]
+		}
+		return packages[i] < packages[j]
+	})
+	if len(packages) > *top {
+		packages = packages[:*top]
+	}
+	fmt.Printf("\nmethod references by package:\n")
+	for _, p := range packages {
+		fmt.Printf("%8v  %v\n", perPackage[p], p)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// testdata/classes.dex defines com.example.app.MainActivity, which extends
+// android.app.Activity and has an int field, count, a constructor and
+// onCreate.
+func readDex(t *testing.T) []byte {
+	b, err := ioutil.ReadFile("testdata/classes.dex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseDex(t *testing.T) {
+	d, err := parseDex("classes.dex", readDex(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &dexFile{
+		name:         "classes.dex",
+		strings:      []string{"<init>", "I", "Landroid/app/Activity;", "Lcom/example/app/MainActivity;", "V", "count", "onCreate"},
+		types:        []string{"Landroid/app/Activity;", "Lcom/example/app/MainActivity;", "V", "I"},
+		methods:      []string{"Landroid/app/Activity;-><init>", "Lcom/example/app/MainActivity;-><init>", "Lcom/example/app/MainActivity;->onCreate"},
+		methodClass:  []string{"Landroid/app/Activity;", "Lcom/example/app/MainActivity;", "Lcom/example/app/MainActivity;"},
+		fields:       []string{"Lcom/example/app/MainActivity;->count"},
+		classes:      []string{"Lcom/example/app/MainActivity;"},
+		classMethods: map[string]int{"Lcom/example/app/MainActivity;": 2},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("parsed %+v, want %+v", d, want)
+	}
+}
+
+func TestParseDexTruncated(t *testing.T) {
+	b := readDex(t)
+	// The last of the file that is read are the four counts that begin the
+	// class_data_item of the class, as its fields and methods are only
+	// counted.
+	classDefs := binary.LittleEndian.Uint32(b[0x64:])
+	end := int(binary.LittleEndian.Uint32(b[classDefs+24:])) + 4
+	for n := 0; n < len(b); n++ {
+		_, err := parseDex("classes.dex", b[:n])
+		if n < end && err == nil {
+			t.Errorf("parsing the first %v of %v bytes returned no error", n, len(b))
+		}
+	}
+}
+
+func TestParseDexOutOfRange(t *testing.T) {
+	b := readDex(t)
+	// The class of the first method becomes an index past the end of the
+	// types.
+	methodIDs := binary.LittleEndian.Uint32(b[0x5c:])
+	b[methodIDs] = 0xff
+	if _, err := parseDex("classes.dex", b); err == nil {
+		t.Errorf("parsing a method of a class out of range returned no error")
+	}
+}
+
+func TestJavaName(t *testing.T) {
+	tests := []struct{ descriptor, name, pkg string }{
+		{"Lcom/example/app/MainActivity;", "com.example.app.MainActivity", "com.example.app"},
+		{"LMain;", "Main", "(default)"},
+		{"I", "I", "(default)"},
+	}
+	for _, tt := range tests {
+		if got := javaName(tt.descriptor); got != tt.name {
+			t.Errorf("javaName(%q) = %q, want %q", tt.descriptor, got, tt.name)
+		}
+		if got := javaPackage(tt.descriptor); got != tt.pkg {
+			t.Errorf("javaPackage(%q) = %q, want %q", tt.descriptor, got, tt.pkg)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// emulatorBootTimeout is how long an emulator has to finish booting, which
+// from a cold start on CI machines without hardware acceleration is slow.
+const emulatorBootTimeout = 10 * time.Minute
+
+// hasSnapshot reports whether the running emulator has a snapshot named name.
+func hasSnapshot(d device, name string) (bool, error) {
+	out, err := d.adb("emu", "avd", "snapshot", "list")
+	if err != nil {
+		return false, err
+	}
+	for _, l := range strings.Split(out, "\n") {
+		for _, f := range strings.Fields(l) {
+			if f == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// emulator runs the subcommand of `blade emulator` named by the first
+// argument, which boot emulators from a snapshot and reset them to it so
+// that each run on a device starts from the same state:
+//
+//	blade emulator boot -avd Pixel_API_34 -snapshot clean
+//	blade emulator reset -snapshot clean
+//	blade emulator save -snapshot clean
+func emulator(arguments []string) {
+	usage := "Usage: blade emulator boot|save|reset [flags]\n"
+	if len(arguments) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("emulator "+arguments[0], flag.ExitOnError)
+	sdkFlag := fs.String("sdk", "", sdkDesc)
+	port := fs.Int("port", 5554, "The console port of the emulator, which is also in its serial, e.g. emulator-5554")
+	snapshot := fs.String("snapshot", "", "The name of the snapshot to boot from, save, or reset to")
+	var avd *string
+	var headless *bool
+	if arguments[0] == "boot" {
+		avd = fs.String("avd", "", "The name of the Android Virtual Device to boot, as listed by `emulator -list-avds`")
+		headless = fs.Bool("headless", false, "Boot without a window, as on CI")
+	}
+	color := registerColor(fs)
+	fs.Parse(arguments[1:])
+	applyColor(fs, *color)
+	sdk, err := sdkHome(*sdkFlag)
+	if err != nil {
+		exitWithError(err)
+	}
+	d := device{sdk: sdk, serial: fmt.Sprintf("emulator-%v", *port)}
+
+	switch arguments[0] {
+	case "boot":
+		err = bootEmulator(d, *avd, *port, *snapshot, *headless)
+	case "save":
+		if *snapshot == "" {
+			err = fmt.Errorf("the snapshot to save must be named with -snapshot")
+			break
+		}
+		_, err = d.adb("emu", "avd", "snapshot", "save", *snapshot)
+	case "reset":
+		if *snapshot == "" {
+			err = fmt.Errorf("the snapshot to reset to must be named with -snapshot")
+			break
+		}
+		if _, err = d.adb("emu", "avd", "snapshot", "load", *snapshot); err == nil {
+			err = d.waitForBoot(emulatorBootTimeout)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// bootEmulator starts the emulator of the AVD in the background and waits
+// for it to boot. Given a snapshot, it boots from the snapshot, which is
+// left unchanged when the emulator exits, or else boots cold and saves the
+// snapshot once booted, so that later boots are quick.
+func bootEmulator(d device, avd string, port int, snapshot string, headless bool) error {
+	if avd == "" {
+		return fmt.Errorf("the Android Virtual Device to boot must be named with -avd")
+	}
+	args := []string{"-avd", avd, "-port", fmt.Sprint(port), "-no-boot-anim"}
+	if headless {
+		args = append(args, "-no-window", "-no-audio")
+	}
+	if snapshot != "" {
+		args = append(args, "-snapshot", snapshot, "-no-snapshot-save")
+	}
+	cmd := exec.Command(d.sdk+"/emulator/emulator", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start emulator due to error: %v", err)
+	}
+	// The emulator outlives blade, for later commands to run against.
+	cmd.Process.Release()
+	if err := d.waitForBoot(emulatorBootTimeout); err != nil {
+		return err
+	}
+	if snapshot != "" {
+		ok, err := hasSnapshot(d, snapshot)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if _, err := d.adb("emu", "avd", "snapshot", "save", snapshot); err != nil {
+				return err
+			}
+			fmt.Printf("saved snapshot '%v' of %v after its first boot\n", snapshot, avd)
+		}
+	}
+	fmt.Printf("%v booted as %v\n", avd, d.serial)
+	return nil
+}
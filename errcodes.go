@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Codes of the classes of failure that `blade explain` describes, which are
+// stable so that they can be searched for and referred to. New codes are
+// added at the end of their hundred, and codes are never reused.
+const (
+	errSDKNotFound        = "BLADE1001"
+	errKeystoreNotFound   = "BLADE1002"
+	errInvalidFlags       = "BLADE1003"
+	errMissingBuildTools  = "BLADE1007"
+	errMissingPlatform    = "BLADE1008"
+	errPreflight          = "BLADE1009"
+	errInvalidConfig      = "BLADE1101"
+	errUnknownVariant     = "BLADE1102"
+	errInvalidManifest    = "BLADE1103"
+	errUnresolvedManifest = "BLADE1104"
+	errResources          = "BLADE1201"
+	errCompile            = "BLADE1202"
+	errDex                = "BLADE1203"
+	errPackage            = "BLADE1204"
+	errSign               = "BLADE1205"
+	errGenerator          = "BLADE1206"
+	errLayouts            = "BLADE1207"
+	errRoomSchema         = "BLADE1208"
+	errGoogleServices     = "BLADE1209"
+	errPublish            = "BLADE1210"
+	errResourceRefs       = "BLADE1211"
+	errZopfliPNG          = "BLADE1212"
+	errProto              = "BLADE1213"
+	errZopfliAPK          = "BLADE1214"
+	errSignChecksums      = "BLADE1215"
+	errRemoteOrContainer  = "BLADE1301"
+	errPolicy             = "BLADE1401"
+	errSizeIncrease       = "BLADE1402"
+	errIncompatibleDevice = "BLADE1501"
+	errDeviceUnauthorized = "BLADE1502"
+	errDeviceOffline      = "BLADE1503"
+	errADBServer          = "BLADE1504"
+	errNoDevice           = "BLADE1505"
+	errAmbiguousDevice    = "BLADE1506"
+)
+
+// explanation describes a class of failure and how to remedy it.
+type explanation struct {
+	summary string
+	detail  string
+}
+
+var explanations = map[string]explanation{
+	errSDKNotFound: {"The Android SDK could not be found", `
+blade runs the tools of an installed Android SDK, which it finds through the
+ANDROID_HOME environment variable or the -sdk flag.
+
+To fix this, install the command-line tools from developer.android.com and
+either export ANDROID_HOME=/path/to/sdk or pass -sdk /path/to/sdk.`},
+	errKeystoreNotFound: {"The debug signing keystore is missing", `
+APKs are signed with the debug key in the keystore given by -keystore, or
+else by $BLADE_DEBUG_KEYSTORE, debug_keystore in blade.toml, or the
+debug.keystore in $ANDROID_USER_HOME or ~/.android, in that order. The last
+is created by Android Studio but otherwise must be created once by hand with:
+
+	keytool -genkey -v -keystore ~/.android/debug.keystore -storepass android \
+		-alias androiddebugkey -keypass android -keyalg RSA -keysize 2048 -validity 10000`},
+	errInvalidFlags: {"The flags given cannot be used together or are invalid", `
+Some flags exclude each other, such as -remote and -container, and others
+only accept certain values. Run blade -h to see every flag and its values.`},
+	errMissingBuildTools: {"No build-tools are installed in the SDK", `
+blade uses aapt, d8 and zipalign from the newest version of build-tools in
+the SDK's build-tools directory, which is empty or missing.
+
+To fix this, install a version of build-tools with sdkmanager, e.g.:
+
+	$ANDROID_HOME/tools/bin/sdkmanager --install 'build-tools;28.0.3'`},
+	errMissingPlatform: {"No platforms are installed in the SDK", `
+Apps are compiled against the android.jar of the newest platform in the
+SDK's platforms directory, which is empty or missing.
+
+To fix this, install a platform with sdkmanager, e.g.:
+
+	$ANDROID_HOME/tools/bin/sdkmanager --install 'platforms;android-28'`},
+	errPreflight: {"The build environment is not ready for the build to start", `
+Before any stage runs, blade checks that the output directory and the user's
+cache directory can be written to and have at least min_free_space free, by
+default 512MB, and that the tools the build runs from PATH are installed,
+such as javac, or protoc with -proto. Each problem found is
+listed; free up disk space, fix the directories' permissions or install the
+tools, or lower min_free_space in blade.toml.`},
+	errInvalidConfig: {"blade.toml could not be read or is invalid", `
+The config file, blade.toml beside where blade runs or given with -config,
+must be valid TOML whose keys have the types blade expects. The message says
+which key is wrong.`},
+	errUnknownVariant: {"The variant to build is not declared", `
+Besides debug and release, variants must be declared in blade.toml as a
+[variant.<name>] table before being built with -variant <name>.`},
+	errInvalidManifest: {"AndroidManifest.xml could not be read or parsed", `
+The manifest, AndroidManifest.xml where blade runs or given with -manifest,
+must exist and be well-formed XML with a package attribute on its root. Its
+version and SDK levels must not also be declared under [app] in blade.toml,
+which blade migrate resolves by removing them from the manifest.`},
+	errUnresolvedManifest: {"The manifest uses placeholders that have no value", `
+Placeholders such as ${hostName} in the manifest are replaced by values from
+the [variant.<name>.placeholders] table of the variant being built, which is
+missing some of them. Declare them, or remove them from the manifest.`},
+	errResources: {"aapt could not compile the app's resources", `
+The output of aapt above names the resource file and line it failed on, which
+is usually malformed XML, a reference to a resource that does not exist, or
+a resource file name with characters other than a-z, 0-9, _ and . in it.`},
+	errCompile: {"javac could not compile the app's Java sources", `
+The output of javac above names each source file and line it failed on.`},
+	errDex: {"d8 could not translate the app's bytecode to dex", `
+This is commonly due to compiling for a newer Java release than d8 supports,
+which -java-release lowers, or due to exceeding 65536 methods in one dex.
+Laying out the dex by a startup profile needs d8 8.2 or later, from a newer
+build-tools or declared with path under [tools.d8].`},
+	errPackage: {"The APK could not be packaged or aligned", `
+aapt or zipalign failed to write the APK, for which the output above gives
+the cause, commonly a lack of disk space or permissions on the output.`},
+	errSign: {"The APK could not be signed", `
+apksigner failed to sign the APK with the debug keystore, which is commonly
+due to a keystore whose password is not "android", build-tools older than
+24.0.3 without apksigner, or -sign-schemes naming v3 with build-tools older
+than 28, or v4 with build-tools older than 30.
+For variants with a sign_command, that command failed or wrote no APK to {out}.`},
+	errGenerator: {"A [[generator]] declared in blade.toml failed", `
+The generator's command failed or could not be found. It runs from the
+config file's directory with {out} replaced by its output directory.`},
+	errLayouts: {"Layouts use attributes or views that do not exist", `
+Each problem above gives the layout file and line of an attribute that is not
+declared by the platform or by the app's <declare-styleable> and <attr>
+resources, or of a custom view whose class was not compiled. Such layouts
+would crash the app when inflated. Should the check be wrong about an app,
+set check_layouts = false in blade.toml to skip it.`},
+	errRoomSchema: {"A Room database schema changed without a version bump", `
+With require_version_bump under [room] in blade.toml, the exported schema of
+a database version must not change. Increment the version of the @Database
+and add a migration for it, so that installed apps can upgrade.`},
+	errGoogleServices: {"google-services.json has no client for the app", `
+google-services.json lists the apps of a Firebase project by package name,
+which must include the application ID being built, including any suffix of
+the variant. Register the application ID in the Firebase console and
+download google-services.json again.`},
+	errPublish: {"An artifact of the build could not be published", `
+With -publish, each [[publish]] in blade.toml uploads the APKs, metadata and
+checksums, with an HTTP PUT to its url or by running its command. Check that
+the endpoint is reachable, that any credentials its headers read from the
+environment are set, and the output above of the endpoint or command.`},
+	errResourceRefs: {"Code refers to resource IDs that no resource has", `
+Resource IDs written as numbers, such as getString(0x7f0b0012), are not
+checked by javac and go stale when resources are added or removed, failing
+with Resources.NotFoundException at runtime. Refer to the resource through
+R instead, such as getString(R.string.app_name), which javac checks.`},
+	errZopfliPNG: {"zopflipng could not recompress the app's PNGs", `
+With zopfli_pngs set for the variant in blade.toml, each PNG resource other
+than nine-patches is recompressed with zopflipng, which must be on PATH or
+declared with path under [tools.zopflipng]. It is commonly installed with
+the zopfli package, and fails on PNGs that are corrupt, which the output above
+names.`},
+	errProto: {"protoc could not generate Java from the protocol buffers", `
+With -proto, the .proto files under it are compiled with protoc, which must be
+on PATH or declared with path under [tools.protoc], into Java for the
+protobuf-javalite runtime given with -protobuf-runtime. The output of protoc
+above names the file and line it failed on, which is commonly an import
+that is not under the -proto directory, as only it is searched for imports.`},
+	errZopfliAPK: {"zopfli could not recompress the APK", `
+With -zopfli, the compressed entries of each APK are recompressed with zopfli
+before it is signed, which must be on PATH or declared with path under
+[tools.zopfli]. It is commonly installed with the zopfli package. The output
+above gives the cause, which is otherwise commonly a lack of disk space in
+the output directory, where the entries are recompressed.`},
+	errSignChecksums: {"The checksums of the build could not be signed", `
+With -sign-checksums gpg, checksums.txt is signed with gpg's default key,
+which must exist and be usable without a passphrase prompt, such as through
+gpg-agent. With -sign-checksums sigstore, it is signed with cosign sign-blob,
+which must be installed and, without a key, sign in through OIDC, which on CI
+needs an identity token such as that of GitHub Actions' id-token permission.
+The output above of gpg or cosign gives the cause.`},
+	errRemoteOrContainer: {"The remote host or container could not run the build", `
+With -remote, the host must be reachable with ssh without a password prompt,
+have rsync, and have the SDK and JDK at the same paths as locally. With
+-container, docker or podman must be installed and the image must exist.`},
+	errPolicy: {"The APK violates the assertions of the policy file", `
+blade verify checks an APK against the assertions of a policy file, such as
+permissions it must not request or the only ABIs it may package, each of
+which it lists when violated. Change the build so that the APK satisfies
+them, or change the policy if the assertion no longer holds.`},
+	errSizeIncrease: {"An APK grew by more than -fail-on-size-increase allows", `
+Each build records the sizes of its APKs in .blade/sizes.jsonl under the output
+directory, which blade size history lists. Given -fail-on-size-increase, a
+build fails if any APK grew by more than that since the last build of the
+variant recorded, which stays the one compared against until a build passes.
+Find what grew with -report, which breaks each APK down by what takes up its
+size, and shrink it, or raise the limit if the growth is expected.`},
+	errIncompatibleDevice: {"The APK cannot run on the device", `
+Before installing, blade checks that the device's API level is at least the
+APK's minSdk, and that the device supports one of the ABIs the APK packages
+native libraries for, if any, which pm would otherwise refuse with
+INSTALL_FAILED_OLDER_SDK or INSTALL_FAILED_NO_MATCHING_ABIS. Choose another
+device with -device, such as an emulator of a newer system image or of the
+device's ABI, or build the app with a lower minSdk or libraries for its ABI.`},
+	errDeviceUnauthorized: {"The device has not authorized this computer", `
+A device with USB debugging enabled asks with an "Allow USB debugging?" dialog
+before trusting the RSA key of a computer. Accept it, ticking "Always allow
+from this computer" so that it is not asked again. If no dialog shows,
+reconnect the device, or revoke the USB debugging authorizations in its
+developer options and reconnect it.`},
+	errDeviceOffline: {"The device is offline", `
+adb sees the device but cannot talk to it, which happens while it boots, after
+it sleeps, or when its USB connection is flaky. blade reconnects and retries
+a few times. Should it stay offline, reconnect the device, restart it, or
+restart adb with blade adb kill-server.`},
+	errADBServer: {"adb could not reach its server", `
+adb talks to devices through a server listening on port 5037, which blade
+restarts and retries a few times when it cannot be reached or answers in an
+unexpected way. That persists when something else listens on the port, or
+another version of adb, such as that of another SDK or a phone vendor's
+tool, keeps restarting its own server. Stop it, or use the same SDK for both.`},
+	errNoDevice: {"No device, or not the device asked for, is connected", `
+Commands run against a device need one to be connected with USB debugging
+enabled, an emulator, which blade emulator boot starts, or one connected to
+over wireless debugging with blade connect. With -device or $ANDROID_SERIAL,
+the serial must be one of those listed by blade adb devices.`},
+	errAmbiguousDevice: {"More than one device is connected", `
+With several devices or emulators connected, choose the one to run against
+with -device or $ANDROID_SERIAL, given its serial as listed by blade adb
+devices, or a name remembered with blade connect -name.`},
+}
+
+// withCode prefixes the error's message with the code of its class.
+func withCode(code string, err error) error {
+	return fmt.Errorf("%v: %v", code, err)
+}
+
+var errorCode = regexp.MustCompile(`BLADE\d{4}`)
+
+// explainHint returns a line suggesting `blade explain` for the first error
+// code in the error's message, if it has one.
+func explainHint(err error) string {
+	code := errorCode.FindString(err.Error())
+	if code == "" {
+		return ""
+	}
+	return fmt.Sprintf("run `blade explain %v` for more about this error\n", code)
+}
+
+// explain prints the explanation of the error code given, or a list of
+// every code when none is given.
+func explain(arguments []string) {
+	if len(arguments) == 0 {
+		codes := make([]string, 0, len(explanations))
+		for code := range explanations {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Printf("%v  %v\n", code, explanations[code].summary)
+		}
+		return
+	}
+	code := strings.ToUpper(arguments[0])
+	e, ok := explanations[code]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no error has the code '%v', run `blade explain` to list every code\n", arguments[0])
+		os.Exit(1)
+	}
+	fmt.Printf("%v: %v\n%v\n", code, e.summary, e.detail)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	bladeRepo         = "https://github.com/aoeu/blade"
+	bladeSrclib       = "blade"
+	defaultFDroidDir  = "fdroid"
+	fdroidBuildsIndex = "Builds:\n"
+)
+
+// fdroidConfig holds the F-Droid metadata that cannot be derived from the
+// build, which is read from the [fdroid] table of the config:
+//
+//	[fdroid]
+//	license = "GPL-3.0-only"
+//	categories = ["Reading"]
+//	repo = "https://github.com/example/app"
+//	blade_ref = "v1.4.0"
+type fdroidConfig struct {
+	license    string
+	categories []string
+	// repo is the app's git repository, by default the URL of the origin
+	// remote.
+	repo string
+	// bladeRef is the commit or tag of blade that F-Droid builds the app
+	// with, by default master.
+	bladeRef string
+}
+
+var defaultFDroidConfig = fdroidConfig{bladeRef: "master"}
+
+func newFDroidConfig(t map[string]interface{}) (fdroidConfig, error) {
+	f := defaultFDroidConfig
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid [fdroid] config: %v", err)
+	}
+	var err error
+	if f.license, err = stringValue(t, "license"); err != nil {
+		return f, wrap(err)
+	}
+	if f.categories, err = stringList(t, "categories"); err != nil {
+		return f, wrap(err)
+	}
+	if f.repo, err = stringValue(t, "repo"); err != nil {
+		return f, wrap(err)
+	}
+	if _, ok := t["blade_ref"]; ok {
+		if f.bladeRef, err = stringValue(t, "blade_ref"); err != nil {
+			return f, wrap(err)
+		}
+	}
+	return f, nil
+}
+
+var yamlPlain = regexp.MustCompile(`^[A-Za-z_/$][A-Za-z0-9_./$ -]*$`)
+
+// yamlString returns s as a YAML scalar, quoted unless it would be read as
+// something other than the string it is, such as a number.
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) && !strings.HasSuffix(s, " ") {
+		switch strings.ToLower(s) {
+		case "true", "false", "yes", "no", "on", "off", "null":
+		default:
+			return s
+		}
+	}
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// fdroidBuild returns the entry of the Builds list of F-Droid's metadata
+// that builds the version of the app at commit, by building blade from its
+// srclib and running it with the flags given.
+func fdroidBuild(versionName string, versionCode int, commit, bladeRef string, flags []string, output string) string {
+	var w strings.Builder
+	fmt.Fprintf(&w, "  - versionName: %v\n", yamlString(versionName))
+	fmt.Fprintf(&w, "    versionCode: %v\n", versionCode)
+	fmt.Fprintf(&w, "    commit: %v\n", yamlString(commit))
+	fmt.Fprintf(&w, "    sudo:\n      - apt-get update\n      - apt-get install -y golang-go\n")
+	fmt.Fprintf(&w, "    srclibs:\n      - %v\n", yamlString(bladeSrclib+"@"+bladeRef))
+	command := append([]string{"$$" + bladeSrclib + "$$/blade", "-sdk", "$$SDK$$"}, flags...)
+	fmt.Fprintf(&w, "    build:\n      - cd $$%v$$ && go build -o blade .\n      - %v\n", bladeSrclib, yamlString(strings.Join(command, " ")))
+	fmt.Fprintf(&w, "    output: %v\n", yamlString(output))
+	return w.String()
+}
+
+// addFDroidBuild adds the build entry to existing metadata and makes it the
+// current version, unless the metadata already has a build of the version code.
+func addFDroidBuild(metadata, entry string, versionName string, versionCode int) (string, error) {
+	if regexp.MustCompile(`(?m)^\s+versionCode: ` + strconv.Itoa(versionCode) + `\s*$`).MatchString(metadata) {
+		return "", fmt.Errorf("the metadata already has a build of version code %v", versionCode)
+	}
+	i := strings.Index(metadata, fdroidBuildsIndex)
+	if i < 0 {
+		return "", fmt.Errorf("no Builds list found in the metadata to add to")
+	}
+	// The list ends at the next top-level key.
+	end := len(metadata)
+	if loc := regexp.MustCompile(`(?m)^[A-Za-z]`).FindStringIndex(metadata[i+len(fdroidBuildsIndex):]); loc != nil {
+		end = i + len(fdroidBuildsIndex) + loc[0]
+	}
+	builds := strings.TrimRight(metadata[i:end], "\n") + "\n"
+	metadata = metadata[:i] + builds + entry + "\n" + metadata[end:]
+	metadata = regexp.MustCompile(`(?m)^CurrentVersion: .*$`).ReplaceAllLiteralString(metadata, "CurrentVersion: "+yamlString(versionName))
+	metadata = regexp.MustCompile(`(?m)^CurrentVersionCode: .*$`).ReplaceAllLiteralString(metadata, "CurrentVersionCode: "+strconv.Itoa(versionCode))
+	return metadata, nil
+}
+
+// fdroidCommand writes the metadata that F-Droid builds the app from, in the
+// layout of the fdroiddata repository: metadata/<applicationId>.yml, with an
+// entry in its Builds list for the current version, and srclibs/blade.yml,
+// from which F-Droid builds blade itself. The version is built from its
+// release tag, as `blade release` creates.
+func fdroidCommand(arguments []string) {
+	fs := flag.NewFlagSet("fdroid", flag.ExitOnError)
+	args := &buildArgs{}
+	args.register(fs)
+	dir := fs.String("o", defaultFDroidDir, "The directory to write F-Droid's metadata and srclibs directories to, such as a checkout of fdroiddata")
+	own := map[string]bool{"o": true, "sdk": true, "remote": true, "container": true, "out": true}
+	args.parse(fs, arguments)
+
+	b, err := newBuild(args)
+	if err != nil {
+		exitWithError(err)
+	}
+	f := b.config.fdroid
+	if f.repo == "" {
+		out, err := git("remote", "get-url", "origin")
+		if err != nil {
+			exitWithError(fmt.Errorf("no repo is given under [fdroid] in config and the origin remote could not be read: %v", err))
+		}
+		f.repo = strings.TrimSpace(out)
+	}
+	versionCode, err := b.manifest.versionCode()
+	if err != nil {
+		exitWithError(withCode(errInvalidManifest, err))
+	}
+	if b.manifest.VersionName == "" {
+		exitWithError(fmt.Errorf("no version name is declared in the manifest or config, which F-Droid requires"))
+	}
+
+	// F-Droid builds in the root of the app's repository, where blade is run
+	// from, into the default output directory.
+	flags := make([]string, 0)
+	fs.Visit(func(fl *flag.Flag) {
+		if !own[fl.Name] {
+			flags = append(flags, "-"+fl.Name, fl.Value.String())
+		}
+	})
+	tag := b.config.release.tagPrefix + b.manifest.VersionName
+	entry := fdroidBuild(b.manifest.VersionName, versionCode, tag, f.bladeRef, flags, b.outputs[0].filepath)
+
+	p := filepath.Join(*dir, "metadata", b.applicationID+".yml")
+	var metadata string
+	existing, err := ioutil.ReadFile(p)
+	switch {
+	case os.IsNotExist(err):
+		var w strings.Builder
+		if f.license != "" {
+			fmt.Fprintf(&w, "License: %v\n", yamlString(f.license))
+		}
+		if len(f.categories) > 0 {
+			w.WriteString("Categories:\n")
+			for _, c := range f.categories {
+				fmt.Fprintf(&w, "  - %v\n", yamlString(c))
+			}
+		}
+		fmt.Fprintf(&w, "SourceCode: %v\n\n", yamlString(f.repo))
+		fmt.Fprintf(&w, "RepoType: git\nRepo: %v\n\n", yamlString(f.repo))
+		w.WriteString(fdroidBuildsIndex + entry + "\n")
+		w.WriteString("AutoUpdateMode: None\nUpdateCheckMode: Tags\n")
+		fmt.Fprintf(&w, "CurrentVersion: %v\nCurrentVersionCode: %v\n", yamlString(b.manifest.VersionName), versionCode)
+		metadata = w.String()
+	case err != nil:
+		exitWithError(fmt.Errorf("could not read F-Droid metadata at '%v' due to error: %v", p, err))
+	default:
+		if metadata, err = addFDroidBuild(string(existing), entry, b.manifest.VersionName, versionCode); err != nil {
+			exitWithError(fmt.Errorf("could not add to F-Droid metadata at '%v' due to error: %v", p, err))
+		}
+	}
+
+	srclib := filepath.Join(*dir, "srclibs", bladeSrclib+".yml")
+	files := map[string]string{
+		p:      metadata,
+		srclib: fmt.Sprintf("RepoType: git\nRepo: %v\n", bladeRepo),
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+			exitWithError(fmt.Errorf("could not create directory for '%v' due to error: %v", path, err))
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0664); err != nil {
+			exitWithError(fmt.Errorf("could not write '%v' due to error: %v", path, err))
+		}
+	}
+	fmt.Printf("wrote build of %v (%v) to %v\n", b.manifest.VersionName, versionCode, p)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+// freeSpace returns the disk space available in the file system of dir, and
+// whether it could be determined, which it cannot be on this platform.
+func freeSpace(dir string) (int64, bool) {
+	return 0, false
+}
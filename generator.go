@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const outputDirForGenerators = "generators"
+
+// generator is a custom code generation task declared in blade.toml as:
+//
+//	[[generator]]
+//	name = "openapi"
+//	command = ["openapi-generator", "generate", "-i", "api.yaml", "-g", "java", "-o", "{out}"]
+//	inputs = ["api.yaml"]
+//	kind = "java"
+//
+// The command runs from the config file's directory, with {out} replaced by
+// the generator's output directory, and only when its inputs have changed
+// since it last ran. The output directory is then added to the Java source
+// directories (kind "java", the default) or resource directories (kind "res").
+type generator struct {
+	name    string
+	command []string
+	inputs  []string
+	output  string
+	kind    string
+}
+
+func newGenerator(t map[string]interface{}) (generator, error) {
+	g := generator{}
+	var err error
+	if g.name, err = stringValue(t, "name"); err != nil {
+		return g, err
+	}
+	if g.name == "" {
+		return g, fmt.Errorf("every [[generator]] in config must have a name")
+	}
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid generator '%v': %v", g.name, err)
+	}
+	if g.command, err = stringList(t, "command"); err != nil {
+		return g, wrap(err)
+	}
+	if len(g.command) == 0 {
+		return g, wrap(fmt.Errorf("a command must be provided"))
+	}
+	if g.inputs, err = stringList(t, "inputs"); err != nil {
+		return g, wrap(err)
+	}
+	if g.output, err = stringValue(t, "output"); err != nil {
+		return g, wrap(err)
+	}
+	if g.kind, err = stringValue(t, "kind"); err != nil {
+		return g, wrap(err)
+	}
+	switch g.kind {
+	case "":
+		g.kind = "java"
+	case "java", "res":
+	default:
+		return g, wrap(fmt.Errorf("kind must be 'java' or 'res' but was '%v'", g.kind))
+	}
+	return g, nil
+}
+
+// outputDir returns where the generator writes its output, which is
+// relative to the build's output directory unless configured as absolute.
+func (g generator) outputDir(buildOutputDir string) string {
+	switch {
+	case g.output == "":
+		return filepath.Join(buildOutputDir, outputDirForGenerators, g.name)
+	case filepath.IsAbs(g.output):
+		return g.output
+	default:
+		return filepath.Join(buildOutputDir, g.output)
+	}
+}
+
+// inputFiles returns the files the generator's inputs name.
+func (g generator) inputFiles(c *config) ([]string, error) {
+	patterns := make([]string, len(g.inputs))
+	for i, s := range g.inputs {
+		patterns[i] = c.resolve(s)
+	}
+	return expandInputs(patterns)
+}
+
+// run executes the generator unless its inputs and command are unchanged
+// since the last successful run and its output still exists.
+func (g generator) run(c *config, buildOutputDir string, stdout, stderr io.Writer) error {
+	out := g.outputDir(buildOutputDir)
+	command := make([]string, len(g.command))
+	for i, s := range g.command {
+		command[i] = strings.Replace(s, "{out}", out, -1)
+	}
+	inputs, err := g.inputFiles(c)
+	if err != nil {
+		return err
+	}
+	fp, err := fingerprint(inputs, command...)
+	if err != nil {
+		return err
+	}
+	stamp := stampPath(buildOutputDir, "generator-"+g.name)
+	if _, err := os.Stat(out); err == nil && len(g.inputs) > 0 && isUpToDate(stamp, fp) {
+		return nil
+	}
+	if err := os.MkdirAll(out, 0774); err != nil {
+		return fmt.Errorf("could not create output directory '%v' due to error: %v", out, err)
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = c.dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error when running command %v : %v", strings.Join(command, " "), err)
+	}
+	return writeStamp(stamp, fp)
+}
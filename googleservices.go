@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	googleServicesFilename              = "google-services.json"
+	outputDirForGoogleServicesResources = "generated_google_services_resources"
+	// googleServicesWebClientType is the client_type of OAuth web clients.
+	googleServicesWebClientType = 3
+)
+
+// googleServices is the subset of a google-services.json file downloaded
+// from the Firebase console that apps read at runtime, as string resources.
+type googleServices struct {
+	ProjectInfo struct {
+		ProjectNumber string `json:"project_number"`
+		FirebaseURL   string `json:"firebase_url"`
+		ProjectID     string `json:"project_id"`
+		StorageBucket string `json:"storage_bucket"`
+	} `json:"project_info"`
+	Client []struct {
+		ClientInfo struct {
+			MobileSDKAppID    string `json:"mobilesdk_app_id"`
+			AndroidClientInfo struct {
+				PackageName string `json:"package_name"`
+			} `json:"android_client_info"`
+		} `json:"client_info"`
+		OAuthClient []struct {
+			ClientID   string `json:"client_id"`
+			ClientType int    `json:"client_type"`
+		} `json:"oauth_client"`
+		APIKey []struct {
+			CurrentKey string `json:"current_key"`
+		} `json:"api_key"`
+	} `json:"client"`
+}
+
+// googleServicesFilepath returns the google-services.json file to build
+// with, which is either the one provided as a flag or one beside the
+// manifest, or "" if there is none.
+func googleServicesFilepath(args *buildArgs) (string, error) {
+	if args.googleServicesFilepath != "" {
+		p, err := filepath.Abs(args.googleServicesFilepath)
+		if err != nil {
+			return "", fmt.Errorf("could not locate %v at filepath '%v' due to error: %v", googleServicesFilename, args.googleServicesFilepath, err)
+		}
+		return p, nil
+	}
+	p := filepath.Join(filepath.Dir(args.androidManifestFilepath), googleServicesFilename)
+	if _, err := os.Stat(p); err != nil {
+		return "", nil
+	}
+	return p, nil
+}
+
+// writeGoogleServicesResources writes the string resources that the Google
+// Services Gradle plugin would generate from the google-services.json file
+// at path for the app with applicationID, under resDir.
+func writeGoogleServicesResources(path, resDir, applicationID string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read '%v' due to error: %v", path, err)
+	}
+	var g googleServices
+	if err := json.Unmarshal(b, &g); err != nil {
+		return fmt.Errorf("could not parse '%v' due to error: %v", path, err)
+	}
+	values := map[string]string{
+		"gcm_defaultSenderId":   g.ProjectInfo.ProjectNumber,
+		"firebase_database_url": g.ProjectInfo.FirebaseURL,
+		"project_id":            g.ProjectInfo.ProjectID,
+		"google_storage_bucket": g.ProjectInfo.StorageBucket,
+	}
+	found := false
+	packages := make([]string, 0, len(g.Client))
+	for _, c := range g.Client {
+		if c.ClientInfo.AndroidClientInfo.PackageName != applicationID {
+			packages = append(packages, c.ClientInfo.AndroidClientInfo.PackageName)
+			continue
+		}
+		found = true
+		values["google_app_id"] = c.ClientInfo.MobileSDKAppID
+		if len(c.APIKey) > 0 {
+			values["google_api_key"] = c.APIKey[0].CurrentKey
+			values["google_crash_reporting_api_key"] = c.APIKey[0].CurrentKey
+		}
+		for _, o := range c.OAuthClient {
+			if o.ClientType == googleServicesWebClientType {
+				values["default_web_client_id"] = o.ClientID
+				break
+			}
+		}
+		break
+	}
+	if !found {
+		return withCode(errGoogleServices, fmt.Errorf("no client for package '%v' found in '%v', only for: %v", applicationID, path, strings.Join(packages, ", ")))
+	}
+
+	names := make([]string, 0, len(values))
+	for name, v := range values {
+		if v != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var w strings.Builder
+	w.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(&w, "<!-- Generated by blade from %v -->\n<resources>\n", filepath.Base(path))
+	for _, name := range names {
+		fmt.Fprintf(&w, "    <string name=\"%v\" translatable=\"false\">%v</string>\n", name, escapeStringResource(values[name]))
+	}
+	w.WriteString("</resources>\n")
+
+	dir := filepath.Join(resDir, "values")
+	if err := os.MkdirAll(dir, 0774); err != nil {
+		return fmt.Errorf("could not create Google Services resources directory due to error: %v", err)
+	}
+	p := filepath.Join(dir, "google_services.xml")
+	if err := ioutil.WriteFile(p, []byte(w.String()), 0664); err != nil {
+		return fmt.Errorf("could not write Google Services resources to '%v' due to error: %v", p, err)
+	}
+	return nil
+}
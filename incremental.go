@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+)
+
+const depfilesDir = ".blade/deps"
+
+// depfilePath returns where the files read by the named stage when it last
+// ran are recorded, as a Makefile-style depfile that ninja can also read.
+func depfilePath(outputDir, name string) string {
+	return filepath.Join(outputDir, depfilesDir, strings.NewReplacer(":", "-", "/", "-").Replace(name)+".d")
+}
+
+// runIncrementally runs the stage unless it declares its outputs, they all
+// still exist, and neither the files it read nor the flags it reads have
+// changed since it last ran. Either way the stage's stamp is written, which
+// records its fingerprint and tells ninja that the stage is up to date.
+//
+// So touching a drawable re-runs aapt to generate R.java, but javac only runs
+// again if the generated R.java is different. It reports whether the stage
+// was skipped.
+func (b *build) runIncrementally(s *stage, t *toolchain) (bool, error) {
+	stamp := stageStampPath(b.args.outputDir, s.name)
+	if s.outputs == nil {
+		if err := s.run(t); err != nil {
+			return false, err
+		}
+		return false, b.writeStageStamp(s, stamp, s.name)
+	}
+	files, err := s.readFiles()
+	if err != nil {
+		return false, err
+	}
+	fp, err := fingerprint(files, s.name, b.argsFingerprint(s.flags))
+	if err != nil {
+		return false, err
+	}
+	if exist(s.outputs...) && isUpToDate(stamp, fp) {
+		return true, b.writeStageStamp(s, stamp, fp)
+	}
+	if err := s.run(t); err != nil {
+		return false, err
+	}
+	if err := writeDepfile(depfilePath(b.args.outputDir, s.name), stamp, files); err != nil {
+		return false, err
+	}
+	return false, b.writeStageStamp(s, stamp, fp)
+}
+
+// argsFingerprint returns the values of the named build flags as
+// fingerprinted. The arguments as given also hold flags such as -j that do
+// not change what stages output, which the parsed flags already account for.
+func (b *build) argsFingerprint(names []string) string {
+	args := &buildArgs{}
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	args.register(fs)
+	// The flags are of the fields of args, which then hold the build's.
+	*args = *b.args
+	var w strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&w, "-%v=%v\x00", name, fs.Lookup(name).Value)
+	}
+	return w.String()
+}
+
+// inputsFingerprint returns a digest of everything the build reads, less the
+// intermediates it writes itself: the sources and resources of every stage,
+// the config file, the flags and the version of blade.
+func (b *build) inputsFingerprint() (string, error) {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, s := range b.stages() {
+		if s.inputs == nil {
+			continue
+		}
+		ff, err := s.inputs()
+		if err != nil {
+			return "", err
+		}
+		for _, f := range ff {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	if _, err := os.Stat(b.config.path); err == nil && !seen[b.config.path] {
+		files = append(files, b.config.path)
+	}
+	version := "(devel)"
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		version = bi.Main.Version
+	}
+	return fingerprint(files, version, b.argsFingerprint(buildFlagNames()))
+}
+
+// buildFlagNames returns the names of every build flag.
+func buildFlagNames() []string {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	(&buildArgs{}).register(fs)
+	names := make([]string, 0)
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}
+
+// fingerprintCommand prints the digest of the inputs of the build described
+// by the build flags. CI can key a cache on it to skip a job entirely when
+// nothing it builds from has changed. The SDK and JDK are not read, so a
+// cache key should also name the image or machine that provides them.
+func fingerprintCommand(arguments []string) {
+	fs := flag.NewFlagSet("fingerprint", flag.ExitOnError)
+	args := &buildArgs{}
+	args.register(fs)
+	args.parse(fs, arguments)
+
+	b, err := newBuild(args)
+	if err != nil {
+		exitWithError(err)
+	}
+	fp, err := b.inputsFingerprint()
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Println(fp)
+}
+
+func (b *build) writeStageStamp(s *stage, stamp, fp string) error {
+	if err := writeStamp(stamp, fp); err != nil {
+		return fmt.Errorf("could not record stage '%v' as run due to error: %v", s.name, err)
+	}
+	return nil
+}
+
+// readFiles returns the stage's inputs and intermediates, once the stages it
+// depends on have run.
+func (s *stage) readFiles() ([]string, error) {
+	files := make([]string, 0)
+	for _, f := range []func() ([]string, error){s.inputs, s.intermediates} {
+		if f == nil {
+			continue
+		}
+		ff, err := f()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, ff...)
+	}
+	return files, nil
+}
+
+// writeDepfile records that target depends on files, in the Makefile syntax
+// ninja reads with `deps = gcc`.
+func writeDepfile(path, target string, files []string) error {
+	var w strings.Builder
+	fmt.Fprintf(&w, "%v:", depfileEscape(target))
+	for _, f := range files {
+		if abs, err := filepath.Abs(f); err == nil {
+			f = abs
+		}
+		fmt.Fprintf(&w, " \\\n  %v", depfileEscape(f))
+	}
+	fmt.Fprintln(&w)
+	if err := os.MkdirAll(filepath.Dir(path), 0774); err != nil {
+		return fmt.Errorf("could not create depfile directory due to error: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(w.String()), 0664); err != nil {
+		return fmt.Errorf("could not write depfile '%v' due to error: %v", path, err)
+	}
+	return nil
+}
+
+func depfileEscape(s string) string {
+	return strings.NewReplacer(" ", "\\ ", "#", "\\#", "$", "$$").Replace(s)
+}
+
+// exist reports whether every one of paths exists.
+func exist(paths ...string) bool {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// clearDir empties the directory at path, creating it if need be, so that
+// files from a previous run of a stage do not outlive their sources.
+func clearDir(path string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("could not clear directory '%v' due to error: %v", path, err)
+	}
+	return makeOutputDirs(path)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// inspectDirs are the directories of the app's data directory that `blade
+// inspect` lists and pulls from, by what they hold.
+var inspectDirs = map[string]string{
+	"db":    "databases",
+	"prefs": "shared_prefs",
+	"files": "files",
+}
+
+// sqliteSidecars are the suffixes of the files SQLite keeps beside a
+// database, which hold changes not yet written to it.
+var sqliteSidecars = []string{"-wal", "-shm", "-journal"}
+
+// inspect lists or pulls the SQLite databases, shared preferences or files
+// of a debuggable build from the device with run-as, for looking into the
+// app's state with tools on the host:
+//
+//	blade inspect db
+//	blade inspect db -o /tmp app.db && sqlite3 /tmp/app.db
+//	blade inspect prefs settings
+//
+// Databases are pulled along with their write-ahead logs, so that changes
+// the app has not yet checkpointed are seen.
+func inspect(arguments []string) {
+	usage := "Usage: blade inspect db|prefs|files [flags] [<name>...]\n"
+	if len(arguments) < 1 || inspectDirs[arguments[0]] == "" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	kind, dir := arguments[0], inspectDirs[arguments[0]]
+	var out *string
+	d, pkg, names := appCommand("inspect "+kind, arguments[1:], func(fs *flag.FlagSet) {
+		out = fs.String("o", ".", "The directory to pull the files named to")
+	})
+	files, err := listAppFiles(d, pkg, dir)
+	if err != nil {
+		exitWithError(fmt.Errorf("could not list %v of '%v', which must be a debuggable build, due to error: %v", dir, pkg, err))
+	}
+	if len(names) == 0 {
+		for _, f := range files {
+			if kind != "db" || !isSQLiteSidecar(f) {
+				fmt.Println(f)
+			}
+		}
+		return
+	}
+
+	have := make(map[string]bool)
+	for _, f := range files {
+		have[f] = true
+	}
+	for _, name := range names {
+		if kind == "prefs" && !strings.HasSuffix(name, ".xml") {
+			name += ".xml"
+		}
+		if !have[name] {
+			exitWithError(fmt.Errorf("'%v' has no '%v' in %v, run `blade inspect %v` to list what it has", pkg, name, dir, kind))
+		}
+		pull := []string{name}
+		if kind == "db" {
+			for _, suffix := range sqliteSidecars {
+				if have[name+suffix] {
+					pull = append(pull, name+suffix)
+				}
+			}
+		}
+		for _, f := range pull {
+			local := filepath.Join(*out, filepath.FromSlash(f))
+			if err := pullAppFile(d, pkg, dir+"/"+f, local); err != nil {
+				exitWithError(fmt.Errorf("could not pull '%v' of '%v' due to error: %v", f, pkg, err))
+			}
+		}
+		fmt.Printf("pulled %v\n", filepath.Join(*out, filepath.FromSlash(name)))
+	}
+}
+
+// listAppFiles returns the files under dir of the app's data directory,
+// relative to it, or none if it does not exist.
+func listAppFiles(d device, pkg, dir string) ([]string, error) {
+	out, err := d.adb("shell", "run-as", pkg, "sh", "-c", shellQuote("test ! -d "+dir+" || find "+dir+" -type f"))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	for _, l := range strings.Split(out, "\n") {
+		if f := strings.TrimPrefix(strings.TrimSpace(l), dir+"/"); f != "" {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func isSQLiteSidecar(name string) bool {
+	for _, suffix := range sqliteSidecars {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// pullAppFile copies the file at path, relative to the app's data directory,
+// to local.
+func pullAppFile(d device, pkg, path, local string) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0774); err != nil {
+		return err
+	}
+	f, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	// exec-out, unlike shell, does not mangle binary output with a pty.
+	err = d.pipe(nil, f, "exec-out", "run-as", pkg, "cat", shellQuote(path))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(local)
+	}
+	return err
+}
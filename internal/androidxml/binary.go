@@ -0,0 +1,225 @@
+package androidxml
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Chunk types of the binary XML that aapt compiles manifests into, as
+// defined in ResourceTypes.h of the Android framework.
+const (
+	axmlStringPool   = 0x0001
+	axmlDocument     = 0x0003
+	axmlResourceMap  = 0x0180
+	axmlStartElement = 0x0102
+	axmlEndElement   = 0x0103
+)
+
+// Types of the typed values of attributes.
+const (
+	axmlTypeReference = 0x01
+	axmlTypeString    = 0x03
+	axmlTypeIntDec    = 0x10
+	axmlTypeIntHex    = 0x11
+	axmlTypeBoolean   = 0x12
+)
+
+// axmlAttributeIDs names the framework attributes by resource ID, for
+// manifests whose attribute names have been stripped by shrinking.
+var axmlAttributeIDs = map[uint32]string{
+	0x01010003: "name",
+	0x0101000f: "debuggable",
+	0x0101020c: "minSdkVersion",
+	0x0101021b: "versionCode",
+	0x0101021c: "versionName",
+	0x01010270: "targetSdkVersion",
+}
+
+// ParseBinary parses the binary XML in b, such as the AndroidManifest.xml of
+// an APK, and returns its root element.
+func ParseBinary(b []byte) (*Element, error) {
+	if len(b) < 8 || binary.LittleEndian.Uint16(b) != axmlDocument {
+		return nil, fmt.Errorf("not a binary XML document")
+	}
+	var strs []string
+	var ids []uint32
+	var root *Element
+	stack := make([]*Element, 0)
+	off := int(binary.LittleEndian.Uint16(b[2:]))
+	for off+8 <= len(b) {
+		typ := binary.LittleEndian.Uint16(b[off:])
+		size := int(binary.LittleEndian.Uint32(b[off+4:]))
+		if size < 8 || off+size > len(b) {
+			return nil, fmt.Errorf("chunk at offset %#x has invalid size %v", off, size)
+		}
+		chunk := b[off : off+size]
+		switch typ {
+		case axmlStringPool:
+			var err error
+			if strs, err = parseStringPool(chunk); err != nil {
+				return nil, err
+			}
+		case axmlResourceMap:
+			hs := int(binary.LittleEndian.Uint16(chunk[2:]))
+			for i := hs; i+4 <= len(chunk); i += 4 {
+				ids = append(ids, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+		case axmlStartElement:
+			e, err := parseStartElement(chunk, strs, ids)
+			if err != nil {
+				return nil, err
+			}
+			if len(stack) > 0 {
+				p := stack[len(stack)-1]
+				p.Children = append(p.Children, e)
+			} else if root == nil {
+				root = e
+			}
+			stack = append(stack, e)
+		case axmlEndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+		off += size
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no elements found in binary XML document")
+	}
+	return root, nil
+}
+
+// parseStringPool returns the strings of a string pool chunk, which are
+// encoded as either UTF-8 or UTF-16.
+func parseStringPool(chunk []byte) ([]string, error) {
+	if len(chunk) < 28 {
+		return nil, fmt.Errorf("string pool is truncated")
+	}
+	count := int(binary.LittleEndian.Uint32(chunk[8:]))
+	isUTF8 := binary.LittleEndian.Uint32(chunk[16:])&(1<<8) != 0
+	start := int(binary.LittleEndian.Uint32(chunk[20:]))
+	hs := int(binary.LittleEndian.Uint16(chunk[2:]))
+	if hs+4*count > len(chunk) {
+		return nil, fmt.Errorf("string pool of %v strings is truncated", count)
+	}
+	strs := make([]string, count)
+	for i := range strs {
+		p := start + int(binary.LittleEndian.Uint32(chunk[hs+4*i:]))
+		var ok bool
+		if isUTF8 {
+			strs[i], ok = utf8PoolString(chunk, p)
+		} else {
+			strs[i], ok = utf16PoolString(chunk, p)
+		}
+		if !ok {
+			return nil, fmt.Errorf("string %v of string pool is truncated", i)
+		}
+	}
+	return strs, nil
+}
+
+// utf8PoolString returns the UTF-8 string at p, which is preceded by its
+// lengths in UTF-16 code units and in bytes, each of one or two bytes.
+func utf8PoolString(b []byte, p int) (string, bool) {
+	length := func() int {
+		if p >= len(b) {
+			return -1
+		}
+		n := int(b[p])
+		p++
+		if n&0x80 != 0 {
+			if p >= len(b) {
+				return -1
+			}
+			n = (n&0x7f)<<8 | int(b[p])
+			p++
+		}
+		return n
+	}
+	length()
+	n := length()
+	if n < 0 || p+n > len(b) {
+		return "", false
+	}
+	return string(b[p : p+n]), true
+}
+
+// utf16PoolString returns the UTF-16 string at p, which is preceded by its
+// length in code units, of one or two code units.
+func utf16PoolString(b []byte, p int) (string, bool) {
+	if p+2 > len(b) {
+		return "", false
+	}
+	n := int(binary.LittleEndian.Uint16(b[p:]))
+	p += 2
+	if n&0x8000 != 0 {
+		if p+2 > len(b) {
+			return "", false
+		}
+		n = (n&0x7fff)<<16 | int(binary.LittleEndian.Uint16(b[p:]))
+		p += 2
+	}
+	if p+2*n > len(b) {
+		return "", false
+	}
+	u := make([]uint16, n)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[p+2*i:])
+	}
+	return string(utf16.Decode(u)), true
+}
+
+func parseStartElement(chunk []byte, strs []string, ids []uint32) (*Element, error) {
+	hs := int(binary.LittleEndian.Uint16(chunk[2:]))
+	if hs+20 > len(chunk) {
+		return nil, fmt.Errorf("element is truncated")
+	}
+	ext := chunk[hs:]
+	str := func(i uint32) string {
+		if int(i) < len(strs) {
+			return strs[i]
+		}
+		return ""
+	}
+	e := &Element{Name: str(binary.LittleEndian.Uint32(ext[4:])), Attrs: make(map[string]string)}
+	start := int(binary.LittleEndian.Uint16(ext[8:]))
+	size := int(binary.LittleEndian.Uint16(ext[10:]))
+	count := int(binary.LittleEndian.Uint16(ext[12:]))
+	if size < 20 || start+size*count > len(ext) {
+		return nil, fmt.Errorf("attributes of element '%v' are truncated", e.Name)
+	}
+	for i := 0; i < count; i++ {
+		a := ext[start+size*i:]
+		nameIndex := binary.LittleEndian.Uint32(a[4:])
+		name := str(nameIndex)
+		if int(nameIndex) < len(ids) {
+			if n, ok := axmlAttributeIDs[ids[nameIndex]]; ok && name == "" {
+				name = n
+			}
+		}
+		raw := binary.LittleEndian.Uint32(a[8:])
+		dataType := a[15]
+		data := binary.LittleEndian.Uint32(a[16:])
+		var v string
+		switch {
+		case raw != 0xffffffff:
+			v = str(raw)
+		case dataType == axmlTypeString:
+			v = str(data)
+		case dataType == axmlTypeIntDec:
+			v = strconv.Itoa(int(int32(data)))
+		case dataType == axmlTypeIntHex:
+			v = fmt.Sprintf("%#x", data)
+		case dataType == axmlTypeBoolean:
+			v = strconv.FormatBool(data != 0)
+		case dataType == axmlTypeReference:
+			v = fmt.Sprintf("@%#08x", data)
+		default:
+			v = fmt.Sprintf("%#x", data)
+		}
+		e.Attrs[name] = v
+	}
+	return e, nil
+}
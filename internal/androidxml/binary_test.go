@@ -0,0 +1,122 @@
+package androidxml
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// testdata/AndroidManifest.axml is a manifest in the binary XML that aapt
+// compiles manifests of APKs into, with UTF-16 strings and an attribute whose
+// name was stripped, leaving only its resource ID:
+//
+//	<manifest package="com.example.app" android:versionCode="7" android:versionName="1.2">
+//	    <uses-sdk android:minSdkVersion="21" android:targetSdkVersion="30" />
+//	    <application android:label="@0x7f010000" android:debuggable="true">
+//	        <activity android:name=".MainActivity">
+//	            <intent-filter>
+//	                <action android:name="android.intent.action.MAIN" />
+//	                <category android:name="android.intent.category.LAUNCHER" />
+//	            </intent-filter>
+//	        </activity>
+//	    </application>
+//	</manifest>
+func readBinaryManifest(t *testing.T) []byte {
+	b, err := ioutil.ReadFile("testdata/AndroidManifest.axml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseBinaryXML(t *testing.T) {
+	root, err := ParseBinary(readBinaryManifest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  []string
+		attr  string
+		value string
+	}{
+		{nil, "package", "com.example.app"},
+		{nil, "versionCode", "7"},
+		{nil, "versionName", "1.2"},
+		{[]string{"uses-sdk"}, "minSdkVersion", "21"},
+		{[]string{"uses-sdk"}, "targetSdkVersion", "30"},
+		{[]string{"application"}, "label", "@0x7f010000"},
+		{[]string{"application"}, "debuggable", "true"},
+		{[]string{"application", "activity"}, "name", ".MainActivity"},
+		{[]string{"application", "activity", "intent-filter", "action"}, "name", "android.intent.action.MAIN"},
+		{[]string{"application", "activity", "intent-filter", "category"}, "name", "android.intent.category.LAUNCHER"},
+	}
+	if root.Name != "manifest" {
+		t.Errorf("root element is '%v', want 'manifest'", root.Name)
+	}
+	for _, tt := range tests {
+		found := root.Find(tt.path...)
+		if len(found) != 1 {
+			t.Errorf("found %v elements at %v, want 1", len(found), tt.path)
+			continue
+		}
+		if v := found[0].Attrs[tt.attr]; v != tt.value {
+			t.Errorf("%v of %v is '%v', want '%v'", tt.attr, tt.path, v, tt.value)
+		}
+	}
+}
+
+func TestParseBinaryXMLTruncated(t *testing.T) {
+	b := readBinaryManifest(t)
+	// The string pool, which follows the 8 bytes of the document's header,
+	// precedes every element, so no prefix that ends within it has any.
+	poolEnd := 8 + int(binary.LittleEndian.Uint32(b[12:]))
+	for n := 0; n < len(b); n++ {
+		_, err := ParseBinary(b[:n])
+		if n < poolEnd && err == nil {
+			t.Errorf("parsing the first %v of %v bytes returned no error", n, len(b))
+		}
+	}
+	if _, err := ParseBinary([]byte("<manifest/>")); err == nil || err.Error() != "not a binary XML document" {
+		t.Errorf("parsing text XML returned error %v, want 'not a binary XML document'", err)
+	}
+}
+
+func TestParseStringPool(t *testing.T) {
+	b := readBinaryManifest(t)
+	pool := b[8 : 8+binary.LittleEndian.Uint32(b[12:])]
+	strs, err := parseStringPool(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(strs) != 22 || strs[0] != "versionCode" || strs[21] != "android.intent.category.LAUNCHER" {
+		t.Errorf("parsed strings %q", strs)
+	}
+	for _, n := range []int{0, 27, 40, len(pool) - 8} {
+		_, err := parseStringPool(pool[:n])
+		if err == nil || !strings.Contains(err.Error(), "truncated") {
+			t.Errorf("parsing the first %v of %v bytes of the string pool returned error %v, want it to be truncated", n, len(pool), err)
+		}
+	}
+}
+
+func TestUTF8PoolString(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want string
+		ok   bool
+	}{
+		{[]byte{3, 3, 'a', 'b', 'c', 0}, "abc", true},
+		{[]byte{2, 3, 0xc3, 0xa9, 'x', 0}, "éx", true},
+		{append([]byte{0x80, 0x81, 0x80, 0x81}, []byte(strings.Repeat("a", 0x81))...), strings.Repeat("a", 0x81), true},
+		{[]byte{3, 3, 'a', 'b'}, "", false},
+		{[]byte{0x80}, "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		s, ok := utf8PoolString(tt.b, 0)
+		if s != tt.want || ok != tt.ok {
+			t.Errorf("utf8PoolString(%q) = %q, %v, want %q, %v", tt.b, s, ok, tt.want, tt.ok)
+		}
+	}
+}
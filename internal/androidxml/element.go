@@ -0,0 +1,28 @@
+// Package androidxml reads the elements of Android XML documents, such as
+// manifests, from either their source text or the binary XML that aapt
+// compiles them into, so that source manifests and those of APKs are read
+// alike.
+package androidxml
+
+// Element is an element of a binary or text XML document, with its
+// attributes by local name, since the attributes of manifests rarely collide
+// across namespaces.
+type Element struct {
+	Name     string
+	Attrs    map[string]string
+	Children []*Element
+}
+
+// Find returns the descendants of e at the path of element names.
+func (e *Element) Find(path ...string) []*Element {
+	if len(path) == 0 {
+		return []*Element{e}
+	}
+	found := make([]*Element, 0)
+	for _, c := range e.Children {
+		if c.Name == path[0] {
+			found = append(found, c.Find(path[1:]...)...)
+		}
+	}
+	return found
+}
@@ -0,0 +1,46 @@
+package androidxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Parse parses the text XML in b, such as a source AndroidManifest.xml, and
+// returns its root element.
+func Parse(b []byte) (*Element, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	var root *Element
+	open := make([]*Element, 0)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			e := &Element{Name: t.Name.Local, Attrs: make(map[string]string)}
+			for _, a := range t.Attr {
+				if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+					e.Attrs[a.Name.Local] = a.Value
+				}
+			}
+			if len(open) == 0 {
+				root = e
+			} else {
+				parent := open[len(open)-1]
+				parent.Children = append(parent.Children, e)
+			}
+			open = append(open, e)
+		case xml.EndElement:
+			open = open[:len(open)-1]
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no root element found")
+	}
+	return root, nil
+}
@@ -0,0 +1,48 @@
+package androidxml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	root, err := Parse([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<!-- A comment. -->
+<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.example.app" android:versionCode="7">
+    <uses-sdk android:minSdkVersion="21" />
+    <application android:label="@string/app_name">
+        <activity android:name=".MainActivity" />
+        <activity android:name=".SettingsActivity" />
+    </application>
+</manifest>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Element{Name: "manifest", Attrs: map[string]string{"package": "com.example.app", "versionCode": "7"}, Children: []*Element{
+		{Name: "uses-sdk", Attrs: map[string]string{"minSdkVersion": "21"}},
+		{Name: "application", Attrs: map[string]string{"label": "@string/app_name"}, Children: []*Element{
+			{Name: "activity", Attrs: map[string]string{"name": ".MainActivity"}},
+			{Name: "activity", Attrs: map[string]string{"name": ".SettingsActivity"}},
+		}},
+	}}
+	if !reflect.DeepEqual(root, want) {
+		t.Errorf("parsed %+v, want %+v", root, want)
+	}
+	if n := len(root.Find("application", "activity")); n != 2 {
+		t.Errorf("found %v activities, want 2", n)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"<!-- only a comment -->",
+		"<manifest>",
+		`<manifest package="a"></application>`,
+	} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("parsing %q returned no error", s)
+		}
+	}
+}
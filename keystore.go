@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	debugKeystoreFilename = "debug.keystore"
+	// keystoreVariable names the environment variable that locates the
+	// debug keystore, which -keystore takes precedence over.
+	keystoreVariable = "BLADE_DEBUG_KEYSTORE"
+)
+
+// debugKeystore returns the keystore to sign APKs with the debug key from,
+// and where it was chosen from, which is the first of: the -keystore flag,
+// $BLADE_DEBUG_KEYSTORE, debug_keystore in config, the debug.keystore in
+// $ANDROID_USER_HOME, and that in $HOME/.android, as the SDK's tools and
+// Android Studio look for it.
+func debugKeystore(args *buildArgs, c *config) (string, string, error) {
+	if args.keystore != "" {
+		p, err := filepath.Abs(args.keystore)
+		if err != nil {
+			return "", "", fmt.Errorf("could not locate keystore at filepath '%v' due to error: %v", args.keystore, err)
+		}
+		return p, "-keystore", nil
+	}
+	if p := os.Getenv(keystoreVariable); p != "" {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", "", fmt.Errorf("could not locate keystore at filepath '%v' due to error: %v", p, err)
+		}
+		return abs, "$" + keystoreVariable, nil
+	}
+	if c.debugKeystore != "" {
+		return c.debugKeystore, "debug_keystore in " + c.path, nil
+	}
+	if dir := os.Getenv("ANDROID_USER_HOME"); dir != "" {
+		return filepath.Join(dir, debugKeystoreFilename), "$ANDROID_USER_HOME", nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("could not locate user's home directory to find signing keystore: %v", err)
+	}
+	return filepath.Join(home, ".android", debugKeystoreFilename), "$HOME/.android", nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	androidNamespace = "http://schemas.android.com/apk/res/android"
+	appNamespace     = "http://schemas.android.com/apk/res-auto"
+	// appNamespacePrefix precedes the package of the app in namespaces used
+	// for its attributes before res-auto existed.
+	appNamespacePrefix = "http://schemas.android.com/apk/res/"
+)
+
+// layoutElementsWithoutClass are elements of layouts that are not views.
+var layoutElementsWithoutClass = map[string]bool{
+	"merge": true, "include": true, "requestFocus": true, "tag": true,
+	"fragment": true, "view": true, "layout": true, "data": true, "variable": true, "import": true,
+}
+
+// layoutChecker cross-checks the attributes and view classes that layouts
+// use against the attributes declared in resources and the compiled classes,
+// to catch typos that would otherwise only crash the app once a layout is
+// inflated.
+type layoutChecker struct {
+	// androidAttrs are the attributes of the platform, or nil if unknown, in
+	// which case the android namespace is not checked.
+	androidAttrs map[string]bool
+	// appAttrs are the attributes declared in the app's own resources.
+	appAttrs map[string]bool
+	// classDirs and jars hold the classes custom views may be.
+	classDirs  []string
+	jars       []string
+	jarClasses map[string]bool
+	// skipClasses leaves view classes unchecked, for apps whose classes are
+	// only in prebuilt dex, which is not searched.
+	skipClasses bool
+}
+
+func newLayoutChecker(resourceDirs []string, platformDir string, classDirs, jars []string) (*layoutChecker, error) {
+	c := &layoutChecker{appAttrs: make(map[string]bool), classDirs: classDirs, jars: jars}
+	files, err := filesUnder(resourceDirs...)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if strings.HasPrefix(filepath.Base(filepath.Dir(f)), "values") && strings.HasSuffix(f, ".xml") {
+			if err := readDeclaredAttrs(f, c.appAttrs); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The platform's attributes are only known from the SDK's copy of its
+	// resources, which may not be installed or may be inside a container.
+	p := filepath.Join(platformDir, "data", "res", "values", "attrs.xml")
+	if _, err := os.Stat(p); err == nil {
+		c.androidAttrs = make(map[string]bool)
+		if err := readDeclaredAttrs(p, c.androidAttrs); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(platformDir, "android.jar")); err == nil {
+		c.jars = append(c.jars, filepath.Join(platformDir, "android.jar"))
+	}
+	return c, nil
+}
+
+// readDeclaredAttrs adds the names of the attr elements in the values file
+// at path to attrs.
+func readDeclaredAttrs(path string, attrs map[string]bool) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read '%v' for declared attributes due to error: %v", path, err)
+	}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse '%v' for declared attributes due to error: %v", path, err)
+		}
+		if e, ok := tok.(xml.StartElement); ok && e.Name.Local == "attr" {
+			for _, a := range e.Attr {
+				if a.Name.Local == "name" {
+					attrs[strings.TrimPrefix(a.Value, "android:")] = true
+				}
+			}
+		}
+	}
+}
+
+// check returns the problems found in each layout under resourceDirs, as
+// "file:line: problem".
+func (c *layoutChecker) check(resourceDirs []string) ([]string, error) {
+	files, err := filesUnder(resourceDirs...)
+	if err != nil {
+		return nil, err
+	}
+	problems := make([]string, 0)
+	for _, f := range files {
+		if !strings.HasPrefix(filepath.Base(filepath.Dir(f)), "layout") || !strings.HasSuffix(f, ".xml") {
+			continue
+		}
+		pp, err := c.checkLayout(f)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, pp...)
+	}
+	return problems, nil
+}
+
+func (c *layoutChecker) checkLayout(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read layout '%v' due to error: %v", path, err)
+	}
+	problems := make([]string, 0)
+	report := func(offset int64, format string, a ...interface{}) {
+		line := bytes.Count(b[:offset], []byte("\n")) + 1
+		problems = append(problems, fmt.Sprintf("%v:%v: %v", path, line, fmt.Sprintf(format, a...)))
+	}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			return problems, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse layout '%v' due to error: %v", path, err)
+		}
+		e, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		class := e.Name.Local
+		if class == "view" {
+			class = ""
+			for _, a := range e.Attr {
+				if a.Name.Space == "" && a.Name.Local == "class" {
+					class = a.Value
+				}
+			}
+		}
+		if !c.skipClasses && strings.Contains(class, ".") && !layoutElementsWithoutClass[class] {
+			found, err := c.hasClass(class)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				report(offset, "view class '%v' was not found among the compiled classes", class)
+			}
+		}
+		for _, a := range e.Attr {
+			var attrs map[string]bool
+			switch {
+			case a.Name.Space == androidNamespace:
+				attrs = c.androidAttrs
+			case a.Name.Space == appNamespace, strings.HasPrefix(a.Name.Space, appNamespacePrefix):
+				attrs = c.appAttrs
+			}
+			if attrs == nil || attrs[a.Name.Local] {
+				continue
+			}
+			if s := closest(a.Name.Local, attrs); s != "" {
+				report(offset, "attribute '%v' on %v is not declared, did you mean '%v'?", a.Name.Local, e.Name.Local, s)
+			} else {
+				report(offset, "attribute '%v' on %v is not declared", a.Name.Local, e.Name.Local)
+			}
+		}
+	}
+}
+
+// hasClass reports whether the class with the fully qualified name, which
+// may be a nested class, is compiled or in any of the jars.
+func (c *layoutChecker) hasClass(name string) (bool, error) {
+	p := strings.Replace(name, ".", "/", -1) + ".class"
+	for _, dir := range c.classDirs {
+		if _, err := os.Stat(filepath.Join(dir, p)); err == nil {
+			return true, nil
+		}
+	}
+	if c.jarClasses == nil {
+		c.jarClasses = make(map[string]bool)
+		for _, jar := range c.jars {
+			r, err := zip.OpenReader(jar)
+			if err != nil {
+				return false, fmt.Errorf("could not open '%v' to list its classes due to error: %v", jar, err)
+			}
+			for _, f := range r.File {
+				c.jarClasses[f.Name] = true
+			}
+			r.Close()
+		}
+	}
+	return c.jarClasses[p], nil
+}
+
+// closest returns the name among names that is at most a few edits from s,
+// if any, preferring the fewest edits and then alphabetical order.
+func closest(s string, names map[string]bool) string {
+	sorted := make([]string, 0, len(names))
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+	best, bestDistance := "", 3
+	for _, n := range sorted {
+		if d := editDistance(s, n); d < bestDistance {
+			best, bestDistance = n, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/aoeu/blade/internal/androidxml"
+)
+
+// manifest holds the parts of an AndroidManifest.xml that the build needs.
+type manifest struct {
+	Package     string
+	VersionCode string
+	VersionName string
+	UsesSDK     struct {
+		MinSDKVersion    string
+		TargetSDKVersion string
+	}
+	// LaunchableActivity is the class of the activity that the launcher
+	// starts, if the manifest declares one.
+	LaunchableActivity string
+}
+
+func readManifest(path string) (*manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest at '%v' due to error: %v", path, err)
+	}
+	root, err := androidxml.Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse manifest at '%v' due to error: %v", path, err)
+	}
+	m := &manifest{
+		Package:            root.Attrs["package"],
+		VersionCode:        root.Attrs["versionCode"],
+		VersionName:        root.Attrs["versionName"],
+		LaunchableActivity: launchableActivity(root),
+	}
+	if m.Package == "" {
+		return nil, fmt.Errorf("no package attribute found on the manifest element of '%v'", path)
+	}
+	for _, e := range root.Find("uses-sdk") {
+		m.UsesSDK.MinSDKVersion, m.UsesSDK.TargetSDKVersion = e.Attrs["minSdkVersion"], e.Attrs["targetSdkVersion"]
+	}
+	return m, nil
+}
+
+// launchableActivity returns the class of the first enabled activity, or
+// alias of one, in the manifest rooted at root whose intent filters have the
+// MAIN action and LAUNCHER category, as the launcher starts, or "" if none
+// does.
+func launchableActivity(root *androidxml.Element) string {
+	for _, app := range root.Find("application") {
+		for _, e := range app.Children {
+			if (e.Name != "activity" && e.Name != "activity-alias") || e.Attrs["enabled"] == "false" {
+				continue
+			}
+			for _, f := range e.Find("intent-filter") {
+				if hasNamed(f, "action", "android.intent.action.MAIN") && hasNamed(f, "category", "android.intent.category.LAUNCHER") {
+					return className(root.Attrs["package"], e.Attrs["name"])
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// hasNamed reports whether e has a child element of the given element name
+// with the given android:name.
+func hasNamed(e *androidxml.Element, element, name string) bool {
+	for _, c := range e.Find(element) {
+		if c.Attrs["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// versionCode returns the manifest's version code, which defaults to 1 as it
+// does on devices when none is declared.
+func (m *manifest) versionCode() (int, error) {
+	if m.VersionCode == "" {
+		return 1, nil
+	}
+	i, err := strconv.Atoi(m.VersionCode)
+	if err != nil {
+		return 0, fmt.Errorf("manifest version code '%v' is not an integer", m.VersionCode)
+	}
+	return i, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// measure runs the subcommand of `blade measure` named by the first argument.
+func measure(arguments []string) {
+	if len(arguments) < 1 || arguments[0] != "startup" {
+		fmt.Fprintf(os.Stderr, "Usage: blade measure startup [flags]\n")
+		os.Exit(2)
+	}
+	var n *int
+	var activity *string
+	d, pkg, _ := appCommand("measure startup", arguments[1:], func(fs *flag.FlagSet) {
+		n = fs.Int("n", 10, "The number of cold launches to measure")
+		activity = fs.String("activity", "", "The activity to launch, such as .MainActivity (default the app's launcher activity)")
+	})
+	if err := measureStartup(d, pkg, *activity, *n); err != nil {
+		exitWithError(err)
+	}
+}
+
+var launchTime = regexp.MustCompile(`(?m)^\s*(TotalTime|WaitTime):\s*(\d+)`)
+
+// measureStartup cold-launches the app n times, stopping it before each
+// launch, and reports the times that `am start -W` measured.
+func measureStartup(d device, pkg, activity string, n int) error {
+	component := pkg + "/" + activity
+	if activity == "" {
+		var err error
+		if component, err = launcherActivity(d, pkg); err != nil {
+			return err
+		}
+	}
+	times := map[string][]float64{"TotalTime": nil, "WaitTime": nil}
+	live := isTerminal(os.Stderr)
+	for i := 0; i < n; i++ {
+		if _, err := d.adb("shell", "am", "force-stop", pkg); err != nil {
+			return err
+		}
+		out, err := d.adb("shell", "am", "start", "-W", "-n", component)
+		if err != nil {
+			return err
+		}
+		mm := launchTime.FindAllStringSubmatch(out, -1)
+		if len(mm) == 0 {
+			return fmt.Errorf("could not launch '%v': %v", component, strings.TrimSpace(out))
+		}
+		for _, m := range mm {
+			ms, _ := strconv.ParseFloat(m[2], 64)
+			times[m[1]] = append(times[m[1]], ms)
+		}
+		if live {
+			fmt.Fprintf(os.Stderr, "\r[%v/%v]", i+1, n)
+		}
+	}
+	if live {
+		fmt.Fprintf(os.Stderr, "\r\x1b[K")
+	}
+	d.adb("shell", "am", "force-stop", pkg)
+
+	fmt.Printf("cold launches of %v: %v\n\n", component, n)
+	fmt.Printf("%-10v %8v %8v %8v %8v\n", "", "mean", "median", "p90", "max")
+	for _, k := range []string{"TotalTime", "WaitTime"} {
+		t := times[k]
+		if len(t) == 0 {
+			continue
+		}
+		fmt.Printf("%-10v %6.0fms %6.0fms %6.0fms %6.0fms\n", k, mean(t), median(t), percentile(t, 90), percentile(t, 100))
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank pth percentile of xs.
+func percentile(xs []float64, p float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	i := int(math.Ceil(p/100*float64(len(s)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return s[i]
+}
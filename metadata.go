@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+const outputMetadataFilepath = "output-metadata.json"
+
+// outputMetadata is the schema of the output-metadata.json file that the
+// Android Gradle Plugin writes next to the APKs it builds, which release
+// tooling commonly parses to find them.
+type outputMetadata struct {
+	Version       int                     `json:"version"`
+	ArtifactType  outputMetadataArtifact  `json:"artifactType"`
+	ApplicationID string                  `json:"applicationId"`
+	VariantName   string                  `json:"variantName"`
+	Elements      []outputMetadataElement `json:"elements"`
+	ElementType   string                  `json:"elementType"`
+}
+
+type outputMetadataArtifact struct {
+	Type string `json:"type"`
+	Kind string `json:"kind"`
+}
+
+type outputMetadataElement struct {
+	Type        string                 `json:"type"`
+	Filters     []outputMetadataFilter `json:"filters"`
+	Attributes  []interface{}          `json:"attributes"`
+	VersionCode int                    `json:"versionCode"`
+	VersionName string                 `json:"versionName"`
+	OutputFile  string                 `json:"outputFile"`
+}
+
+type outputMetadataFilter struct {
+	FilterType string `json:"filterType"`
+	Value      string `json:"value"`
+}
+
+// writeOutputMetadata describes the packaged APKs in an output-metadata.json
+// file at path.
+func writeOutputMetadata(path string, outputs []apkOutput, applicationID, variantName string, m *manifest) error {
+	manifestVersionCode, err := m.versionCode()
+	if err != nil {
+		return err
+	}
+	md := outputMetadata{
+		Version:       3,
+		ArtifactType:  outputMetadataArtifact{Type: "APK", Kind: "Directory"},
+		ApplicationID: applicationID,
+		VariantName:   variantName,
+		Elements:      make([]outputMetadataElement, 0, len(outputs)),
+		ElementType:   "File",
+	}
+	for _, o := range outputs {
+		e := outputMetadataElement{
+			Type:        "SINGLE",
+			Filters:     make([]outputMetadataFilter, 0),
+			Attributes:  make([]interface{}, 0),
+			VersionCode: manifestVersionCode,
+			VersionName: m.VersionName,
+			OutputFile:  o.filepath,
+		}
+		if o.versionCode != 0 {
+			e.VersionCode = o.versionCode
+		}
+		switch {
+		case o.density != "":
+			e.Type = "ONE_OF_MANY"
+			e.Filters = append(e.Filters, outputMetadataFilter{FilterType: "DENSITY", Value: o.density})
+		case len(outputs) > 1:
+			e.Type = "UNIVERSAL"
+		}
+		md.Elements = append(md.Elements, e)
+	}
+	b, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode output metadata due to error: %v", err)
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0664); err != nil {
+		return fmt.Errorf("could not write output metadata to '%v' due to error: %v", path, err)
+	}
+	return nil
+}
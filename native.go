@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// uncompressedNativeLibsMinSDK is the API level from which the platform can
+// load native libraries straight from the APK rather than extracting them.
+const uncompressedNativeLibsMinSDK = 23
+
+// nativeLibs returns the shared libraries the APK packages, which come from
+// under lib/<abi>/ in the raw files directory.
+func (b *build) nativeLibs() ([]string, error) {
+	libs := make([]string, 0)
+	if b.args.rawFilesFilepath == "" {
+		return libs, nil
+	}
+	files, err := filesUnder(filepath.Join(b.args.rawFilesFilepath, "lib"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f, ".so") {
+			libs = append(libs, f)
+		}
+	}
+	return libs, nil
+}
+
+// hasNativeLibs reports whether the APK packages shared libraries.
+func (b *build) hasNativeLibs() bool {
+	libs, err := b.nativeLibs()
+	return err == nil && len(libs) > 0
+}
+
+// uncompressedNativeLibs reports whether the APK stores its shared libraries
+// uncompressed, and page-aligned by zipalign, declaring
+// android:extractNativeLibs="false" so that installs do not extract them,
+// which makes installs faster and takes less disk space, while the APK is
+// larger but downloads compress as well. It does unless the app's minSdk is
+// below 23 or -legacy-native-packaging is given.
+func (b *build) uncompressedNativeLibs() bool {
+	if b.args.legacyNativePackaging || !b.hasNativeLibs() {
+		return false
+	}
+	minSDK, err := strconv.Atoi(b.manifest.UsesSDK.MinSDKVersion)
+	return err == nil && minSDK >= uncompressedNativeLibsMinSDK
+}
+
+// keepNativeLibsInAPK declares android:extractNativeLibs="false" in the
+// manifest at path.
+func keepNativeLibsInAPK(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read manifest at '%v' due to error: %v", path, err)
+	}
+	s, err := setApplicationAttribute(string(b), "android:extractNativeLibs", "false")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(s), 0664); err != nil {
+		return fmt.Errorf("could not write manifest to '%v' due to error: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stageStampPath returns the file touched whenever the named stage has been
+// run on its own, which stands in for the stage's outputs in build.ninja.
+func stageStampPath(outputDir, name string) string {
+	return stampPath(outputDir, "stage-"+strings.NewReplacer(":", "-", "/", "-").Replace(name))
+}
+
+// runStage runs a single stage of the build, without the stages it depends
+// on, as build.ninja files generated by blade do for each of its edges.
+func runStage(arguments []string) {
+	fs := flag.NewFlagSet("stage", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: blade stage <name> [build flags]\n\nRun `blade graph` for the names of stages.\n\n")
+		fs.PrintDefaults()
+	}
+	args := &buildArgs{}
+	args.register(fs)
+	if len(arguments) < 1 || strings.HasPrefix(arguments[0], "-") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := arguments[0]
+	args.parse(fs, arguments[1:])
+	if err := args.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	b, err := newBuild(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	var s *stage
+	for _, ss := range b.stages() {
+		if ss.name == name {
+			s = ss
+		}
+	}
+	if s == nil {
+		fmt.Fprintf(os.Stderr, "no stage named '%v' is in the build, see `blade graph` for those that are\n", name)
+		os.Exit(1)
+	}
+	if err := b.initToolchain(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if _, err := b.runIncrementally(s, b.toolchain); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// generate writes files that let other build systems drive the build.
+func generate(arguments []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	ninja := fs.Bool("ninja", false, "Generate a build.ninja file with an edge per stage of the build, to be run by ninja")
+	output := fs.String("o", "build.ninja", "The location to write the generated file to")
+	args := &buildArgs{}
+	args.register(fs)
+	args.parse(fs, arguments)
+	if !*ninja {
+		fmt.Fprintf(os.Stderr, "nothing to generate, try -ninja\n")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	b, err := newBuild(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	flags := make([]string, 0)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "ninja" && f.Name != "o" {
+			flags = append(flags, shellQuote(fmt.Sprintf("-%v=%v", f.Name, f.Value)))
+		}
+	})
+	s, err := b.ninjaFile(*output, strings.Join(flags, " "))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*output, []byte(s), 0664); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write '%v' due to error: %v\n", *output, err)
+		os.Exit(1)
+	}
+	inputs, err := b.regenerateInputs()
+	if err == nil {
+		err = writeDepfile(ninjaDepfilePath(b.args.outputDir), *output, inputs)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ninjaDepfilePath returns the depfile of the edge that regenerates
+// build.ninja, named so as not to be that of any stage.
+func ninjaDepfilePath(outputDir string) string {
+	return filepath.Join(outputDir, depfilesDir, "build.ninja.d")
+}
+
+// regenerateInputs returns what build.ninja is regenerated when any of
+// changes: the manifest and config, which the stages are described from, and
+// every directory of sources and resources, as adding a file to a directory
+// or removing one from it changes its modification time along with the
+// inputs of the stages that read it.
+func (b *build) regenerateInputs() ([]string, error) {
+	inputs := []string{b.args.androidManifestFilepath}
+	if _, err := os.Stat(b.config.path); err == nil {
+		inputs = append(inputs, b.config.path)
+	}
+	roots := []string{b.args.javaSourcesFilepath, b.args.protoSourcesFilepath, b.args.rawFilesFilepath}
+	roots = append(roots, b.javaOverlays...)
+	for _, d := range b.resourceDirs {
+		// Resources generated into the output directory are not sources.
+		if rel, err := filepath.Rel(b.args.outputDir, d); filepath.IsAbs(d) && (err != nil || strings.HasPrefix(rel, "..")) {
+			roots = append(roots, d)
+		}
+	}
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			switch {
+			case os.IsNotExist(err) && path == root:
+			case err != nil:
+				return err
+			case info.IsDir():
+				inputs = append(inputs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not walk '%v' for source directories due to error: %v", root, err)
+		}
+	}
+	return inputs, nil
+}
+
+// ninjaFile returns the contents of a build.ninja file, to be written to
+// path, which runs each of the build's stages with `blade stage` once the
+// stages it depends on have run, and only when any of its inputs changed.
+// The file regenerates itself when the manifest or config changes, or files
+// are added to or removed from the sources, as listed by a depfile that
+// `blade generate` writes beside those of the stages.
+func (b *build) ninjaFile(path, flags string) (string, error) {
+	blade, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("could not locate the blade executable for build.ninja to run due to error: %v", err)
+	}
+	var w strings.Builder
+	fmt.Fprintf(&w, "# Generated by `blade generate -ninja`, which this file re-runs when the\n")
+	fmt.Fprintf(&w, "# manifest or config changes, or files are added to or removed from the\n")
+	fmt.Fprintf(&w, "# sources, so edits to it will be lost.\n\n")
+	fmt.Fprintf(&w, "ninja_required_version = 1.3\n")
+	fmt.Fprintf(&w, "blade = %v\n", ninjaEscapeValue(blade))
+	fmt.Fprintf(&w, "flags = %v\n\n", ninjaEscapeValue(flags))
+	fmt.Fprintf(&w, "rule stage\n  command = $blade stage $stage $flags\n  description = $stage\n\n")
+	fmt.Fprintf(&w, "rule generate\n  command = $blade generate -ninja -o $out $flags\n  description = regenerating $out\n  generator = 1\n\n")
+
+	regenerateInputs := []string{b.args.androidManifestFilepath}
+	if _, err := os.Stat(b.config.path); err == nil {
+		regenerateInputs = append(regenerateInputs, b.config.path)
+	}
+	fmt.Fprintf(&w, "build %v: generate %v\n", ninjaEscapePath(path), ninjaEscapePaths(regenerateInputs))
+	// Directories of sources may be removed, which ninja only tolerates of
+	// the inputs listed by depfiles.
+	fmt.Fprintf(&w, "  depfile = %v\n\n", ninjaEscapeValue(ninjaDepfilePath(b.args.outputDir)))
+
+	ss := b.stages()
+	for _, s := range ss {
+		inputs := make([]string, 0)
+		if s.inputs != nil {
+			if inputs, err = s.inputs(); err != nil {
+				return "", err
+			}
+		}
+		for i, p := range inputs {
+			if abs, err := filepath.Abs(p); err == nil {
+				inputs[i] = abs
+			}
+		}
+		deps := make([]string, len(s.deps))
+		for i, d := range s.deps {
+			deps[i] = stageStampPath(b.args.outputDir, d)
+		}
+		fmt.Fprintf(&w, "build %v: stage %v", ninjaEscapePath(stageStampPath(b.args.outputDir, s.name)), ninjaEscapePaths(inputs))
+		if len(deps) > 0 {
+			fmt.Fprintf(&w, " | %v", ninjaEscapePaths(deps))
+		}
+		fmt.Fprintf(&w, "\n  stage = %v\n", ninjaEscapeValue(s.name))
+		if s.outputs != nil {
+			// Lets ninja re-run the stage when files written by the stages
+			// it depends on change, not only its declared inputs.
+			fmt.Fprintf(&w, "  depfile = %v\n  deps = gcc\n", ninjaEscapeValue(depfilePath(b.args.outputDir, s.name)))
+		}
+		fmt.Fprintln(&w)
+	}
+	// The build is done once every stage that no other stage depends on is.
+	dependedOn := make(map[string]bool)
+	for _, s := range ss {
+		for _, d := range s.deps {
+			dependedOn[d] = true
+		}
+	}
+	sinks := make([]string, 0)
+	for _, s := range ss {
+		if !dependedOn[s.name] {
+			sinks = append(sinks, stageStampPath(b.args.outputDir, s.name))
+		}
+	}
+	fmt.Fprintf(&w, "default %v\n", ninjaEscapePaths(sinks))
+	return w.String(), nil
+}
+
+func ninjaEscapeValue(s string) string {
+	return strings.Replace(s, "$", "$$", -1)
+}
+
+func ninjaEscapePath(s string) string {
+	return strings.NewReplacer("$", "$$", " ", "$ ", ":", "$:").Replace(s)
+}
+
+func ninjaEscapePaths(ss []string) string {
+	escaped := make([]string, len(ss))
+	for i, s := range ss {
+		escaped[i] = ninjaEscapePath(s)
+	}
+	return strings.Join(escaped, " ")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// packageAPK packages the manifest, resources, assets and raw files, and the
+// dex files in order as classes.dex, classes2.dex and so on, into an unsigned
+// and unaligned APK at out, as the link and add-dex stages of a build do.
+// Either of assetsDir and rawDir may be empty.
+func (t toolchain) packageAPK(manifest string, resourceDirs []string, assetsDir, rawDir string, dexFiles []string, out string) error {
+	var args []string
+	if assetsDir != "" {
+		args = []string{"-A", assetsDir}
+	}
+	if err := t.createUnalignedAndroidApplicationPackage(manifest, resourceDirs, "", args, out, rawDir); err != nil {
+		return withCode(errResources, fmt.Errorf("could not create unaligned APK file due to error: %v", err))
+	}
+	if len(dexFiles) == 0 {
+		return nil
+	}
+	// The dex files are added at the root of the APK under the names the
+	// runtime loads them by, whatever they are named.
+	dir, err := ioutil.TempDir("", "blade-dex")
+	if err != nil {
+		return fmt.Errorf("could not create temporary directory due to error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	names := make([]string, len(dexFiles))
+	for i, f := range dexFiles {
+		names[i] = filepath.Join(dir, "classes.dex")
+		if i > 0 {
+			names[i] = filepath.Join(dir, fmt.Sprintf("classes%v.dex", i+1))
+		}
+		if err := copyTree(f, names[i], nil); err != nil {
+			return err
+		}
+	}
+	if err := t.run(append([]string{t.aaptBin, "add", "-k", out}, names...)...); err != nil {
+		return withCode(errPackage, fmt.Errorf("could not add dex files to APK due to error: %v", err))
+	}
+	return nil
+}
+
+// signAPK signs the APK at in, which may be out itself, to out, aligned,
+// with the variant's sign_command or else with the debug key in the
+// toolchain's keystore by schemes, as the align and sign stages of a build
+// do.
+func (t toolchain) signAPK(c *config, v variant, schemes []string, in, out string) error {
+	unsigned := out + ".unsigned"
+	defer os.Remove(unsigned)
+	if err := t.alignUncompressedDataInZipFileToFourByteBoundariesForFasterMemoryMappingAtRuntime(in, unsigned); err != nil {
+		return withCode(errPackage, fmt.Errorf("Could align bytes of APK file due to error: %v", err))
+	}
+	var err error
+	if len(v.signCommand) > 0 {
+		err = signWithCommand(c, v.signCommand, unsigned, out, t.stdout(), t.stderr())
+	} else {
+		err = t.signAndroidApplicationPackageWithDebugKey(unsigned, out, schemes)
+	}
+	if err != nil {
+		return withCode(errSign, fmt.Errorf("could not sign APK due to error: %v", err))
+	}
+	return nil
+}
+
+// packageCommand packages an unsigned APK from a manifest, resources, assets
+// and raw files, and dex files built elsewhere, without compiling anything,
+// for `blade sign` to sign.
+func packageCommand(arguments []string) {
+	fs := flag.NewFlagSet("package", flag.ExitOnError)
+	args := &buildArgs{}
+	fs.StringVar(&args.androidHome, "sdk", "", sdkDesc)
+	fs.StringVar(&args.androidManifestFilepath, "manifest", "AndroidManifest.xml", manifestDesc)
+	fs.StringVar(&args.xmlResourcesFilepath, "xml", "xml", xmlDesc)
+	fs.StringVar(&args.rawFilesFilepath, "raw", "", rawDesc)
+	assets := fs.String("assets", "", "The parent-folder location of files to package under assets/ in the APK, if any")
+	out := fs.String("o", "app.unsigned.apk", "The location to write the unsigned APK to")
+	args.parse(fs, arguments)
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: blade package [flags] <classes.dex> [<classes2.dex>...]\n")
+		os.Exit(2)
+	}
+	for _, f := range fs.Args() {
+		if !strings.HasSuffix(f, ".dex") {
+			exitWithError(withCode(errInvalidFlags, fmt.Errorf("'%v' is not a .dex file, so dex jars with d8 first or build with -prebuilt-dex", f)))
+		}
+	}
+	if err := args.validate(); err != nil {
+		exitWithError(err)
+	}
+	t, err := newToolchain(args.androidHome, nil)
+	if err != nil {
+		exitWithError(fmt.Errorf("could not ascertain toolchain due to error: %v", err))
+	}
+	if err := t.packageAPK(args.androidManifestFilepath, []string{args.xmlResourcesFilepath}, *assets, args.rawFilesFilepath, fs.Args(), *out); err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf("packaged %v\n", *out)
+}
+
+// signCommand aligns and signs an APK built elsewhere, such as by `blade
+// package`, as a build of the variant would.
+func signCommand(arguments []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	args := &buildArgs{}
+	fs.StringVar(&args.androidHome, "sdk", "", sdkDesc)
+	fs.StringVar(&args.configFilepath, "config", defaultConfigFilepath, configDesc)
+	fs.StringVar(&args.variant, "variant", "debug", "The build variant whose sign_command to sign with, or else the debug key")
+	fs.StringVar(&args.keystore, "keystore", "", keystoreDesc)
+	fs.StringVar(&args.signSchemes, "sign-schemes", defaultSignSchemes, schemesDesc)
+	out := fs.String("o", "", "The location to write the signed APK to (default the APK given, which is replaced)")
+	args.parse(fs, arguments)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: blade sign [flags] <apk>\n")
+		os.Exit(2)
+	}
+	in := fs.Arg(0)
+	if *out == "" {
+		*out = in
+	}
+	if err := args.validate(); err != nil {
+		exitWithError(err)
+	}
+	c, err := loadConfig(args.configFilepath, args.explicitConfig)
+	if err != nil {
+		exitWithError(withCode(errInvalidConfig, fmt.Errorf("could not load config due to error: %v", err)))
+	}
+	v, err := c.variant(args.variant)
+	if err != nil {
+		exitWithError(err)
+	}
+	t, err := newToolchain(args.androidHome, nil)
+	if err != nil {
+		exitWithError(fmt.Errorf("could not ascertain toolchain due to error: %v", err))
+	}
+	t.useTools(c.tools, c.isolateJavaOptions)
+	if len(v.signCommand) == 0 {
+		keystore, source, err := debugKeystore(args, c)
+		if err != nil {
+			exitWithError(err)
+		}
+		if _, err := os.Stat(keystore); err != nil {
+			exitWithError(withCode(errKeystoreNotFound, fmt.Errorf("could not find signing keystore chosen by %v: '%v'%v", source, err, fmt.Sprintf(keystoreCreationCmd, keystore))))
+		}
+		fmt.Fprintf(os.Stderr, "signing with debug keystore %v, as chosen by %v\n", keystore, source)
+		t.keystore = keystore
+	}
+	if err := t.signAPK(c, v, args.signSchemeList(), in, *out); err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf("signed %v\n", *out)
+}
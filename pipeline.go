@@ -0,0 +1,670 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stage is a step of the build pipeline, which may run once all the stages
+// it depends on have completed.
+type stage struct {
+	name string
+	deps []string
+	// inputs returns the files the stage reads, besides those written by the
+	// stages it depends on, or is nil if it reads none.
+	inputs func() ([]string, error)
+	// intermediates returns the files the stage reads that are written by the
+	// stages it depends on, or is nil if it reads none.
+	intermediates func() ([]string, error)
+	// outputs are the files and directories the stage writes. Only stages
+	// that declare their outputs, and do not modify their inputs, are skipped
+	// when none of the files they read have changed since they last ran.
+	outputs []string
+	// flags are the names of the build flags the stage reads, whose values
+	// are fingerprinted along with its files, so that flags it does not
+	// read, such as -publish, do not make it run again.
+	flags []string
+	// run runs the stage with t, whose tools write to the stage's own output.
+	run func(t *toolchain) error
+}
+
+// runStages runs each of ss with run once the stages it depends on have
+// completed, running up to jobs stages at once. Once any stage fails no more
+// are started, and the error is returned when those running have finished.
+func runStages(ss []*stage, jobs int, run func(*stage) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	waiting := make(map[string]int, len(ss))
+	dependents := make(map[string][]*stage, len(ss))
+	ready := make([]*stage, 0)
+	for _, s := range ss {
+		waiting[s.name] = len(s.deps)
+		for _, d := range s.deps {
+			dependents[d] = append(dependents[d], s)
+		}
+		if len(s.deps) == 0 {
+			ready = append(ready, s)
+		}
+	}
+
+	type result struct {
+		s   *stage
+		err error
+	}
+	done := make(chan result)
+	var firstErr error
+	running, finished := 0, 0
+	for {
+		for firstErr == nil && running < jobs && len(ready) > 0 {
+			s := ready[0]
+			ready = ready[1:]
+			running++
+			go func() { done <- result{s, run(s)} }()
+		}
+		if running == 0 {
+			break
+		}
+		r := <-done
+		running--
+		finished++
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		for _, s := range dependents[r.s.name] {
+			if waiting[s.name]--; waiting[s.name] == 0 {
+				ready = append(ready, s)
+			}
+		}
+	}
+	if firstErr == nil && finished < len(ss) {
+		return fmt.Errorf("could not run every stage of the build, as some depend on stages that are not in it or on each other")
+	}
+	return firstErr
+}
+
+// filesUnder returns the files under each of paths, walking directories
+// recursively and skipping paths that do not exist (yet).
+func filesUnder(paths ...string) ([]string, error) {
+	files := make([]string, 0)
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			switch {
+			case os.IsNotExist(err) && path == p:
+			case err != nil:
+				return err
+			case !info.IsDir():
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not walk '%v' for input files due to error: %v", p, err)
+		}
+	}
+	return files, nil
+}
+
+// build holds everything resolved from the build's flags and config that
+// its stages need to run.
+type build struct {
+	args          *buildArgs
+	config        *config
+	variant       variant
+	manifest      *manifest
+	applicationID string
+	outputs       []apkOutput
+	libraries     []string
+	// prebuiltDex are dex files, or jars of them, merged into the app's dex.
+	prebuiltDex    []string
+	javaSourceDirs []string
+	// javaOverlays are the variant's Java sources, which follow the main
+	// ones in javaSourceDirs.
+	javaOverlays     []string
+	resourceDirs     []string
+	manifestFilepath string
+	// googleServices is the google-services.json file, if any.
+	googleServices string
+	// intermediateDirs are kept between builds so that stages can be skipped
+	// when their inputs have not changed, unlike tmpFiles.
+	intermediateDirs []string
+	tmpFiles         []string
+	// toolchain is only needed to run stages, not to describe them.
+	toolchain *toolchain
+	// started is when the build started, which {date} templates.
+	started time.Time
+	// sourceDir is where blade was run from, which git describes the
+	// checkout of, as stages run from the output directory instead.
+	sourceDir string
+}
+
+func newBuild(args *buildArgs) (*build, error) {
+	p, err := filepath.Abs(args.androidManifestFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("could not find AndroidManifest.xml at filepath '%v' due to error: '%v'", args.androidManifestFilepath, err)
+	}
+	args.androidManifestFilepath = p
+
+	p, err = filepath.Abs(args.outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not locate output directory at filepath '%v' due to error: %v", args.outputDir, err)
+	}
+	args.outputDir = p
+
+	// Sources are located from where blade is run, while stages run from
+	// the output directory so that they can build read-only checkouts.
+	for _, p := range []*string{&args.javaSourcesFilepath, &args.xmlResourcesFilepath, &args.protoSourcesFilepath, &args.rawFilesFilepath} {
+		if *p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return nil, fmt.Errorf("could not locate sources at filepath '%v' due to error: %v", *p, err)
+		}
+		*p = abs
+	}
+
+	b := &build{args: args, started: time.Now()}
+	if b.sourceDir, err = os.Getwd(); err != nil {
+		return nil, fmt.Errorf("could not determine working directory due to error: %v", err)
+	}
+	b.config, err = loadConfig(args.configFilepath, args.explicitConfig)
+	if err != nil {
+		return nil, withCode(errInvalidConfig, fmt.Errorf("could not load config due to error: %v", err))
+	}
+	if b.variant, err = b.config.variant(args.variant); err != nil {
+		return nil, err
+	}
+	if args.publish && len(b.config.publishers) == 0 {
+		return nil, withCode(errInvalidFlags, fmt.Errorf("-publish was given but no [[publish]] is declared in config"))
+	}
+	if b.manifest, err = readManifest(args.androidManifestFilepath); err != nil {
+		return nil, withCode(errInvalidManifest, err)
+	}
+	if err := b.config.app.override(b.manifest); err != nil {
+		return nil, withCode(errInvalidManifest, err)
+	}
+	if args.renameManifestPackage == "" && b.variant.applicationIDSuffix != "" {
+		args.renameManifestPackage = b.manifest.Package + b.variant.applicationIDSuffix
+	}
+	b.applicationID = b.manifest.Package
+	if args.renameManifestPackage != "" {
+		b.applicationID = args.renameManifestPackage
+	}
+
+	densitySplits := make([]string, 0)
+	if args.densitySplits != "" {
+		densitySplits = strings.Split(args.densitySplits, ",")
+	}
+	name, err := b.outputName()
+	if err != nil {
+		return nil, withCode(errInvalidConfig, err)
+	}
+	if b.outputs, err = apkOutputs(name, densitySplits, b.manifest); err != nil {
+		return nil, err
+	}
+
+	b.libraries = make([]string, 0)
+	if args.protoSourcesFilepath != "" {
+		if args.protobufRuntimeFilepath == "" {
+			return nil, fmt.Errorf("the protobuf runtime jar must be provided as a flag when building with .proto files")
+		}
+		p, err := filepath.Abs(args.protobufRuntimeFilepath)
+		if err != nil {
+			return nil, fmt.Errorf("could not locate protobuf runtime at filepath '%v' due to error: %v", args.protobufRuntimeFilepath, err)
+		}
+		b.libraries = append(b.libraries, p)
+	}
+	if b.prebuiltDex, err = prebuiltDexFiles(args.prebuiltDex); err != nil {
+		return nil, withCode(errInvalidFlags, err)
+	}
+
+	var resOverlays []string
+	b.javaOverlays, resOverlays, err = b.variant.overlays(b.config, args.javaSourcesFilepath, args.xmlResourcesFilepath)
+	if err != nil {
+		return nil, withCode(errInvalidConfig, err)
+	}
+	b.javaSourceDirs = append([]string{args.javaSourcesFilepath}, b.javaOverlays...)
+	b.javaSourceDirs = append(b.javaSourceDirs, outputDirForGeneratedSourceFiles)
+	b.intermediateDirs = []string{outputDirForGeneratedSourceFiles, outputDirForBytecode}
+	if b.variant.strictMode != "" {
+		b.javaSourceDirs = append(b.javaSourceDirs, outputDirForStrictModeSources)
+	}
+	if b.variant.buildConfigFields != nil {
+		b.javaSourceDirs = append(b.javaSourceDirs, outputDirForBuildConfigSources)
+	}
+	if args.protoSourcesFilepath != "" {
+		b.javaSourceDirs = append(b.javaSourceDirs, outputDirForGeneratedProtoFiles)
+		b.intermediateDirs = append(b.intermediateDirs, outputDirForGeneratedProtoFiles)
+	}
+	// Overlays come first so that their resources take precedence.
+	b.resourceDirs = append(resOverlays, args.xmlResourcesFilepath)
+	for _, g := range b.config.generators {
+		if g.kind == "res" {
+			b.resourceDirs = append(b.resourceDirs, g.outputDir(args.outputDir))
+		} else {
+			b.javaSourceDirs = append(b.javaSourceDirs, g.outputDir(args.outputDir))
+		}
+	}
+
+	if b.googleServices, err = googleServicesFilepath(args); err != nil {
+		return nil, err
+	}
+	if b.googleServices != "" {
+		b.resourceDirs = append(b.resourceDirs, outputDirForGoogleServicesResources)
+	}
+	if b.variant.zopfliPNGs {
+		// Recompressed PNGs take precedence over the originals they are of.
+		b.resourceDirs = append([]string{outputDirForZopfliResources}, b.resourceDirs...)
+	}
+
+	b.manifestFilepath = processedManifestFilepath
+	b.tmpFiles = make([]string, 0)
+	if b.variant.hasResources() {
+		b.resourceDirs = append(b.resourceDirs, outputDirForVariantResources)
+	}
+	for _, o := range b.outputs {
+		if o.density != "" {
+			b.tmpFiles = append(b.tmpFiles, o.densityManifestFilepath())
+		}
+		b.tmpFiles = append(b.tmpFiles, o.unalignedFilepath(), o.unsignedFilepath())
+	}
+	return b, nil
+}
+
+// toolFlags are the build flags that choose the versions of the tools that
+// stages run, which can change what the tools output.
+var toolFlags = []string{"sdk", "container"}
+
+// stages returns the stages of the build in an order they can be run in
+// one after another.
+func (b *build) stages() []*stage {
+	ss := make([]*stage, 0)
+	resourceDeps := []string{"process-manifest"}
+	javaDeps := []string{"generate-r"}
+
+	for _, g := range b.config.generators {
+		g := g
+		name := "generate:" + g.name
+		ss = append(ss, &stage{name: name, inputs: func() ([]string, error) {
+			return g.inputFiles(b.config)
+		}, run: func(t *toolchain) error {
+			if err := g.run(b.config, b.args.outputDir, t.stdout(), t.stderr()); err != nil {
+				return withCode(errGenerator, fmt.Errorf("could not run generator '%v' due to error: %v", g.name, err))
+			}
+			return nil
+		}})
+		if g.kind == "res" {
+			resourceDeps = append(resourceDeps, name)
+		} else {
+			javaDeps = append(javaDeps, name)
+		}
+	}
+
+	manifestOutputs := []string{b.manifestFilepath}
+	if b.variant.hasResources() {
+		manifestOutputs = append(manifestOutputs, outputDirForVariantResources)
+	}
+	if b.variant.strictMode != "" {
+		manifestOutputs = append(manifestOutputs, outputDirForStrictModeSources)
+	}
+	if b.variant.buildConfigFields != nil {
+		manifestOutputs = append(manifestOutputs, outputDirForBuildConfigSources)
+	}
+	ss = append(ss, &stage{name: "process-manifest", inputs: func() ([]string, error) {
+		inputs, err := filesUnder(b.args.androidManifestFilepath, b.config.path)
+		if err != nil {
+			return nil, err
+		}
+		// Whether the manifest keeps native libraries in the APK depends on
+		// whether there are any.
+		libs, err := b.nativeLibs()
+		return append(inputs, libs...), err
+	}, outputs: manifestOutputs, flags: []string{"variant", "rename-manifest-package", "legacy-native-packaging", "raw"}, run: func(t *toolchain) error {
+		err := b.variant.processManifest(b.args.androidManifestFilepath, b.manifestFilepath, outputDirForVariantResources, b.applicationID, b.config.app)
+		if err != nil {
+			return fmt.Errorf("could not process manifest for variant '%v' due to error: %v", b.variant.name, err)
+		}
+		if b.uncompressedNativeLibs() {
+			if err := keepNativeLibsInAPK(b.manifestFilepath); err != nil {
+				return err
+			}
+		}
+		if b.variant.buildConfigFields != nil {
+			if err := clearDir(outputDirForBuildConfigSources); err != nil {
+				return err
+			}
+			if err := b.writeBuildConfig(outputDirForBuildConfigSources); err != nil {
+				return err
+			}
+		}
+		if b.variant.strictMode != "" {
+			if err := clearDir(outputDirForStrictModeSources); err != nil {
+				return err
+			}
+			return writeStrictModeSource(outputDirForStrictModeSources, b.variant.strictMode)
+		}
+		return nil
+	}})
+
+	if b.googleServices != "" {
+		ss = append(ss, &stage{name: "generate-google-services", inputs: func() ([]string, error) {
+			return []string{b.googleServices}, nil
+		}, outputs: []string{outputDirForGoogleServicesResources}, flags: []string{"variant", "rename-manifest-package"}, run: func(t *toolchain) error {
+			if err := clearDir(outputDirForGoogleServicesResources); err != nil {
+				return err
+			}
+			if err := writeGoogleServicesResources(b.googleServices, outputDirForGoogleServicesResources, b.applicationID); err != nil {
+				return fmt.Errorf("could not generate resources from %v due to error: %v", googleServicesFilename, err)
+			}
+			return nil
+		}})
+		resourceDeps = append(resourceDeps, "generate-google-services")
+	}
+
+	if b.variant.zopfliPNGs {
+		// The directories of PNGs to recompress do not include the output.
+		sourceResourceDirs := b.resourceDirs[1:]
+		ss = append(ss, &stage{name: "recompress-pngs", deps: resourceDeps, inputs: func() ([]string, error) {
+			return filesUnder(sourceResourceDirs...)
+		}, outputs: []string{outputDirForZopfliResources}, flags: toolFlags, run: func(t *toolchain) error {
+			if err := clearDir(outputDirForZopfliResources); err != nil {
+				return err
+			}
+			if err := t.recompressPNGs(sourceResourceDirs, outputDirForZopfliResources); err != nil {
+				return withCode(errZopfliPNG, fmt.Errorf("could not recompress PNGs with zopflipng due to error: %v", err))
+			}
+			return nil
+		}})
+		resourceDeps = append(resourceDeps, "recompress-pngs")
+	}
+
+	resourceFiles := func() ([]string, error) {
+		return filesUnder(b.resourceDirs...)
+	}
+	packagedFiles := func() ([]string, error) {
+		if b.args.rawFilesFilepath == "" {
+			return resourceFiles()
+		}
+		return filesUnder(append([]string{b.args.rawFilesFilepath}, b.resourceDirs...)...)
+	}
+	manifestFile := func() ([]string, error) {
+		return filesUnder(b.manifestFilepath)
+	}
+	ss = append(ss, &stage{name: "generate-r", deps: resourceDeps, inputs: resourceFiles, intermediates: manifestFile, outputs: []string{outputDirForGeneratedSourceFiles}, flags: toolFlags, run: func(t *toolchain) error {
+		if err := clearDir(outputDirForGeneratedSourceFiles); err != nil {
+			return err
+		}
+		// R.java only depends on the resources and manifest, so is shared
+		// between builds, such as of other checkouts or branches of the app.
+		dir := b.args.outputDir + "/" + outputDirForGeneratedSourceFiles
+		key, err := rJavaCacheKey(t, b.resourceDirs, b.manifestFilepath)
+		if err != nil {
+			return err
+		}
+		if ok, err := restoreRJava(key, dir); ok && err == nil {
+			return nil
+		} else if err != nil {
+			fmt.Fprintf(t.stderr(), "%v\n", yellow(fmt.Sprintf("could not restore cached R.java due to error: %v", err)))
+			if err := clearDir(outputDirForGeneratedSourceFiles); err != nil {
+				return err
+			}
+		}
+		if err := t.generateJavaFileForAndroidResources(dir, b.manifestFilepath, b.resourceDirs); err != nil {
+			return withCode(errResources, fmt.Errorf("could not create Java file from Android XML resources files due to error: %v", err))
+		}
+		if err := storeRJava(key, dir); err != nil {
+			fmt.Fprintf(t.stderr(), "%v\n", yellow(fmt.Sprintf("could not cache R.java due to error: %v", err)))
+		}
+		return nil
+	}})
+
+	if b.args.protoSourcesFilepath != "" {
+		ss = append(ss, &stage{name: "generate-proto", inputs: func() ([]string, error) {
+			return filesUnder(b.args.protoSourcesFilepath)
+		}, outputs: []string{outputDirForGeneratedProtoFiles}, flags: toolFlags, run: func(t *toolchain) error {
+			if err := clearDir(outputDirForGeneratedProtoFiles); err != nil {
+				return err
+			}
+			if err := t.generateJavaFilesForProtocolBuffers(b.args.protoSourcesFilepath, outputDirForGeneratedProtoFiles); err != nil {
+				return withCode(errProto, fmt.Errorf("could not create Java files from protocol buffer files due to error: %v", err))
+			}
+			return nil
+		}})
+		javaDeps = append(javaDeps, "generate-proto")
+	}
+
+	compileOutputs := []string{outputDirForBytecode}
+	if b.config.room.schemaDir != "" {
+		compileOutputs = append(compileOutputs, b.config.room.schemaDir)
+	}
+	ss = append(ss, &stage{name: "compile", deps: javaDeps, inputs: func() ([]string, error) {
+		// The config holds options for javac that can change its output.
+		inputs := append([]string{b.args.javaSourcesFilepath, b.config.path}, b.javaOverlays...)
+		return filesUnder(append(inputs, b.libraries...)...)
+	}, intermediates: func() ([]string, error) {
+		return filesUnder(b.javaSourceDirs[1+len(b.javaOverlays):]...)
+	}, outputs: compileOutputs, flags: append([]string{"variant", "java-release", "prebuilt-dex"}, toolFlags...), run: func(t *toolchain) error {
+		// Classes of deleted sources must not linger to be dexed.
+		if err := clearDir(outputDirForBytecode); err != nil {
+			return err
+		}
+		if b.prebuiltOnly() {
+			return nil
+		}
+		schemas, err := b.config.room.schemas()
+		if err != nil {
+			return err
+		}
+		if err := t.compileJavaSourceFilesToJavaVirtualMachineBytecode(b.args.javaRelease, b.javaSourceDirs, outputDirForBytecode, b.libraries, append(b.variant.javacArgs(), b.config.room.javacArgs()...)); err != nil {
+			return withCode(errCompile, fmt.Errorf("could not compile java source files to bytecode due to error: %v", err))
+		}
+		return b.config.room.checkVersionBumps(schemas)
+	}})
+
+	ss = append(ss, &stage{name: "dex", deps: []string{"compile"}, inputs: func() ([]string, error) {
+		inputs := append([]string{b.config.path, b.startupProfile()}, b.libraries...)
+		return filesUnder(append(inputs, b.prebuiltDex...)...)
+	}, intermediates: func() ([]string, error) {
+		return filesUnder(outputDirForBytecode)
+	}, outputs: []string{outputDexFilepath}, flags: append([]string{"variant"}, toolFlags...), run: func(t *toolchain) error {
+		args := []string{b.variant.d8Mode()}
+		if p := b.startupProfile(); exist(p) {
+			if err := t.checkStartupProfileSupport(); err != nil {
+				return withCode(errDex, err)
+			}
+			args = append(args, "--startup-profile", p)
+		}
+		if err := t.translateJavaVirtualMachineMBytecodeToAndroidRuntimeBytecode(outputDexFilepath, outputDirForBytecode, b.libraries, b.prebuiltDex, args); err != nil {
+			return withCode(errDex, fmt.Errorf("could not translate bytecode with dexer due to error: %v", err))
+		}
+		return nil
+	}})
+
+	metadataDeps := []string{"check-resource-refs"}
+	if b.config.checkLayouts {
+		metadataDeps = append(metadataDeps, "check-layouts")
+		ss = append(ss, &stage{name: "check-layouts", deps: []string{"compile"}, inputs: resourceFiles, intermediates: func() ([]string, error) {
+			return filesUnder(outputDirForBytecode)
+		}, outputs: []string{}, flags: append([]string{"prebuilt-dex"}, toolFlags...), run: func(t *toolchain) error {
+			c, err := newLayoutChecker(b.resourceDirs, t.platform, []string{outputDirForBytecode}, b.libraries)
+			if err != nil {
+				return err
+			}
+			c.skipClasses = b.prebuiltOnly()
+			problems, err := c.check(b.resourceDirs)
+			if err != nil {
+				return err
+			}
+			if len(problems) > 0 {
+				return withCode(errLayouts, fmt.Errorf("found problems in layouts that would fail when inflated:\n%v", strings.Join(problems, "\n")))
+			}
+			return nil
+		}})
+	}
+
+	ss = append(ss, &stage{name: "check-resource-refs", deps: []string{"compile"}, intermediates: func() ([]string, error) {
+		return filesUnder(outputDirForBytecode)
+	}, outputs: []string{}, run: func(t *toolchain) error {
+		problems, err := checkResourceReferences(outputDirForBytecode, outputDirForGeneratedSourceFiles, b.javaSourceDirs[:1+len(b.javaOverlays)])
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return withCode(errResourceRefs, fmt.Errorf("found references to resources that the build does not have:\n%v", strings.Join(problems, "\n")))
+		}
+		return nil
+	}})
+
+	for _, o := range b.outputs {
+		o := o
+		ss = append(ss, &stage{name: "link:" + o.filepath, deps: resourceDeps, inputs: packagedFiles, run: func(t *toolchain) error {
+			p, err := o.manifestFilepath(b.manifestFilepath)
+			if err != nil {
+				return err
+			}
+			args := o.packageArgs()
+			if b.variant.noCrunch {
+				args = append(args, "--no-crunch")
+			}
+			if b.uncompressedNativeLibs() {
+				args = append(args, "-0", "so")
+			}
+			if err := t.createUnalignedAndroidApplicationPackage(p, b.resourceDirs, b.args.renameManifestPackage, args, o.unalignedFilepath(), b.args.rawFilesFilepath); err != nil {
+				return withCode(errResources, fmt.Errorf("could not create unaligned APK file due to error: %v", err))
+			}
+			return nil
+		}})
+		ss = append(ss, &stage{name: "add-dex:" + o.filepath, deps: []string{"link:" + o.filepath, "dex"}, run: func(t *toolchain) error {
+			if err := t.addAndroidRuntimeBytecodeToAndroidApplicationPackage(o.unalignedFilepath(), outputDexFilepath); err != nil {
+				return withCode(errPackage, fmt.Errorf("could not add android runtime bytecode to APK due to error: %v", err))
+			}
+			return nil
+		}})
+		signDeps := []string{"add-dex:" + o.filepath}
+		if b.args.zopfli {
+			ss = append(ss, &stage{name: "recompress:" + o.filepath, deps: signDeps, run: func(t *toolchain) error {
+				if err := t.recompressAPK(o.unalignedFilepath(), zopfliTmpDir(b.args.outputDir)); err != nil {
+					return withCode(errZopfliAPK, fmt.Errorf("could not recompress APK with zopfli due to error: %v", err))
+				}
+				return nil
+			}})
+			signDeps = []string{"recompress:" + o.filepath}
+		}
+		// APK Signature Scheme v2 and later sign the whole file, so the APK
+		// must already be aligned.
+		ss = append(ss, &stage{name: "align:" + o.filepath, deps: signDeps, run: func(t *toolchain) error {
+			if err := t.alignUncompressedDataInZipFileToFourByteBoundariesForFasterMemoryMappingAtRuntime(o.unalignedFilepath(), o.unsignedFilepath()); err != nil {
+				return withCode(errPackage, fmt.Errorf("Could align bytes of APK file due to error: %v", err))
+			}
+			return nil
+		}})
+		ss = append(ss, &stage{name: "sign:" + o.filepath, deps: []string{"align:" + o.filepath}, run: func(t *toolchain) error {
+			var err error
+			if len(b.variant.signCommand) > 0 {
+				err = signWithCommand(b.config, b.variant.signCommand, o.unsignedFilepath(), o.filepath, t.stdout(), t.stderr())
+			} else {
+				err = t.signAndroidApplicationPackageWithDebugKey(o.unsignedFilepath(), o.filepath, b.args.signSchemeList())
+			}
+			if err != nil {
+				return withCode(errSign, fmt.Errorf("could not sign APK due to error: %v", err))
+			}
+			return nil
+		}})
+		metadataDeps = append(metadataDeps, "sign:"+o.filepath)
+	}
+
+	ss = append(ss, &stage{name: "write-metadata", deps: metadataDeps, run: func(t *toolchain) error {
+		return writeOutputMetadata(outputMetadataFilepath, b.outputs, b.applicationID, b.variant.name, b.manifest)
+	}})
+
+	ss = append(ss, &stage{name: "write-buildinfo", deps: metadataDeps, run: func(t *toolchain) error {
+		info, err := b.newBuildInfo(t)
+		if err != nil {
+			return err
+		}
+		return info.write(buildInfoFilepath)
+	}})
+
+	ss = append(ss, &stage{name: "write-checksums", deps: []string{"write-metadata", "write-buildinfo"}, run: func(t *toolchain) error {
+		files := make([]string, 0, len(b.outputs)+2)
+		for _, o := range b.outputs {
+			files = append(files, o.filepath)
+			if b.signsIDSig() {
+				files = append(files, o.idsigFilepath())
+			}
+		}
+		if err := writeChecksums(checksumsFilepath, append(files, outputMetadataFilepath, buildInfoFilepath)); err != nil {
+			return err
+		}
+		if b.args.signChecksums == "" {
+			return nil
+		}
+		if err := signChecksums(b.args.signChecksums, checksumsFilepath, t.stdout(), t.stderr()); err != nil {
+			return withCode(errSignChecksums, fmt.Errorf("could not sign checksums with %v due to error: %v", b.args.signChecksums, err))
+		}
+		return nil
+	}})
+
+	ss = append(ss, &stage{name: "record-sizes", deps: metadataDeps, run: func(t *toolchain) error {
+		return b.recordSizes()
+	}})
+
+	if !b.args.publish {
+		return ss
+	}
+	for _, p := range b.config.publishers {
+		p := p
+		ss = append(ss, &stage{name: "publish:" + p.name, deps: []string{"write-checksums", "record-sizes"}, run: func(t *toolchain) error {
+			if err := p.publish(b, b.artifacts(), t.stdout(), t.stderr()); err != nil {
+				return withCode(errPublish, fmt.Errorf("could not publish with '%v' due to error: %v", p.name, err))
+			}
+			return nil
+		}})
+	}
+	return ss
+}
+
+// prebuiltOnly reports whether the app's code is only its prebuilt dex, as
+// when another pipeline compiles it and blade only packages and signs it, in
+// which case the prebuilt dex must include the app's R classes too.
+func (b *build) prebuiltOnly() bool {
+	return len(b.prebuiltDex) > 0 && !exist(b.args.javaSourcesFilepath)
+}
+
+// outputPath returns where the file that stages write to name is, for use
+// outside of stages, which run from the output directory.
+func (b *build) outputPath(name string) string {
+	return filepath.Join(b.args.outputDir, name)
+}
+
+// toolDirs returns the directories that the build's toolchain commands read
+// from or write to.
+func (b *build) toolDirs() ([]string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine working directory due to error: %v", err)
+	}
+	dirs := []string{wd, b.args.outputDir, filepath.Dir(b.args.androidManifestFilepath)}
+	dirs = append(dirs, b.javaSourceDirs...)
+	dirs = append(dirs, b.resourceDirs...)
+	if b.args.protoSourcesFilepath != "" {
+		dirs = append(dirs, b.args.protoSourcesFilepath)
+	}
+	if b.args.rawFilesFilepath != "" {
+		dirs = append(dirs, b.args.rawFilesFilepath)
+	}
+	if b.config.room.schemaDir != "" {
+		dirs = append(dirs, b.config.room.schemaDir)
+	}
+	for _, l := range b.libraries {
+		dirs = append(dirs, filepath.Dir(l))
+	}
+	return dirs, nil
+}
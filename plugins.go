@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const pluginPrefix = "blade-"
+
+// pluginContext is what a plugin is given on stdin as JSON, so that it can
+// find what blade would build with and where without resolving it again.
+type pluginContext struct {
+	Version        int               `json:"version"`
+	Blade          string            `json:"blade"`
+	WorkDir        string            `json:"workDir"`
+	OutputDir      string            `json:"outputDir"`
+	Config         string            `json:"config,omitempty"`
+	Manifest       string            `json:"manifest"`
+	Variant        string            `json:"variant"`
+	ApplicationID  string            `json:"applicationId"`
+	JavaSourceDirs []string          `json:"javaSourceDirs"`
+	ResourceDirs   []string          `json:"resourceDirs"`
+	Libraries      []string          `json:"libraries"`
+	APKs           []string          `json:"apks"`
+	Toolchain      *pluginToolchain  `json:"toolchain,omitempty"`
+	Dirs           map[string]string `json:"dirs"`
+}
+
+type pluginToolchain struct {
+	SDK        string            `json:"sdk"`
+	BuildTools string            `json:"buildTools"`
+	Platform   string            `json:"platform"`
+	AndroidJar string            `json:"androidJar"`
+	Tools      map[string]string `json:"tools"`
+}
+
+// runPlugin runs the blade-<name> executable found on PATH with arguments,
+// as git does for git-<name>, giving it the context of the build described
+// by args on stdin. It exits with the plugin's exit status.
+func runPlugin(args *buildArgs, name string, arguments []string) {
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "'%v' is neither a subcommand of blade nor a %v%v executable on PATH\n", name, pluginPrefix, name)
+		os.Exit(2)
+	}
+	ctx, err := newPluginContext(args)
+	if err != nil {
+		exitWithError(err)
+	}
+	in, err := json.MarshalIndent(ctx, "", "  ")
+	if err != nil {
+		exitWithError(fmt.Errorf("could not encode build context for plugin due to error: %v", err))
+	}
+	cmd := exec.Command(path, arguments...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "BLADE="+ctx.Blade)
+	if err := cmd.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			os.Exit(exit.ExitCode())
+		}
+		exitWithError(fmt.Errorf("could not run plugin '%v' due to error: %v", path, err))
+	}
+}
+
+// newPluginContext resolves the build described by args. The toolchain is
+// left out when building in a container, since plugins run on the host.
+func newPluginContext(args *buildArgs) (*pluginContext, error) {
+	if err := args.validate(); err != nil {
+		return nil, err
+	}
+	b, err := newBuild(args)
+	if err != nil {
+		return nil, err
+	}
+	blade, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not locate the blade executable due to error: %v", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine working directory due to error: %v", err)
+	}
+	ctx := &pluginContext{
+		Version:        1,
+		Blade:          blade,
+		WorkDir:        wd,
+		OutputDir:      args.outputDir,
+		Manifest:       args.androidManifestFilepath,
+		Variant:        b.variant.name,
+		ApplicationID:  b.applicationID,
+		JavaSourceDirs: b.outputPaths(b.javaSourceDirs),
+		ResourceDirs:   b.outputPaths(b.resourceDirs),
+		Libraries:      b.libraries,
+		APKs:           make([]string, 0, len(b.outputs)),
+		Dirs: map[string]string{
+			"generatedSources": b.outputPath(outputDirForGeneratedSourceFiles),
+			"bytecode":         b.outputPath(outputDirForBytecode),
+			"dex":              b.outputPath(outputDexFilepath),
+			"stamps":           filepath.Join(args.outputDir, stampsDir),
+		},
+	}
+	if exist(args.configFilepath) {
+		ctx.Config = absPath(args.configFilepath)
+	}
+	for _, o := range b.outputs {
+		ctx.APKs = append(ctx.APKs, b.outputPath(o.filepath))
+	}
+	if args.container == "" {
+		t, err := newToolchain(args.androidHome, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not ascertain toolchain due to error: %v", err)
+		}
+		t.useTools(b.config.tools, b.config.isolateJavaOptions)
+		ctx.Toolchain = &pluginToolchain{
+			SDK:        t.sdk,
+			BuildTools: t.buildTools,
+			Platform:   t.platform,
+			AndroidJar: t.androidLib,
+			Tools: map[string]string{
+				"aapt":      t.aaptBin,
+				"apksigner": t.apksignerBin(),
+				"d8":        t.d8Bin,
+				"zipalign":  t.zipalignBin(),
+			},
+		}
+		for _, name := range []string{"javac", "java"} {
+			if p, err := exec.LookPath(t.bin(name)); err == nil {
+				ctx.Toolchain.Tools[name] = p
+			}
+		}
+	}
+	return ctx, nil
+}
+
+// absPath returns the absolute form of path, or path itself if the working
+// directory cannot be determined.
+func absPath(path string) string {
+	if p, err := filepath.Abs(path); err == nil {
+		return p
+	}
+	return path
+}
+
+// outputPaths returns paths with those relative to the output directory,
+// which the build's intermediate directories are, made absolute.
+func (b *build) outputPaths(paths []string) []string {
+	abs := make([]string, len(paths))
+	for i, p := range paths {
+		if filepath.IsAbs(p) {
+			abs[i] = p
+		} else {
+			abs[i] = b.outputPath(p)
+		}
+	}
+	return abs
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prebuiltDexFiles returns the absolute paths of the comma-separated list of
+// prebuilt .dex files, and of jars or zips of them, given to -prebuilt-dex,
+// such as the output of an earlier R8 run. d8 merges dex files into the
+// app's dex as they are, without compiling them again.
+func prebuiltDexFiles(list string) ([]string, error) {
+	files := make([]string, 0)
+	if list == "" {
+		return files, nil
+	}
+	for _, f := range strings.Split(list, ",") {
+		switch strings.ToLower(filepath.Ext(f)) {
+		case ".dex", ".jar", ".zip":
+		default:
+			return nil, fmt.Errorf("prebuilt dex '%v' must be a .dex file or a .jar or .zip of them", f)
+		}
+		p, err := filepath.Abs(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not locate prebuilt dex at filepath '%v' due to error: %v", f, err)
+		}
+		if _, err := os.Stat(p); err != nil {
+			return nil, fmt.Errorf("could not find prebuilt dex due to error: %v", err)
+		}
+		files = append(files, p)
+	}
+	return files, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultMinFreeSpace is the least free disk space a build starts with, as
+// the bytecode, dex and APKs of a large app take hundreds of megabytes.
+const defaultMinFreeSpace = 512 << 20
+
+// preflight checks that the build can write to its output and cache
+// directories, that they have at least min_free_space free, and that the
+// tools the build runs from PATH are installed, before any stage runs, so
+// that a build fails at once with every problem rather than midway with
+// the first.
+func (b *build) preflight() error {
+	problems := make([]string, 0)
+	dirs := []string{b.args.outputDir}
+	if dir, err := userCacheDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		if err := checkWritable(dir); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		free, ok := freeSpace(dir)
+		if ok && b.config.minFreeSpace > 0 && free < b.config.minFreeSpace {
+			problems = append(problems, fmt.Sprintf("only %.1f MB is free in '%v', less than the %.1f MB of min_free_space", float64(free)/(1<<20), dir, float64(b.config.minFreeSpace)/(1<<20)))
+		}
+	}
+	for _, tool := range b.requiredTools() {
+		if _, err := exec.LookPath(tool); err != nil {
+			problems = append(problems, fmt.Sprintf("%v is not installed or not on PATH", tool))
+		}
+	}
+	if len(problems) > 0 {
+		return withCode(errPreflight, fmt.Errorf("the build cannot start, as:\n  %v", strings.Join(problems, "\n  ")))
+	}
+	return nil
+}
+
+// checkWritable creates dir if needed, and checks that files can be written
+// to it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0774); err != nil {
+		return fmt.Errorf("could not create '%v' due to error: %v", dir, err)
+	}
+	f, err := ioutil.TempFile(dir, ".preflight")
+	if err != nil {
+		return fmt.Errorf("could not write to '%v' due to error: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// requiredTools returns the tools the build runs from the PATH of this host,
+// rather than from the SDK, a container or a remote host, or from where
+// config overrides their paths.
+func (b *build) requiredTools() []string {
+	tools := make([]string, 0)
+	if b.args.remote != "" {
+		tools = append(tools, "ssh", "rsync")
+	}
+	if b.args.container == "" {
+		if b.args.remote == "" {
+			tools = append(tools, toolPath(b.config.tools, "javac", "javac"))
+		}
+		if b.args.protoSourcesFilepath != "" {
+			tools = append(tools, toolPath(b.config.tools, "protoc", "protoc"))
+		}
+		if b.variant.zopfliPNGs {
+			tools = append(tools, toolPath(b.config.tools, "zopflipng", "zopflipng"))
+		}
+		if b.args.zopfli {
+			tools = append(tools, toolPath(b.config.tools, "zopfli", "zopfli"))
+		}
+	}
+	if len(b.variant.signCommand) > 0 {
+		tools = append(tools, b.variant.signCommand[0])
+	}
+	if signer, ok := checksumSigners[b.args.signChecksums]; ok {
+		tools = append(tools, signer("")[0])
+	}
+	return tools
+}
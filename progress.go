@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress reports on the stages of a build as they run. On a terminal it
+// shows a live status line of the stages running and for how long, above
+// which a line is printed as each stage finishes. Otherwise it prints only
+// the lines of finished stages, so that logs read sequentially.
+//
+// Stages' tool output is collected and printed once the stage finishes, so
+// that the output of stages running at once is not interleaved.
+type progress struct {
+	mu      sync.Mutex
+	w       *os.File
+	live    bool
+	total   int
+	done    int
+	start   time.Time
+	running map[string]time.Time
+	stop    chan struct{}
+}
+
+func newProgress(w *os.File, total int) *progress {
+	p := &progress{w: w, total: total, start: time.Now(), running: make(map[string]time.Time), stop: make(chan struct{})}
+	if isTerminal(w) {
+		p.live = true
+		go p.tick()
+	}
+	return p
+}
+
+func (p *progress) tick() {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+			p.mu.Lock()
+			p.draw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *progress) started(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running[name] = time.Now()
+	if p.live {
+		p.draw()
+	}
+}
+
+// finished reports the stage named name as done, printing what its tools
+// output, and whether it failed or was skipped as up to date.
+func (p *progress) finished(name string, skipped bool, output []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := time.Since(p.running[name])
+	delete(p.running, name)
+	p.done++
+	if p.live {
+		p.clear()
+	}
+	status := fmt.Sprintf("%.1fs", elapsed.Seconds())
+	switch {
+	case err != nil:
+		status = red("failed")
+	case skipped:
+		status = "up to date"
+	}
+	mark := fmt.Sprintf("[%v/%v]", p.done, p.total)
+	if p.live {
+		mark = green("✓")
+		if err != nil {
+			mark = red("✗")
+		}
+	}
+	fmt.Fprintf(p.w, "%v %v %v\n", mark, name, status)
+	p.w.Write(output)
+	if p.live {
+		p.draw()
+	}
+}
+
+// close stops the live status line and clears it.
+func (p *progress) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.live {
+		close(p.stop)
+		p.clear()
+		p.live = false
+	}
+	fmt.Fprintf(p.w, "%v stages in %.1fs\n", p.done, time.Since(p.start).Seconds())
+}
+
+func (p *progress) clear() {
+	fmt.Fprint(p.w, "\r\x1b[K")
+}
+
+// draw redraws the status line, which is cut short to fit on one line of
+// the terminal so that it can be cleared.
+func (p *progress) draw() {
+	if !p.live {
+		return
+	}
+	names := make([]string, 0, len(p.running))
+	for name := range p.running {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return p.running[names[i]].Before(p.running[names[j]]) })
+	stages := make([]string, len(names))
+	for i, name := range names {
+		stages[i] = fmt.Sprintf("%v %.1fs", name, time.Since(p.running[name]).Seconds())
+	}
+	s := fmt.Sprintf("[%v/%v] %.1fs %v", p.done, p.total, time.Since(p.start).Seconds(), strings.Join(stages, ", "))
+	width := 80
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		width = n
+	}
+	if r := []rune(s); len(r) > width-1 {
+		s = string(r[:width-1])
+	}
+	p.clear()
+	fmt.Fprint(p.w, s)
+}
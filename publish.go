@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// publisher uploads the artifacts of a build once it succeeds, and is
+// declared in config as one of:
+//
+//	[[publish]]
+//	name = "artifactory"
+//	url = "https://repo.example.com/apps/{application_id}/{variant}/{version_name}/{file}"
+//	headers = { Authorization = "Bearer ${ARTIFACTORY_TOKEN}" }
+//
+//	[[publish]]
+//	name = "s3"
+//	command = ["aws", "s3", "cp", "{path}", "s3://bucket/{variant}/{version_code}/{file}"]
+//
+// Each artifact is uploaded with an HTTP PUT to url, which suits
+// Artifactory, Nexus and presigned S3 or GCS URLs, or by running command,
+// which suits the CLIs of storage services. Both are templated as in
+// template.go, with {file} the artifact's name and {path} its absolute path,
+// and headers may refer to environment variables, so that credentials stay
+// out of the config.
+type publisher struct {
+	name    string
+	url     string
+	headers map[string]string
+	command []string
+}
+
+func newPublisher(t map[string]interface{}) (publisher, error) {
+	p := publisher{}
+	var err error
+	if p.name, err = stringValue(t, "name"); err != nil {
+		return p, err
+	}
+	if p.name == "" {
+		return p, fmt.Errorf("every [[publish]] in config must have a name")
+	}
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid publisher '%v': %v", p.name, err)
+	}
+	if p.url, err = stringValue(t, "url"); err != nil {
+		return p, wrap(err)
+	}
+	if p.headers, err = stringMap(t, "headers"); err != nil {
+		return p, wrap(err)
+	}
+	if p.command, err = stringList(t, "command"); err != nil {
+		return p, wrap(err)
+	}
+	if (p.url == "") == (len(p.command) == 0) {
+		return p, wrap(fmt.Errorf("either a url or a command must be provided, but not both"))
+	}
+	return p, nil
+}
+
+// publish uploads each of files, with the config file's directory as the
+// working directory of any command, as for generators.
+func (p publisher) publish(b *build, files []string, stdout, stderr io.Writer) error {
+	for _, f := range files {
+		extra := map[string]string{"file": filepath.Base(f), "path": absPath(f)}
+		if p.url != "" {
+			u, err := b.expand(fmt.Sprintf("the url of publisher '%v'", p.name), p.url, extra)
+			if err != nil {
+				return err
+			}
+			if err := p.put(f, u); err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, "published %v to %v\n", f, u)
+			continue
+		}
+		command := make([]string, len(p.command))
+		for i, s := range p.command {
+			var err error
+			if command[i], err = b.expand(fmt.Sprintf("the command of publisher '%v'", p.name), s, extra); err != nil {
+				return err
+			}
+		}
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Dir = b.config.dir
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error when running command %v : %v", strings.Join(command, " "), err)
+		}
+	}
+	return nil
+}
+
+// put uploads the file at path to url with an HTTP PUT.
+func (p publisher) put(path, url string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open '%v' to publish due to error: %v", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not read '%v' to publish due to error: %v", path, err)
+	}
+	req, err := http.NewRequest(http.MethodPut, url, f)
+	if err != nil {
+		return fmt.Errorf("could not publish to '%v' due to error: %v", url, err)
+	}
+	req.ContentLength = info.Size()
+	for k, v := range p.headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not publish '%v' due to error: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("could not publish '%v' to '%v', which responded %v: %v", path, url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// artifacts returns the files that the build leaves for publishing: the
+// APKs, their metadata, build info and checksums, and any signature of the
+// checksums.
+func (b *build) artifacts() []string {
+	files := make([]string, 0, len(b.outputs)+3)
+	for _, o := range b.outputs {
+		files = append(files, o.filepath)
+		if b.signsIDSig() {
+			files = append(files, o.idsigFilepath())
+		}
+	}
+	files = append(files, outputMetadataFilepath, buildInfoFilepath, checksumsFilepath)
+	if b.args.signChecksums == "" {
+		return files
+	}
+	for _, sig := range []string{checksumsFilepath + ".asc", checksumsFilepath + ".sigstore.json"} {
+		if _, err := os.Stat(sig); err == nil {
+			files = append(files, sig)
+		}
+	}
+	return files
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const rJavaCacheDir = "r-java"
+
+// rJavaCacheKey returns the key under which the R.java files generated from
+// the resource dirs, in the order aapt is given them, and the manifest of an
+// app are cached. Files are hashed by their paths relative to the dir they
+// are in, so that checkouts of the app at other paths, or on other machines,
+// share entries. It covers the versions of build-tools and of the platform,
+// since both decide what aapt generates, or else the path of the aapt that
+// config runs in lieu of that of build-tools.
+func rJavaCacheKey(t *toolchain, resourceDirs []string, manifest string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "R.java\x00%v\x00%v\x00", filepath.Base(t.buildTools), filepath.Base(t.platform))
+	if filepath.Dir(t.aaptBin) != t.buildTools {
+		fmt.Fprintf(h, "%v\x00", t.aaptBin)
+	}
+	for _, root := range append(append([]string(nil), resourceDirs...), manifest) {
+		files, err := filesUnder(root)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%v\x00", len(files))
+		for _, f := range files {
+			rel, err := filepath.Rel(root, f)
+			if err != nil {
+				return "", fmt.Errorf("could not find path of '%v' within '%v' due to error: %v", f, root, err)
+			}
+			fmt.Fprintf(h, "%v\x00", filepath.ToSlash(rel))
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				return "", fmt.Errorf("could not read '%v' to fingerprint due to error: %v", f, err)
+			}
+			fmt.Fprintf(h, "%v\x00", len(b))
+			h.Write(b)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// restoreRJava copies the R.java files cached under key to dir, reporting
+// whether any were cached. Restored files are marked as used, so that the
+// cache policy removes them last. An entry that fails verification, such as
+// one partly removed by the cache policy, is removed for the build to store
+// it anew.
+func restoreRJava(key, dir string) (bool, error) {
+	cache, err := userCacheDir()
+	if err != nil {
+		return false, err
+	}
+	src := filepath.Join(cache, rJavaCacheDir, key)
+	lock, err := lockCacheEntry(src, false)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := verifyCacheEntry(src); err != nil {
+		lock.Close()
+		return false, removeCorruptCacheEntry(src, err)
+	}
+	defer lock.Close()
+	now := time.Now()
+	os.Chtimes(filepath.Join(src, cacheSumsFilename), now, now)
+	return true, copyTree(filepath.Join(src, cacheFilesDir), dir, func(path string) { os.Chtimes(path, now, now) })
+}
+
+// storeRJava caches the R.java files in dir under key. They are copied to a
+// temporary directory that is then renamed into place, so that a build that
+// fails or is interrupted midway never leaves a partial entry, and builds
+// running at once may store the same key.
+func storeRJava(key, dir string) error {
+	cache, err := userCacheDir()
+	if err != nil {
+		return err
+	}
+	parent := filepath.Join(cache, rJavaCacheDir)
+	if err := os.MkdirAll(parent, 0774); err != nil {
+		return fmt.Errorf("could not create R.java cache directory due to error: %v", err)
+	}
+	tmp, err := ioutil.TempDir(parent, key+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary R.java cache directory due to error: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(dir, filepath.Join(tmp, cacheFilesDir), nil); err != nil {
+		return err
+	}
+	if err := writeCacheSums(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(parent, key)); err != nil && !exist(filepath.Join(parent, key)) {
+		return fmt.Errorf("could not add R.java files to cache due to error: %v", err)
+	}
+	return nil
+}
+
+// copyTree copies the files under src to the same relative paths under dst,
+// calling copied, if not nil, with the path of each source file copied.
+func copyTree(src, dst string, copied func(path string)) error {
+	files, err := filesUnder(src)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		rel, err := filepath.Rel(src, f)
+		if err != nil {
+			return fmt.Errorf("could not find path of '%v' within '%v' due to error: %v", f, src, err)
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("could not read '%v' to copy due to error: %v", f, err)
+		}
+		out := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(out), 0774); err != nil {
+			return fmt.Errorf("could not create directory to copy '%v' to due to error: %v", f, err)
+		}
+		if err := ioutil.WriteFile(out, b, 0664); err != nil {
+			return fmt.Errorf("could not copy '%v' to '%v' due to error: %v", f, out, err)
+		}
+		if copied != nil {
+			copied(f)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// appPackageID is the package ID of resources of the app itself, which is
+// the top byte of their IDs.
+const appPackageID = 0x7f
+
+var (
+	rClass = regexp.MustCompile(`public static (final )?class (\w+)`)
+	rField = regexp.MustCompile(`public static (final )?int (\w+)\s*=\s*(0x[0-9a-fA-F]+|\d+);`)
+)
+
+// readRTable returns the names of the resources of the R.java files under
+// dir, such as R.string.app_name, by resource ID.
+func readRTable(dir string) (map[uint32]string, error) {
+	files, err := filesUnder(dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[uint32]string)
+	for _, f := range files {
+		if filepath.Base(f) != "R.java" {
+			continue
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not read '%v' due to error: %v", f, err)
+		}
+		class := ""
+		s := bufio.NewScanner(strings.NewReader(string(b)))
+		for s.Scan() {
+			if m := rClass.FindStringSubmatch(s.Text()); m != nil {
+				class = m[2]
+			}
+			if m := rField.FindStringSubmatch(s.Text()); m != nil && class != "" {
+				id, err := strconv.ParseUint(m[3], 0, 32)
+				if err != nil {
+					continue
+				}
+				ids[uint32(id)] = "R." + class + "." + m[2]
+			}
+		}
+	}
+	return ids, nil
+}
+
+// classIntConstants returns the int constants of the constant pool of the
+// class file in b, which hold the resource IDs that javac inlines for each
+// reference to a field of R as well as any written as literals.
+func classIntConstants(b []byte) ([]uint32, error) {
+	if len(b) < 10 || binary.BigEndian.Uint32(b) != 0xcafebabe {
+		return nil, fmt.Errorf("not a class file")
+	}
+	count := int(binary.BigEndian.Uint16(b[8:]))
+	ints := make([]uint32, 0)
+	p := 10
+	for i := 1; i < count; i++ {
+		if p >= len(b) {
+			return nil, fmt.Errorf("constant pool is truncated")
+		}
+		tag := b[p]
+		p++
+		size := 0
+		switch tag {
+		case 1: // Utf8
+			if p+2 > len(b) {
+				return nil, fmt.Errorf("constant pool is truncated")
+			}
+			size = 2 + int(binary.BigEndian.Uint16(b[p:]))
+		case 3: // Integer
+			if p+4 > len(b) {
+				return nil, fmt.Errorf("constant pool is truncated")
+			}
+			ints = append(ints, binary.BigEndian.Uint32(b[p:]))
+			size = 4
+		case 4, 9, 10, 11, 12, 17, 18: // Float, refs, NameAndType, dynamic
+			size = 4
+		case 5, 6: // Long and Double take two entries.
+			size = 8
+			i++
+		case 7, 8, 16, 19, 20: // Class, String, MethodType, Module, Package
+			size = 2
+		case 15: // MethodHandle
+			size = 3
+		default:
+			return nil, fmt.Errorf("unknown constant pool tag %v", tag)
+		}
+		p += size
+	}
+	return ints, nil
+}
+
+// checkResourceReferences returns the resource IDs that the compiled
+// classes under classDir refer to but the resources of the build do not
+// define, as found in the R.java files under rDir, as "Class: problem".
+// Such IDs come from literals such as getString(0x7f0b0012), and would
+// otherwise only fail with Resources.NotFoundException at runtime.
+func checkResourceReferences(classDir, rDir string, sourceDirs []string) ([]string, error) {
+	ids, err := readRTable(rDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	// Only IDs of the types of resource the app has are taken for resource
+	// IDs, as other constants can fall in the app's package ID too.
+	types := make(map[uint32]bool)
+	for id := range ids {
+		types[id>>16] = true
+	}
+	files, err := filesUnder(classDir)
+	if err != nil {
+		return nil, err
+	}
+	problems := make([]string, 0)
+	for _, f := range files {
+		name := filepath.Base(f)
+		if !strings.HasSuffix(name, ".class") || name == "R.class" || strings.HasPrefix(name, "R$") {
+			continue
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not read '%v' due to error: %v", f, err)
+		}
+		ints, err := classIntConstants(b)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse '%v' due to error: %v", f, err)
+		}
+		class := strings.TrimSuffix(filepath.ToSlash(strings.TrimPrefix(f, classDir+string(filepath.Separator))), ".class")
+		for _, id := range ints {
+			if id>>24 != appPackageID || !types[id>>16] || ids[id] != "" {
+				continue
+			}
+			where := ""
+			if loc, err := findLiteral(sourceDirs, id); err == nil && loc != "" {
+				where = " at " + loc
+			}
+			problems = append(problems, fmt.Sprintf("%v: refers to resource ID %#08x%v, which no resource of the build has", strings.Replace(class, "/", ".", -1), id, where))
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// findLiteral returns the file and line of the first Java source under dirs
+// that spells id as a literal, in hexadecimal or decimal.
+func findLiteral(dirs []string, id uint32) (string, error) {
+	literal := regexp.MustCompile(fmt.Sprintf(`(?i)\b(0x0*%x|%d)\b`, id, id))
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		files, err := filesUnder(dir)
+		if err != nil {
+			return "", err
+		}
+		for _, f := range files {
+			if !strings.HasSuffix(f, ".java") {
+				continue
+			}
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				return "", err
+			}
+			for i, line := range strings.Split(string(b), "\n") {
+				if literal.MatchString(line) {
+					return fmt.Sprintf("%v:%v", f, i+1), nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// releaseConfig holds the settings of `blade release`, which are read from
+// the [release] table of the config:
+//
+//	[release]
+//	bump = "minor"
+//	version_code = "semver"
+//	tag_prefix = "v"
+type releaseConfig struct {
+	// bump is the part of a major.minor.patch version name to increment,
+	// which defaults to patch.
+	bump string
+	// versionCode is the scheme of the version code, either increment,
+	// which adds one, or semver, which derives it from the version name as
+	// major*10000 + minor*100 + patch.
+	versionCode string
+	tagPrefix   string
+}
+
+var (
+	releaseBumps        = []string{"major", "minor", "patch"}
+	releaseVersionCodes = []string{"increment", "semver"}
+)
+
+var defaultReleaseConfig = releaseConfig{bump: "patch", versionCode: "increment", tagPrefix: "v"}
+
+func newReleaseConfig(t map[string]interface{}) (releaseConfig, error) {
+	r := defaultReleaseConfig
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid [release] config: %v", err)
+	}
+	for key, v := range map[string]*string{"bump": &r.bump, "version_code": &r.versionCode, "tag_prefix": &r.tagPrefix} {
+		if _, ok := t[key]; !ok {
+			continue
+		}
+		s, err := stringValue(t, key)
+		if err != nil {
+			return r, wrap(err)
+		}
+		*v = s
+	}
+	if !contains(releaseBumps, r.bump) {
+		return r, wrap(fmt.Errorf("bump must be one of %v but was '%v'", releaseBumps, r.bump))
+	}
+	if !contains(releaseVersionCodes, r.versionCode) {
+		return r, wrap(fmt.Errorf("version_code must be one of %v but was '%v'", releaseVersionCodes, r.versionCode))
+	}
+	return r, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVersionName returns the major, minor and patch numbers of a version
+// name, of which minor and patch may be left out.
+func parseVersionName(name string) ([3]int, error) {
+	var v [3]int
+	parts := strings.Split(name, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("version name '%v' is not of the form major.minor.patch", name)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("version name '%v' is not of the form major.minor.patch", name)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// nextVersion returns the version code and name following the given ones
+// under the release config's scheme.
+func (r releaseConfig) nextVersion(code int, name string) (int, string, error) {
+	v, err := parseVersionName(name)
+	if err != nil {
+		return 0, "", err
+	}
+	switch r.bump {
+	case "major":
+		v = [3]int{v[0] + 1, 0, 0}
+	case "minor":
+		v = [3]int{v[0], v[1] + 1, 0}
+	default:
+		v[2]++
+	}
+	next := fmt.Sprintf("%v.%v.%v", v[0], v[1], v[2])
+	if r.versionCode != "semver" {
+		return code + 1, next, nil
+	}
+	if v[1] > 99 || v[2] > 99 {
+		return 0, "", fmt.Errorf("version name '%v' cannot be a semver version code, whose minor and patch are at most 99", next)
+	}
+	c := v[0]*10000 + v[1]*100 + v[2]
+	if c <= code {
+		return 0, "", fmt.Errorf("version code %v of version name '%v' is not greater than the current version code %v", c, next, code)
+	}
+	return c, next, nil
+}
+
+func git(args ...string) (string, error) {
+	return gitIn("", args...)
+}
+
+// gitIn runs git in dir, or the working directory if dir is empty.
+func gitIn(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("could not run git %v due to error: %v\n%v", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+var releaseCommit = regexp.MustCompile(`^Release \d+\.\d+\.\d+$`)
+
+// releaseNotes returns the subjects of the commits since the last release
+// tag, or of every commit if there is no such tag, along with the tag. The
+// commits of earlier version bumps are left out.
+func releaseNotes(tagPrefix string) (string, []string, error) {
+	tag, err := git("describe", "--tags", "--abbrev=0", "--match", tagPrefix+"*")
+	tag = strings.TrimSpace(tag)
+	commits := "HEAD"
+	if err != nil {
+		tag = ""
+	} else {
+		commits = tag + "..HEAD"
+	}
+	out, err := git("log", "--no-merges", "--format=%s", commits)
+	if err != nil {
+		return tag, nil, err
+	}
+	notes := make([]string, 0)
+	for _, l := range strings.Split(out, "\n") {
+		if l = strings.TrimSpace(l); l != "" && !releaseCommit.MatchString(l) {
+			notes = append(notes, l)
+		}
+	}
+	return tag, notes, nil
+}
+
+// setAppValue sets the key of the config's [app] table to the TOML value,
+// replacing the line declaring it if there is one.
+func setAppValue(config, key, value string) string {
+	line := fmt.Sprintf("%v = %v", key, value)
+	loc := appTableHeader.FindStringIndex(config)
+	if loc == nil {
+		return addToAppTable(config, []string{line})
+	}
+	end := len(config)
+	if next := tableHeader.FindStringIndex(config[loc[1]:]); next != nil {
+		end = loc[1] + next[0]
+	}
+	declared := regexp.MustCompile(`(?m)^[ \t]*` + regexp.QuoteMeta(key) + `[ \t]*=.*$`)
+	if m := declared.FindStringIndex(config[loc[1]:end]); m != nil {
+		return config[:loc[1]+m[0]] + line + config[loc[1]+m[1]:]
+	}
+	return addToAppTable(config, []string{line})
+}
+
+var tableHeader = regexp.MustCompile(`(?m)^[ \t]*\[`)
+
+// release bumps the version of the app, in the config if it declares it or
+// else in the manifest, commits the bump and tags it with notes collected
+// from the commits since the last release.
+func release(arguments []string) {
+	fs := flag.NewFlagSet("release", flag.ExitOnError)
+	manifestFilepath := fs.String("manifest", "AndroidManifest.xml", manifestDesc)
+	configFilepath := fs.String("config", defaultConfigFilepath, configDesc)
+	bump := fs.String("bump", "", "The part of the version name to increment, one of major, minor or patch (default bump under [release] in config, or patch)")
+	notesFilepath := fs.String("notes", "release-notes.txt", "The file to write the release notes to, for upload steps to read")
+	dryRun := fs.Bool("dry-run", false, "Print the next version and release notes without changing any file")
+	fs.Parse(arguments)
+
+	c, err := loadConfig(*configFilepath, false)
+	if err != nil {
+		exitWithError(withCode(errInvalidConfig, err))
+	}
+	r := c.release
+	if *bump != "" {
+		if !contains(releaseBumps, *bump) {
+			exitWithError(withCode(errInvalidFlags, fmt.Errorf("-bump must be one of %v but was '%v'", releaseBumps, *bump)))
+		}
+		r.bump = *bump
+	}
+	b, err := ioutil.ReadFile(*manifestFilepath)
+	if err != nil {
+		exitWithError(fmt.Errorf("could not read manifest at '%v' due to error: %v", *manifestFilepath, err))
+	}
+	m, err := readManifest(*manifestFilepath)
+	if err != nil {
+		exitWithError(withCode(errInvalidManifest, err))
+	}
+	if err := c.app.override(m); err != nil {
+		exitWithError(withCode(errInvalidManifest, err))
+	}
+	code, err := m.versionCode()
+	if err != nil {
+		exitWithError(withCode(errInvalidManifest, err))
+	}
+	if m.VersionName == "" {
+		exitWithError(fmt.Errorf("no version name is declared in the manifest or config to bump"))
+	}
+	nextCode, nextName, err := r.nextVersion(code, m.VersionName)
+	if err != nil {
+		exitWithError(err)
+	}
+	lastTag, notes, err := releaseNotes(r.tagPrefix)
+	if err != nil {
+		exitWithError(err)
+	}
+	tag := r.tagPrefix + nextName
+	since := "since " + lastTag
+	if lastTag == "" {
+		since = "since the first commit"
+	}
+	var w strings.Builder
+	for _, n := range notes {
+		fmt.Fprintf(&w, "- %v\n", n)
+	}
+	fmt.Printf("%v (%v) -> %v (%v), %v changes %v\n", m.VersionName, code, nextName, nextCode, len(notes), since)
+	if *dryRun {
+		fmt.Printf("\n%v", w.String())
+		return
+	}
+
+	// The bump is committed on its own, so the files it changes must not
+	// have changes of their own.
+	if out, err := git("status", "--porcelain", "--", c.path, *manifestFilepath); err != nil {
+		exitWithError(err)
+	} else if strings.TrimSpace(out) != "" {
+		exitWithError(fmt.Errorf("the config or manifest has uncommitted changes, which must be committed before releasing:\n%v", out))
+	}
+	configured := c.app.values()
+	_, codeConfigured := configured["version_code"]
+	_, nameConfigured := configured["version_name"]
+	changed := make([]string, 0, 2)
+	if codeConfigured || nameConfigured {
+		cb, err := ioutil.ReadFile(c.path)
+		if err != nil {
+			exitWithError(fmt.Errorf("could not read config file at '%v' due to error: %v", c.path, err))
+		}
+		s := string(cb)
+		if codeConfigured {
+			s = setAppValue(s, "version_code", strconv.Itoa(nextCode))
+		}
+		if nameConfigured {
+			s = setAppValue(s, "version_name", strconv.Quote(nextName))
+		}
+		if err := ioutil.WriteFile(c.path, []byte(s), 0664); err != nil {
+			exitWithError(fmt.Errorf("could not write config file at '%v' due to error: %v", c.path, err))
+		}
+		changed = append(changed, c.path)
+	}
+	manifest := string(b)
+	for key, v := range map[string]string{"version_code": strconv.Itoa(nextCode), "version_name": nextName} {
+		if _, ok := configured[key]; ok {
+			continue
+		}
+		for _, s := range appSettings {
+			if s.key == key {
+				if manifest, err = setElementAttribute(manifest, s.element, s.attr, escapeAttribute(v)); err != nil {
+					exitWithError(withCode(errInvalidManifest, err))
+				}
+			}
+		}
+	}
+	if manifest != string(b) {
+		if err := ioutil.WriteFile(*manifestFilepath, []byte(manifest), 0664); err != nil {
+			exitWithError(fmt.Errorf("could not write manifest at '%v' due to error: %v", *manifestFilepath, err))
+		}
+		changed = append(changed, *manifestFilepath)
+	}
+	if err := ioutil.WriteFile(*notesFilepath, []byte(w.String()), 0664); err != nil {
+		exitWithError(fmt.Errorf("could not write release notes to '%v' due to error: %v", *notesFilepath, err))
+	}
+
+	if _, err := git(append([]string{"commit", "-m", "Release " + nextName, "--"}, changed...)...); err != nil {
+		exitWithError(err)
+	}
+	if _, err := git("tag", "-a", tag, "-m", "Release "+nextName+"\n\n"+w.String()); err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf("tagged %v, with release notes in %v\n", tag, *notesFilepath)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// remote runs toolchain commands on another machine over SSH.
+//
+// The directories a command reads from are mirrored to the same absolute
+// paths on the remote host with rsync before it runs, and the directories
+// it writes to are mirrored back afterwards, so commands run unchanged. As
+// such the Android SDK and a JDK must be installed at the same paths on the
+// remote host as they are locally.
+//
+// Inputs are mirrored with --delete, so that files removed locally, such as
+// the classes of deleted sources that the compile stage clears, are removed
+// from the remote host before the command could read them.
+type remote struct {
+	host string
+	// inputDirs are mirrored to the remote host before each command.
+	inputDirs []string
+	// outputDirs are mirrored back from the remote host after each command.
+	outputDirs []string
+	// unset are environment variables to unset before each command.
+	unset []string
+	// mu keeps commands from running at once, as mirroring the inputs of
+	// one would delete the outputs of another before they are mirrored
+	// back.
+	mu sync.Mutex
+}
+
+func newRemote(host string, inputDirs, outputDirs []string) *remote {
+	return &remote{
+		host:       host,
+		inputDirs:  outermostDirs(append(append([]string(nil), inputDirs...), outputDirs...)),
+		outputDirs: outermostDirs(outputDirs),
+	}
+}
+
+// outermostDirs returns the absolute paths of dirs that are not inside any
+// of the others.
+func outermostDirs(dirs []string) []string {
+	abs := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		if p, err := filepath.Abs(d); err == nil {
+			abs = append(abs, p)
+		}
+	}
+	sort.Strings(abs)
+	outermost := make([]string, 0, len(abs))
+	for _, d := range abs {
+		n := len(outermost)
+		if n > 0 && (d == outermost[n-1] || strings.HasPrefix(d, outermost[n-1]+string(filepath.Separator))) {
+			continue
+		}
+		outermost = append(outermost, d)
+	}
+	return outermost
+}
+
+// run runs the command of args on the remote host from the remote mirror of the current
+// working directory.
+func (r *remote) run(args []string, stdout, stderr io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.rsync(stderr, append(append([]string{"--relative", "--delete"}, r.inputDirs...), r.host+":/")...); err != nil {
+		return withCode(errRemoteOrContainer, fmt.Errorf("could not copy inputs to remote host '%v' due to error: %v", r.host, err))
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("could not determine working directory due to error: %v", err)
+	}
+	s := make([]string, len(args))
+	for i, a := range args {
+		s[i] = shellQuote(a)
+	}
+	script := fmt.Sprintf("cd %v && %v", shellQuote(wd), strings.Join(s, " "))
+	if len(r.unset) > 0 {
+		script = fmt.Sprintf("unset %v; %v", strings.Join(r.unset, " "), script)
+	}
+	cmd := exec.Command("ssh", r.host, script)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error when running command %v on remote host '%v' : %v", strings.Join(args, " "), r.host, err)
+	}
+	for _, d := range r.outputDirs {
+		if err := r.rsync(stderr, "--relative", r.host+":"+d, "/"); err != nil {
+			return withCode(errRemoteOrContainer, fmt.Errorf("could not copy outputs from remote host '%v' due to error: %v", r.host, err))
+		}
+	}
+	return nil
+}
+
+func (r *remote) rsync(stderr io.Writer, arguments ...string) error {
+	cmd := exec.Command("rsync", append([]string{"--archive", "--compress", "--exclude", ".git"}, arguments...)...)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell command.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// buildReport collects what the stages of a build printed, to write with
+// the build's stats as a self-contained HTML page for people to read, such
+// as attached to a CI run.
+type buildReport struct {
+	mu          sync.Mutex
+	diagnostics map[string]string
+}
+
+func newBuildReport() *buildReport {
+	return &buildReport{diagnostics: make(map[string]string)}
+}
+
+// add records what the stage printed, and how it failed if it did.
+func (r *buildReport) add(name string, output []byte, err error) {
+	s := strings.TrimSpace(string(output))
+	if err != nil {
+		s = strings.TrimSpace(s + "\n" + err.Error())
+	}
+	if s == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.diagnostics[name] = s
+}
+
+type reportStage struct {
+	stageStats
+	// Percent is the share of the slowest stage's time the stage took.
+	Percent float64
+}
+
+type reportDiagnostic struct {
+	Stage  string
+	Output string
+}
+
+type reportAPK struct {
+	Name       string
+	Size       int64
+	Categories []reportCategory
+}
+
+type reportCategory struct {
+	Name    string
+	Size    int64
+	Percent float64
+}
+
+// apkCategory returns what part of an APK the entry belongs to.
+func apkCategory(name string) string {
+	switch {
+	case strings.HasPrefix(name, "classes") && strings.HasSuffix(name, ".dex"):
+		return "dex"
+	case name == "resources.arsc":
+		return "resources.arsc"
+	case name == "AndroidManifest.xml":
+		return "manifest"
+	case strings.Contains(name, "/"):
+		return name[:strings.Index(name, "/")+1]
+	}
+	return "other"
+}
+
+// apkSizes returns how much of the APK at p each of its parts takes, by
+// their compressed size, which is what downloads cost.
+func apkSizes(p string) (reportAPK, error) {
+	a := reportAPK{Name: path.Base(p)}
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return a, fmt.Errorf("could not open APK '%v' due to error: %v", p, err)
+	}
+	defer zr.Close()
+	sizes := make(map[string]int64)
+	for _, f := range zr.File {
+		sizes[apkCategory(f.Name)] += int64(f.CompressedSize64)
+	}
+	if fi, err := os.Stat(p); err == nil {
+		a.Size = fi.Size()
+	}
+	for name, size := range sizes {
+		c := reportCategory{Name: name, Size: size}
+		if a.Size > 0 {
+			c.Percent = 100 * float64(size) / float64(a.Size)
+		}
+		a.Categories = append(a.Categories, c)
+	}
+	sort.Slice(a.Categories, func(i, j int) bool { return a.Categories[i].Size > a.Categories[j].Size })
+	return a, nil
+}
+
+// write writes the report of the build, which failed with buildErr if not
+// nil, as HTML to path.
+func (r *buildReport) write(path string, b *build, st *buildStats, buildErr error) error {
+	st.mu.Lock()
+	stages := make([]reportStage, 0, len(st.Stages))
+	slowest := 0.0
+	for _, s := range st.Stages {
+		stages = append(stages, reportStage{stageStats: s})
+		if s.Seconds > slowest {
+			slowest = s.Seconds
+		}
+	}
+	st.mu.Unlock()
+	for i := range stages {
+		if slowest > 0 {
+			stages[i].Percent = 100 * stages[i].Seconds / slowest
+		}
+	}
+	sort.SliceStable(stages, func(i, j int) bool { return stages[i].Seconds > stages[j].Seconds })
+
+	r.mu.Lock()
+	diagnostics := make([]reportDiagnostic, 0, len(r.diagnostics))
+	for name, output := range r.diagnostics {
+		diagnostics = append(diagnostics, reportDiagnostic{name, output})
+	}
+	r.mu.Unlock()
+	sort.Slice(diagnostics, func(i, j int) bool { return diagnostics[i].Stage < diagnostics[j].Stage })
+
+	apks := make([]reportAPK, 0, len(b.outputs))
+	if buildErr == nil {
+		for _, o := range b.outputs {
+			a, err := apkSizes(o.filepath)
+			if err != nil {
+				return err
+			}
+			apks = append(apks, a)
+		}
+	}
+
+	// Templated paths, such as reports/{variant}/{date}.html, may name
+	// directories that do not exist yet.
+	if err := os.MkdirAll(filepath.Dir(path), 0774); err != nil {
+		return fmt.Errorf("could not create directory of report '%v' due to error: %v", path, err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create report '%v' due to error: %v", path, err)
+	}
+	defer f.Close()
+	data := map[string]interface{}{
+		"ApplicationID": b.applicationID,
+		"Variant":       b.variant.name,
+		"VersionName":   b.manifest.VersionName,
+		"Start":         st.Start.Format("2006-01-02 15:04:05 MST"),
+		"Seconds":       st.Seconds,
+		"Error":         buildErr,
+		"Stages":        stages,
+		"Diagnostics":   diagnostics,
+		"APKs":          apks,
+		"Libraries":     b.libraries,
+	}
+	if err := reportTemplate.Execute(f, data); err != nil {
+		return fmt.Errorf("could not write report '%v' due to error: %v", path, err)
+	}
+	return nil
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"kb": func(n int64) string { return fmt.Sprintf("%.1f KB", float64(n)/1024) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>blade build of {{.ApplicationID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { padding: 0.2em 0.8em; text-align: left; }
+td.n { text-align: right; font-variant-numeric: tabular-nums; }
+.bar { background: #4a90d9; height: 0.8em; }
+.failed { color: #c0392b; }
+.ok { color: #27ae60; }
+pre { background: #f4f4f4; padding: 0.8em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.ApplicationID}} {{.VersionName}} ({{.Variant}})</h1>
+<p>Built at {{.Start}} in {{printf "%.1f" .Seconds}}s:
+{{if .Error}}<span class="failed">failed</span>{{else}}<span class="ok">succeeded</span>{{end}}</p>
+{{if .Error}}<pre class="failed">{{.Error}}</pre>{{end}}
+
+<h2>Stages</h2>
+<table>
+<tr><th>Stage</th><th>Time</th><th></th></tr>
+{{range .Stages}}<tr><td>{{.Name}}</td><td class="n">{{if .Skipped}}up to date{{else}}{{printf "%.2f" .Seconds}}s{{end}}</td><td><div class="bar" style="width: {{printf "%.0f" .Percent}}px"></div></td></tr>
+{{end}}</table>
+
+{{if .Diagnostics}}<h2>Diagnostics</h2>
+{{range .Diagnostics}}<h3>{{.Stage}}</h3>
+<pre>{{.Output}}</pre>
+{{end}}{{end}}
+
+{{range .APKs}}<h2>{{.Name}}: {{kb .Size}}</h2>
+<table>
+<tr><th>Part</th><th>Compressed</th><th>Share</th></tr>
+{{range .Categories}}<tr><td>{{.Name}}</td><td class="n">{{kb .Size}}</td><td class="n">{{printf "%.1f" .Percent}}%</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Dependencies</h2>
+{{if .Libraries}}<ul>
+{{range .Libraries}}<li>{{.}}</li>
+{{end}}</ul>{{else}}<p>None</p>{{end}}
+</body>
+</html>
+`))
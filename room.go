@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// room holds the settings for Room's schema export, declared in blade.toml
+// as:
+//
+//	[room]
+//	schema_dir = "schemas"
+//	require_version_bump = true
+//
+// The Room annotation processor, itself configured as a javac option, then
+// exports the schema of each database version as schemas/<database>/<n>.json
+// for those files to be committed. With require_version_bump set, the build
+// fails when the schema of an already exported version changes, since such
+// a change needs a new version and migration.
+type room struct {
+	schemaDir          string
+	requireVersionBump bool
+}
+
+func newRoom(c *config, t map[string]interface{}) (room, error) {
+	r := room{}
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid [room] config: %v", err)
+	}
+	var err error
+	if r.schemaDir, err = stringValue(t, "schema_dir"); err != nil {
+		return r, wrap(err)
+	}
+	if r.requireVersionBump, err = boolValue(t, "require_version_bump"); err != nil {
+		return r, wrap(err)
+	}
+	if r.requireVersionBump && r.schemaDir == "" {
+		return r, wrap(fmt.Errorf("require_version_bump needs a schema_dir to find exported schemas in"))
+	}
+	if r.schemaDir != "" {
+		r.schemaDir = c.resolve(r.schemaDir)
+	}
+	return r, nil
+}
+
+// javacArgs returns the arguments that tell the Room annotation processor
+// where to export schemas to.
+func (r room) javacArgs() []string {
+	if r.schemaDir == "" {
+		return nil
+	}
+	return []string{"-Aroom.schemaLocation=" + r.schemaDir}
+}
+
+// schemas returns the contents of the exported schemas, by path.
+func (r room) schemas() (map[string][]byte, error) {
+	schemas := make(map[string][]byte)
+	if r.schemaDir == "" {
+		return schemas, nil
+	}
+	files, err := filesUnder(r.schemaDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not read Room schema '%v' due to error: %v", f, err)
+		}
+		schemas[f] = b
+	}
+	return schemas, nil
+}
+
+// checkVersionBumps returns an error naming the schemas that differ from
+// those exported before compiling, if version bumps are required.
+func (r room) checkVersionBumps(before map[string][]byte) error {
+	if !r.requireVersionBump {
+		return nil
+	}
+	after, err := r.schemas()
+	if err != nil {
+		return err
+	}
+	changed := make([]string, 0)
+	for p, b := range before {
+		if a, ok := after[p]; ok && !bytes.Equal(a, b) {
+			db := filepath.Base(filepath.Dir(p))
+			version := strings.TrimSuffix(filepath.Base(p), ".json")
+			changed = append(changed, fmt.Sprintf("%v version %v", db, version))
+			// Restore the committed schema so the next build fails as well.
+			if err := ioutil.WriteFile(p, b, 0664); err != nil {
+				return fmt.Errorf("could not restore Room schema '%v' due to error: %v", p, err)
+			}
+		}
+	}
+	if len(changed) > 0 {
+		return withCode(errRoomSchema, fmt.Errorf("the Room schema changed without its database version being bumped for: %v", strings.Join(changed, ", ")))
+	}
+	return nil
+}
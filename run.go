@@ -0,0 +1,475 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runPreset is a launch scenario for `blade run -preset <name>`, declared in
+// config as:
+//
+//	[run.demo]
+//	device = "emulator-*"
+//	activity = ".DemoActivity"
+//	extras = { demo_mode = true, account = "demo@example.com", items = 20 }
+//	logcat = ["Demo:V", "*:S"]
+//	locale = "de-DE"
+//	font_scale = 1.3
+//	env = { ADB_SERVER_SOCKET = "tcp:localhost:5038" }
+type runPreset struct {
+	// device is the serial or name of the device to run on, or a pattern
+	// matching the serials listed by `adb devices`, such as "emulator-*".
+	device string
+	// activity is the activity to start, by default the app's launcher
+	// activity, which may be relative to the app's package.
+	activity string
+	// extras are the intent extras to start the activity with, as strings,
+	// booleans or integers.
+	extras map[string]interface{}
+	// logcat are the filters of the logcat printed once the app starts, by
+	// default none.
+	logcat []string
+	// env holds the environment variables to run adb with.
+	env map[string]string
+	// locale is the locale to run the app in, such as "de-DE".
+	locale string
+	// fontScale is the font scale to set on the device, such as 1.3, or
+	// zero to leave it as it is.
+	fontScale float64
+}
+
+func newRunPreset(name string, t map[string]interface{}) (runPreset, error) {
+	r := runPreset{}
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid [run.%v] config: %v", name, err)
+	}
+	var err error
+	if r.device, err = stringValue(t, "device"); err != nil {
+		return r, wrap(err)
+	}
+	if r.activity, err = stringValue(t, "activity"); err != nil {
+		return r, wrap(err)
+	}
+	if r.extras, err = table(t, "extras"); err != nil {
+		return r, wrap(err)
+	}
+	for k, v := range r.extras {
+		switch v.(type) {
+		case string, bool, int64:
+		default:
+			return r, wrap(fmt.Errorf("extra '%v' must be a string, boolean or integer but was '%v'", k, v))
+		}
+	}
+	if r.logcat, err = stringList(t, "logcat"); err != nil {
+		return r, wrap(err)
+	}
+	if r.env, err = stringMap(t, "env"); err != nil {
+		return r, wrap(err)
+	}
+	if r.locale, err = stringValue(t, "locale"); err != nil {
+		return r, wrap(err)
+	}
+	switch v := t["font_scale"].(type) {
+	case nil:
+	case float64:
+		r.fontScale = v
+	case int64:
+		r.fontScale = float64(v)
+	default:
+		return r, wrap(fmt.Errorf("config key 'font_scale' must be a number but was '%v'", v))
+	}
+	return r, nil
+}
+
+// extrasArgs returns the extras as arguments of `am start`, in order of key.
+func (r runPreset) extrasArgs() []string {
+	keys := make([]string, 0, len(r.extras))
+	for k := range r.extras {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, 3*len(keys))
+	for _, k := range keys {
+		switch v := r.extras[k].(type) {
+		case string:
+			// adb shell joins its arguments into one command line.
+			args = append(args, "--es", shellQuote(k), shellQuote(v))
+		case bool:
+			args = append(args, "--ez", shellQuote(k), strconv.FormatBool(v))
+		case int64:
+			args = append(args, "--ei", shellQuote(k), strconv.FormatInt(v, 10))
+		}
+	}
+	return args
+}
+
+// matchDevice returns the serial of the first connected device that pattern
+// matches, in the order of their serials.
+func matchDevice(d device, pattern string) (string, error) {
+	out, err := d.adb("devices")
+	if err != nil {
+		return "", err
+	}
+	serials := make([]string, 0)
+	for _, l := range strings.Split(out, "\n")[1:] {
+		if f := strings.Fields(l); len(f) == 2 && f[1] == "device" {
+			serials = append(serials, f[0])
+		}
+	}
+	sort.Strings(serials)
+	for _, s := range serials {
+		if ok, err := path.Match(pattern, s); err != nil {
+			return "", fmt.Errorf("invalid device pattern '%v': %v", pattern, err)
+		} else if ok {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("no device connected matches '%v', of: %v", pattern, strings.Join(serials, ", "))
+}
+
+// launchableComponent returns the component of the launcher activity of the
+// APK at apk.
+func launchableComponent(apk string) (string, error) {
+	g, err := readBadging(apk)
+	if err != nil {
+		return "", err
+	}
+	if g.LaunchableActivity == "" {
+		return "", fmt.Errorf("'%v' has no launcher activity, one with the MAIN action and LAUNCHER category", apk)
+	}
+	return g.Package + "/" + g.LaunchableActivity, nil
+}
+
+// launcherActivity returns the component of the launcher activity of the
+// installed app as the device's package manager resolves it, for apps that
+// were not built with blade.
+func launcherActivity(d device, pkg string) (string, error) {
+	out, err := d.adb("shell", "cmd", "package", "resolve-activity", "--brief", "-c", "android.intent.category.LAUNCHER", pkg)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	component := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.Contains(component, "/") {
+		return "", fmt.Errorf("no launcher activity of '%v' was found, so one must be given with -activity", pkg)
+	}
+	return component, nil
+}
+
+// runCommand installs the APK of the build described by the build flags on a
+// device and starts it, as set up by the flags or the preset given with
+// -preset, with flags taking precedence over the preset. The APK must have
+// been built already. While it follows the app's logcat, it fails as soon as
+// the app crashes or stops responding, and once it ends any settings changed
+// on the device for the run are restored.
+func runCommand(arguments []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	args := &buildArgs{}
+	args.register(fs)
+	serial := fs.String("device", "", deviceDesc)
+	name := fs.String("preset", "", "The launch scenario declared as [run.<name>] in config to run the app with")
+	activity := fs.String("activity", "", "The activity to start, by default that of the preset or else the launcher activity")
+	tombstones := fs.String("tombstones", "tombstones", "The directory to pull the tombstones of native crashes of the app to when watching it")
+	symbols := fs.String("symbols", "", "The directory of the unstripped shared libraries of the app, to symbolize the tombstones of native crashes with ndk-stack")
+	grant := fs.Bool("grant-permissions", false, grantDesc)
+	locale := fs.String("locale", "", "The locale to run the app in, such as de-DE, which needs API 33 or later, until run ends")
+	fontScale := fs.Float64("font-scale", 0, "The font scale to set on the device, such as 1.3, until run ends")
+	profile := fs.Bool("startup-profile", false, "Collect the classes and methods the app runs while starting into the startup profile that the next build lays out the dex by, which needs API 33 or later and `blade adb root`")
+	watch := fs.Bool("watch", false, "Keep watching the app once started, until interrupted, and fail as soon as it crashes or stops responding, which is also done while printing the preset's logcat")
+	color := registerColor(fs)
+	args.parse(fs, arguments)
+	applyColor(fs, *color)
+
+	b, err := newBuild(args)
+	if err != nil {
+		exitWithError(err)
+	}
+	preset := runPreset{}
+	if *name != "" {
+		var ok bool
+		if preset, ok = b.config.runPresets[*name]; !ok {
+			exitWithError(withCode(errInvalidConfig, fmt.Errorf("no run preset '%v' is declared as [run.%v] in config", *name, *name)))
+		}
+	}
+	if *serial == "" {
+		*serial = preset.device
+	}
+	if *activity == "" {
+		*activity = preset.activity
+	}
+	if *locale == "" {
+		*locale = preset.locale
+	}
+	if *fontScale == 0 {
+		*fontScale = preset.fontScale
+	}
+	for k, v := range preset.env {
+		os.Setenv(k, v)
+	}
+
+	pattern := ""
+	if strings.ContainsAny(*serial, "*?[") {
+		pattern, *serial = *serial, ""
+	}
+	d, err := newDevice(args.androidHome, *serial)
+	if err != nil {
+		exitWithError(err)
+	}
+	if pattern != "" {
+		if d.serial, err = matchDevice(d, pattern); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	apk := b.outputPath(b.outputs[0].filepath)
+	if _, err := os.Stat(apk); err != nil {
+		exitWithError(fmt.Errorf("no APK was found at '%v', so build it first by running blade with the same build flags", apk))
+	}
+	if err := d.checkCompatible(apk); err != nil {
+		exitWithError(err)
+	}
+	if err := d.run("install", "-r", apk); err != nil {
+		exitWithStatus(err)
+	}
+	if *grant {
+		if err := grantPermissions(d, b.applicationID, apk); err != nil {
+			exitWithError(err)
+		}
+	}
+	// Activities are classes of the manifest's package, whatever the
+	// application ID.
+	component := b.applicationID + "/" + className(b.manifest.Package, *activity)
+	if *activity == "" {
+		if b.manifest.LaunchableActivity == "" {
+			exitWithError(fmt.Errorf("the manifest has no launcher activity, one with the MAIN action and LAUNCHER category, so one must be given with -activity"))
+		}
+		component = b.applicationID + "/" + b.manifest.LaunchableActivity
+	}
+	// Settings changed on the device are restored when run ends, so it stays
+	// attached until then.
+	*watch = *watch || len(preset.logcat) > 0 || *locale != "" || *fontScale != 0
+	if *watch {
+		if _, err := d.adb("logcat", "-c"); err != nil {
+			exitWithError(err)
+		}
+	}
+	restore, err := configureDevice(d, b.applicationID, *locale, *fontScale)
+	if err != nil {
+		exitWithError(err)
+	}
+	started := time.Now()
+	start := append([]string{"shell", "am", "start", "-W", "-n", component}, preset.extrasArgs()...)
+	if err := d.run(start...); err != nil {
+		restore()
+		exitWithStatus(err)
+	}
+	if *profile {
+		if err := collectStartupProfile(d, b.applicationID, b.startupProfile()); err != nil {
+			restore()
+			exitWithError(err)
+		}
+		fmt.Printf("collected startup profile to %v\n", b.startupProfile())
+	}
+	if !*watch {
+		return
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	failures := make(chan []string)
+	watchdog, err := watchForFailures(d, b.applicationID, failures)
+	if err != nil {
+		restore()
+		exitWithError(err)
+	}
+	defer watchdog.Process.Kill()
+	done := make(chan error, 1)
+	var logcat *exec.Cmd
+	if len(preset.logcat) > 0 {
+		logcat = exec.Command(d.adbPath(), d.adbArgs(append([]string{"logcat"}, preset.logcat...))...)
+		logcat.Stdout = os.Stdout
+		if c, _ := useColor(*color, os.Stdout); c {
+			logcat.Stdout = &logcatWriter{w: os.Stdout}
+		}
+		logcat.Stderr = os.Stderr
+		if err := logcat.Start(); err != nil {
+			restore()
+			exitWithError(fmt.Errorf("could not run adb logcat due to error: %v", err))
+		}
+		go func() { done <- logcat.Wait() }()
+	}
+	select {
+	case lines := <-failures:
+		if logcat != nil {
+			logcat.Process.Kill()
+		}
+		watchdog.Process.Kill()
+		reportFailure(os.Stderr, b.applicationID, lines)
+		if isNativeCrash(lines) {
+			collectTombstones(d, b.applicationID, started, *tombstones, *symbols)
+		}
+		restore()
+		os.Exit(1)
+	case <-interrupted:
+		restore()
+	case err := <-done:
+		restore()
+		if err != nil {
+			exitWithStatus(err)
+		}
+	}
+}
+
+// configureDevice runs the app in locale, and sets the font scale of the
+// device if not zero, returning a function that restores both as they were.
+// Only the app's locale is set, as API 33 allows, so that the device's own
+// does not change.
+func configureDevice(d device, pkg, locale string, fontScale float64) (func(), error) {
+	restores := make([]func(), 0)
+	restore := func() {
+		for _, r := range restores {
+			r()
+		}
+	}
+	warn := func(err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", yellow(fmt.Sprintf("could not restore device settings due to error: %v", err)))
+		}
+	}
+	if locale != "" {
+		out, err := d.adb("shell", "cmd", "locale", "get-app-locales", pkg)
+		if err != nil {
+			return restore, fmt.Errorf("could not read the locale of '%v', which needs API 33 or later: %v", pkg, err)
+		}
+		previous := ""
+		if i, j := strings.Index(out, "["), strings.LastIndex(out, "]"); i >= 0 && j > i {
+			previous = out[i+1 : j]
+		}
+		if _, err := d.adb("shell", "cmd", "locale", "set-app-locales", pkg, "--locales", shellQuote(locale)); err != nil {
+			return restore, fmt.Errorf("could not set the locale of '%v' due to error: %v", pkg, err)
+		}
+		restores = append(restores, func() {
+			_, err := d.adb("shell", "cmd", "locale", "set-app-locales", pkg, "--locales", shellQuote(previous))
+			warn(err)
+		})
+	}
+	if fontScale != 0 {
+		out, err := d.adb("shell", "settings", "get", "system", "font_scale")
+		if err != nil {
+			restore()
+			return func() {}, err
+		}
+		previous := strings.TrimSpace(out)
+		if _, err := d.adb("shell", "settings", "put", "system", "font_scale", strconv.FormatFloat(fontScale, 'f', -1, 64)); err != nil {
+			restore()
+			return func() {}, err
+		}
+		restores = append(restores, func() {
+			var err error
+			if previous == "null" || previous == "" {
+				_, err = d.adb("shell", "settings", "delete", "system", "font_scale")
+			} else {
+				_, err = d.adb("shell", "settings", "put", "system", "font_scale", previous)
+			}
+			warn(err)
+		})
+	}
+	return restore, nil
+}
+
+// runtimePermissions are the permissions of the platform whose protection
+// level is dangerous, which apps must be granted at runtime from API 23.
+var runtimePermissions = []string{
+	"android.permission.ACCEPT_HANDOVER",
+	"android.permission.ACCESS_BACKGROUND_LOCATION",
+	"android.permission.ACCESS_COARSE_LOCATION",
+	"android.permission.ACCESS_FINE_LOCATION",
+	"android.permission.ACCESS_MEDIA_LOCATION",
+	"android.permission.ACTIVITY_RECOGNITION",
+	"android.permission.ADD_VOICEMAIL",
+	"android.permission.ANSWER_PHONE_CALLS",
+	"android.permission.BLUETOOTH_ADVERTISE",
+	"android.permission.BLUETOOTH_CONNECT",
+	"android.permission.BLUETOOTH_SCAN",
+	"android.permission.BODY_SENSORS",
+	"android.permission.BODY_SENSORS_BACKGROUND",
+	"android.permission.CALL_PHONE",
+	"android.permission.CAMERA",
+	"android.permission.GET_ACCOUNTS",
+	"android.permission.NEARBY_WIFI_DEVICES",
+	"android.permission.POST_NOTIFICATIONS",
+	"android.permission.PROCESS_OUTGOING_CALLS",
+	"android.permission.READ_CALENDAR",
+	"android.permission.READ_CALL_LOG",
+	"android.permission.READ_CONTACTS",
+	"android.permission.READ_EXTERNAL_STORAGE",
+	"android.permission.READ_MEDIA_AUDIO",
+	"android.permission.READ_MEDIA_IMAGES",
+	"android.permission.READ_MEDIA_VIDEO",
+	"android.permission.READ_MEDIA_VISUAL_USER_SELECTED",
+	"android.permission.READ_PHONE_NUMBERS",
+	"android.permission.READ_PHONE_STATE",
+	"android.permission.READ_SMS",
+	"android.permission.RECEIVE_MMS",
+	"android.permission.RECEIVE_SMS",
+	"android.permission.RECEIVE_WAP_PUSH",
+	"android.permission.RECORD_AUDIO",
+	"android.permission.SEND_SMS",
+	"android.permission.USE_SIP",
+	"android.permission.UWB_RANGING",
+	"android.permission.WRITE_CALENDAR",
+	"android.permission.WRITE_CALL_LOG",
+	"android.permission.WRITE_CONTACTS",
+	"android.permission.WRITE_EXTERNAL_STORAGE",
+}
+
+// grantPermissions grants pkg the runtime permissions that the APK at apk
+// requests. Those the device's API level does not have, or grants already,
+// fail to be granted, which is only warned of.
+func grantPermissions(d device, pkg, apk string) error {
+	g, err := readBadging(apk)
+	if err != nil {
+		return err
+	}
+	for _, p := range g.Permissions {
+		if !contains(runtimePermissions, p) {
+			continue
+		}
+		if _, err := d.adb("shell", "pm", "grant", pkg, p); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", yellow(fmt.Sprintf("could not grant %v: %v", p, err)))
+			continue
+		}
+		fmt.Printf("granted %v\n", p)
+	}
+	return nil
+}
+
+// collectTombstones pulls the tombstones of the app's native crashes since
+// started to dir, and symbolizes them if symbols are given, warning of any
+// that could not be.
+func collectTombstones(d device, pkg string, started time.Time, dir, symbols string) {
+	// debuggerd writes the tombstone once it has logged the crash.
+	time.Sleep(time.Second)
+	paths, err := pullTombstones(d, pkg, started, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", yellow(err.Error()))
+	}
+	for _, p := range paths {
+		if symbols != "" {
+			if s, err := symbolize(p, symbols); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", yellow(err.Error()))
+			} else {
+				p = s
+			}
+		}
+		fmt.Fprintf(os.Stderr, "tombstone: %v\n", p)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// signWithCommand signs the APK at in to out with the signCommand of a
+// variant, in which {in} and {out} are replaced by their absolute paths.
+// Like generators, the command runs on the host from the config file's
+// directory, since signing services and keys are reached from there.
+func signWithCommand(c *config, signCommand []string, in, out string, stdout, stderr io.Writer) error {
+	r := strings.NewReplacer("{in}", absPath(in), "{out}", absPath(out))
+	command := make([]string, len(signCommand))
+	for i, s := range signCommand {
+		command[i] = r.Replace(s)
+	}
+	// An APK left by an earlier build must not pass for the signed one.
+	if err := os.Remove(out); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove previously signed APK '%v' due to error: %v", out, err)
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = c.dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error when running command %v : %v", strings.Join(command, " "), err)
+	}
+	if _, err := os.Stat(out); err != nil {
+		return fmt.Errorf("the signing command %v wrote no signed APK to {out}", strings.Join(signCommand, " "))
+	}
+	return nil
+}
+
+// defaultSignSchemes are the schemes APKs are signed with the debug key by:
+// v1 for Android 6 and earlier, v2 for Android 7 and later, which installs
+// faster and verifies the whole APK, and v3 for key rotation from Android 9.
+const defaultSignSchemes = "v1,v2,v3"
+
+// validateSignSchemes checks that s lists only schemes apksigner signs by,
+// including v2 or v3 whenever it lists v4, which builds on them.
+func validateSignSchemes(s string) error {
+	if s == "" {
+		return nil
+	}
+	given := make(map[string]bool)
+	for _, scheme := range strings.Split(s, ",") {
+		known := false
+		for _, k := range signatureSchemes {
+			known = known || k == scheme
+		}
+		if !known {
+			return fmt.Errorf("unknown signature scheme '%v' in -sign-schemes, expected some of: %v", scheme, strings.Join(signatureSchemes, ", "))
+		}
+		given[scheme] = true
+	}
+	if given["v4"] && !given["v2"] && !given["v3"] {
+		return fmt.Errorf("signature scheme v4 in -sign-schemes needs v2 or v3 as well")
+	}
+	return nil
+}
+
+// signsIDSig reports whether the build signs its APKs with the debug key by
+// APK Signature Scheme v4, which writes an .idsig file beside each.
+func (b *build) signsIDSig() bool {
+	if len(b.variant.signCommand) > 0 {
+		return false
+	}
+	for _, s := range b.args.signSchemeList() {
+		if s == "v4" {
+			return true
+		}
+	}
+	return false
+}
+
+// signSchemeList returns the signature schemes given by -sign-schemes.
+func (args *buildArgs) signSchemeList() []string {
+	if args.signSchemes == "" {
+		return strings.Split(defaultSignSchemes, ",")
+	}
+	return strings.Split(args.signSchemes, ",")
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sizesFilepath = ".blade/sizes.jsonl"
+
+// sizeRecord records the sizes of the APKs of a build. Records are appended
+// as lines of JSON to a file in the output dir, only when any size changed,
+// so that the file is a history of how the app grew without any
+// infrastructure beyond the machine it is built on.
+type sizeRecord struct {
+	Start       time.Time `json:"start"`
+	Variant     string    `json:"variant"`
+	VersionCode int       `json:"version_code"`
+	VersionName string    `json:"version_name"`
+	APKs        []apkSize `json:"apks"`
+}
+
+// apkSize is the size of an APK, keyed by its split rather than its name,
+// which may be templated with the version or date.
+type apkSize struct {
+	Split string `json:"split"`
+	Size  int64  `json:"size"`
+}
+
+// split returns the name of the APK's split: its density, or universal.
+func (o apkOutput) split() string {
+	if o.density == "" {
+		return "universal"
+	}
+	return o.density
+}
+
+// sizeLimit is how much an APK may grow by since the last build recorded,
+// either as a percentage or a number of bytes.
+type sizeLimit struct {
+	percent float64
+	bytes   int64
+}
+
+// parseSizeLimit parses limits such as "5%" or "100KB", or "" as no limit.
+func parseSizeLimit(s string) (*sizeLimit, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("size increase '%v' must be a percentage such as 5%%, or a size such as 100KB", s)
+		}
+		return &sizeLimit{percent: p}, nil
+	}
+	n, err := parseSize(s)
+	if err != nil {
+		return nil, fmt.Errorf("size increase '%v' must be a percentage such as 5%%, or a size such as 100KB", s)
+	}
+	return &sizeLimit{bytes: n}, nil
+}
+
+// exceeded reports whether growing from before to after bytes exceeds the
+// limit.
+func (l *sizeLimit) exceeded(before, after int64) bool {
+	if l.percent > 0 || l.bytes == 0 {
+		return float64(after-before) > float64(before)*l.percent/100
+	}
+	return after-before > l.bytes
+}
+
+// recordSizes appends the sizes of the build's APKs to its size history,
+// unless they are those of the last build of the variant recorded. Given
+// -fail-on-size-increase, it first fails if any APK grew by more than that
+// since then, leaving the history as it was so that later builds are still
+// compared against the last that passed.
+func (b *build) recordSizes() error {
+	versionCode, err := b.manifest.versionCode()
+	if err != nil {
+		return err
+	}
+	r := &sizeRecord{Start: b.started, Variant: b.variant.name, VersionCode: versionCode, VersionName: b.manifest.VersionName}
+	for _, o := range b.outputs {
+		info, err := os.Stat(o.filepath)
+		if err != nil {
+			return fmt.Errorf("could not find size of APK '%v' due to error: %v", o.filepath, err)
+		}
+		r.APKs = append(r.APKs, apkSize{Split: o.split(), Size: info.Size()})
+	}
+
+	records, err := readSizes(b.args.outputDir, b.variant.name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var last *sizeRecord
+	if len(records) > 0 {
+		last = records[len(records)-1]
+	}
+	limit, err := parseSizeLimit(b.args.failOnSizeIncrease)
+	if err != nil {
+		return withCode(errInvalidFlags, err)
+	}
+	if limit != nil && last != nil {
+		grew := make([]string, 0)
+		for _, a := range r.APKs {
+			if before := last.size(a.Split); before > 0 && limit.exceeded(before, a.Size) {
+				grew = append(grew, fmt.Sprintf("%v APK grew by %v to %.2f MB", a.Split, sizeChange(before, a.Size), float64(a.Size)/(1<<20)))
+			}
+		}
+		if len(grew) > 0 {
+			return withCode(errSizeIncrease, fmt.Errorf("the APKs grew by more than -fail-on-size-increase %v since the %v build of %v:\n  %v", b.args.failOnSizeIncrease, b.variant.name, last.Start.Format("2006-01-02 15:04"), strings.Join(grew, "\n  ")))
+		}
+	}
+	if last != nil && last.sameSizes(r) {
+		return nil
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("could not encode APK sizes due to error: %v", err)
+	}
+	path := filepath.Join(b.args.outputDir, sizesFilepath)
+	if err := os.MkdirAll(filepath.Dir(path), 0774); err != nil {
+		return fmt.Errorf("could not create directory for APK size history due to error: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return fmt.Errorf("could not open APK size history file '%v' due to error: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s\n", line); err != nil {
+		return fmt.Errorf("could not write APK size history to '%v' due to error: %v", path, err)
+	}
+	return nil
+}
+
+// size returns the size of the split's APK, or 0 if the build had none.
+func (r *sizeRecord) size(split string) int64 {
+	for _, a := range r.APKs {
+		if a.Split == split {
+			return a.Size
+		}
+	}
+	return 0
+}
+
+func (r *sizeRecord) sameSizes(other *sizeRecord) bool {
+	if r.VersionCode != other.VersionCode || len(r.APKs) != len(other.APKs) {
+		return false
+	}
+	for _, a := range other.APKs {
+		if r.size(a.Split) != a.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// sizeChange describes growing, or shrinking, from before to after bytes.
+func sizeChange(before, after int64) string {
+	s := fmt.Sprintf("%+.1f KB", float64(after-before)/(1<<10))
+	if before > 0 {
+		s += fmt.Sprintf(" (%+.1f%%)", 100*float64(after-before)/float64(before))
+	}
+	return s
+}
+
+// readSizes returns the sizes recorded in the size history in outputDir,
+// oldest first, of the variant's builds or of every build if variant is
+// empty, skipping any lines that cannot be parsed.
+func readSizes(outputDir, variant string) ([]*sizeRecord, error) {
+	path := filepath.Join(outputDir, sizesFilepath)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := make([]*sizeRecord, 0)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		r := &sizeRecord{}
+		if err := json.Unmarshal(sc.Bytes(), r); err != nil {
+			continue
+		}
+		if variant == "" || r.Variant == variant {
+			records = append(records, r)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("could not read APK size history file '%v' due to error: %v", path, err)
+	}
+	return records, nil
+}
+
+// size prints the history of the sizes of the APKs built in an output dir,
+// with how much each changed since the previous build of its variant:
+//
+//	blade size history -variant release
+func size(arguments []string) {
+	if len(arguments) < 1 || arguments[0] != "history" {
+		fmt.Fprintf(os.Stderr, "Usage: blade size history [flags]\n")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("size history", flag.ExitOnError)
+	out := fs.String("out", "", outDesc)
+	variant := fs.String("variant", "", "The build variant to list the sizes of, in lieu of every variant")
+	n := fs.Int("n", 20, "The number of most recent changes in size to list")
+	fs.Parse(arguments[1:])
+
+	records, err := readSizes(*out, *variant)
+	switch {
+	case os.IsNotExist(err):
+		fmt.Println("no sizes have been recorded yet")
+		return
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	previous := make(map[string]*sizeRecord)
+	lines := make([]string, 0)
+	for _, r := range records {
+		for _, a := range r.APKs {
+			change := ""
+			if p := previous[r.Variant]; p != nil && p.size(a.Split) > 0 {
+				change = sizeChange(p.size(a.Split), a.Size)
+			}
+			lines = append(lines, strings.TrimSpace(fmt.Sprintf("%v  %-10v %-16v %-10v %8.2f MB  %v", r.Start.Format("2006-01-02 15:04"), r.Variant, fmt.Sprintf("%v (%v)", r.VersionName, r.VersionCode), a.Split, float64(a.Size)/(1<<20), change)))
+		}
+		previous[r.Variant] = r
+	}
+	if len(lines) == 0 {
+		fmt.Println("no sizes have been recorded yet")
+		return
+	}
+	if len(lines) > *n {
+		lines = lines[len(lines)-*n:]
+	}
+	fmt.Println(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// densities maps the screen densities that APKs can be split by to their
+// dots-per-inch, in ascending order of density.
+var densities = []struct {
+	name string
+	dpi  int
+}{
+	{"ldpi", 120},
+	{"mdpi", 160},
+	{"hdpi", 240},
+	{"xhdpi", 320},
+	{"xxhdpi", 480},
+	{"xxxhdpi", 640},
+}
+
+// apkOutput describes one APK packaged from the compiled app.
+type apkOutput struct {
+	filepath string
+	// density is the only screen density the APK includes resources for,
+	// or empty for a universal APK.
+	density string
+	// versionCode overrides the manifest's version code when non-zero.
+	versionCode int
+}
+
+// apkOutputs returns the APKs to package, named after name: a universal APK
+// and, if any densities are given, an APK per density for multi-APK
+// distribution.
+//
+// Each density APK gets a version code of the manifest's version code times
+// ten plus the density's position in ascending order of density, starting
+// at one, while the universal APK gets the manifest's version code times ten,
+// so that a store always prefers the APK specific to a device's density.
+func apkOutputs(name string, densitySplits []string, m *manifest) ([]apkOutput, error) {
+	universal := apkOutput{filepath: name + ".apk"}
+	if len(densitySplits) == 0 {
+		return []apkOutput{universal}, nil
+	}
+	base, err := m.versionCode()
+	if err != nil {
+		return nil, err
+	}
+	universal.versionCode = base * 10
+	outputs := []apkOutput{universal}
+	seen := make(map[string]bool)
+	for _, d := range densitySplits {
+		i := densityIndex(d)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown density '%v' to split by, expected one of %v", d, strings.Join(densityNames(), ", "))
+		}
+		if seen[d] {
+			return nil, fmt.Errorf("density '%v' to split by is given more than once", d)
+		}
+		seen[d] = true
+		outputs = append(outputs, apkOutput{
+			filepath:    fmt.Sprintf("%v-%v.apk", name, d),
+			density:     d,
+			versionCode: base*10 + i + 1,
+		})
+	}
+	return outputs, nil
+}
+
+func densityIndex(name string) int {
+	for i, d := range densities {
+		if d.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func densityNames() []string {
+	names := make([]string, len(densities))
+	for i, d := range densities {
+		names[i] = d.name
+	}
+	return names
+}
+
+// unalignedFilepath returns where the APK is packaged before being aligned.
+func (o apkOutput) unalignedFilepath() string {
+	return o.filepath + ".unaligned"
+}
+
+// unsignedFilepath returns where the APK is aligned before being signed.
+func (o apkOutput) unsignedFilepath() string {
+	return o.filepath + ".unsigned"
+}
+
+// idsigFilepath returns where APK Signature Scheme v4 signs the APK to.
+func (o apkOutput) idsigFilepath() string {
+	return o.filepath + ".idsig"
+}
+
+// manifestFilepath returns the manifest to package the APK with, which for
+// a density APK is a copy of src declaring the only density it supports.
+func (o apkOutput) manifestFilepath(src string) (string, error) {
+	if o.density == "" {
+		return src, nil
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return "", fmt.Errorf("could not read manifest at '%v' due to error: %v", src, err)
+	}
+	loc := applicationTag.FindStringIndex(string(b))
+	if loc == nil {
+		return "", fmt.Errorf("no application element found in manifest '%v'", src)
+	}
+	dpi := densities[densityIndex(o.density)].dpi
+	var screens strings.Builder
+	screens.WriteString("<compatible-screens>\n")
+	for _, size := range []string{"small", "normal", "large", "xlarge"} {
+		fmt.Fprintf(&screens, "        <screen android:screenSize=\"%v\" android:screenDensity=\"%v\" />\n", size, dpi)
+	}
+	screens.WriteString("    </compatible-screens>\n    ")
+	s := string(b[:loc[0]]) + screens.String() + string(b[loc[0]:])
+	dst := o.densityManifestFilepath()
+	if err := ioutil.WriteFile(dst, []byte(s), 0664); err != nil {
+		return "", fmt.Errorf("could not write manifest for density '%v' due to error: %v", o.density, err)
+	}
+	return dst, nil
+}
+
+// densityManifestFilepath returns where the manifest of a density APK is
+// written.
+func (o apkOutput) densityManifestFilepath() string {
+	return fmt.Sprintf("AndroidManifest.%v.xml", o.density)
+}
+
+// packageArgs returns the additional aapt package arguments for the APK.
+func (o apkOutput) packageArgs() []string {
+	args := make([]string, 0)
+	if o.density != "" {
+		// Bitmaps of other densities are stripped unless no resource of the
+		// preferred density exists to fall back on.
+		args = append(args, "--preferred-density", o.density)
+	}
+	if o.versionCode != 0 {
+		args = append(args, "--version-code", strconv.Itoa(o.versionCode))
+	}
+	return args
+}
+
+// outputName returns the name of the build's APKs, without the .apk
+// extension, as templated by output_name in config so that the APKs of
+// different builds can be told apart in artifact stores, or else "app".
+func (b *build) outputName() (string, error) {
+	if b.config.outputName == "" {
+		return defaultOutputName, nil
+	}
+	name, err := b.expand("output_name in config", strings.TrimSuffix(b.config.outputName, ".apk"), nil)
+	if err != nil {
+		return "", err
+	}
+	if name == "" || strings.ContainsAny(name, "/\\{}") {
+		return "", fmt.Errorf("output_name in config must template a file name, but gave '%v'", name)
+	}
+	return name, nil
+}
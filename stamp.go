@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const stampsDir = ".blade/stamps"
+
+// fingerprint returns a digest of the names and contents of files, plus any
+// extra strings (such as the command that consumes the files), which changes
+// whenever any of them change.
+func fingerprint(files []string, extra ...string) (string, error) {
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	h := sha256.New()
+	for _, s := range extra {
+		fmt.Fprintf(h, "%v\x00", s)
+	}
+	for _, s := range sorted {
+		fmt.Fprintf(h, "%v\x00", s)
+		f, err := os.Open(s)
+		if err != nil {
+			return "", fmt.Errorf("could not open '%v' to fingerprint due to error: %v", s, err)
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("could not read '%v' to fingerprint due to error: %v", s, err)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// expandInputs resolves paths and glob patterns to the files they name,
+// walking any directories recursively.
+func expandInputs(patterns []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input pattern '%v': %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files found matching input '%v'", pattern)
+		}
+		for _, m := range matches {
+			err := filepath.Walk(m, func(path string, info os.FileInfo, err error) error {
+				switch {
+				case err != nil:
+					return err
+				case !info.IsDir():
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not walk input '%v' due to error: %v", m, err)
+			}
+		}
+	}
+	return files, nil
+}
+
+// stampPath returns where the fingerprint of the named task is recorded.
+func stampPath(outputDir, name string) string {
+	return filepath.Join(outputDir, stampsDir, name)
+}
+
+// isUpToDate reports whether the stamp file records the fingerprint fp.
+func isUpToDate(stamp, fp string) bool {
+	b, err := ioutil.ReadFile(stamp)
+	return err == nil && strings.TrimSpace(string(b)) == fp
+}
+
+func writeStamp(stamp, fp string) error {
+	if err := os.MkdirAll(filepath.Dir(stamp), 0774); err != nil {
+		return fmt.Errorf("could not create stamp directory due to error: %v", err)
+	}
+	return ioutil.WriteFile(stamp, []byte(fp+"\n"), 0664)
+}
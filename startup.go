@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const startupProfileFilename = "startup-prof.txt"
+
+// startupProfileD8 is the first version of d8, as major and minor, that takes
+// --startup-profile.
+var startupProfileD8 = [2]int{8, 2}
+
+// d8Version matches the version d8 --version prints, such as "D8 8.2.47".
+var d8Version = regexp.MustCompile(`(?m)^D8 (\d+)\.(\d+)`)
+
+// startupProfile returns the startup profile, in ART's human-readable profile
+// format, that d8 lays out the primary dex by so that the classes run while
+// the app starts are contiguous, which makes cold starts faster. It is
+// startup_profile in config, such as one kept with the sources, or else
+// where `blade run -startup-profile` collects it to in the output directory.
+func (b *build) startupProfile() string {
+	if b.config.startupProfile != "" {
+		return b.config.startupProfile
+	}
+	return filepath.Join(b.args.outputDir, ".blade", startupProfileFilename)
+}
+
+// checkStartupProfileSupport returns an error if d8 is too old to take
+// --startup-profile. A d8 whose version cannot be told is given the benefit
+// of the doubt.
+func (t toolchain) checkStartupProfileSupport() error {
+	out, err := t.output(t.d8Bin, "--version")
+	if err != nil {
+		return nil
+	}
+	m := d8Version.FindStringSubmatch(string(out))
+	if m == nil {
+		return nil
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	if major > startupProfileD8[0] || major == startupProfileD8[0] && minor >= startupProfileD8[1] {
+		return nil
+	}
+	return fmt.Errorf("laying out the dex by a startup profile needs d8 %v.%v or later, but %v is %v.%v, so install a newer build-tools, declare a newer d8 with path under [tools.d8] in config, or remove the startup profile", startupProfileD8[0], startupProfileD8[1], t.d8Bin, major, minor)
+}
+
+// collectStartupProfile writes the classes and methods that pkg has run
+// since it was installed, which once it was just installed and started are
+// those of its startup, to path. It needs API 33 or later, and adb running as
+// root for the app's profile to be saved on demand, such as on emulators
+// without Google Play after `blade adb root`.
+func collectStartupProfile(d device, pkg, path string) error {
+	// ART saves profiles periodically, or when sent SIGUSR1.
+	if _, err := d.adb("shell", "killall", "-s", "SIGUSR1", pkg); err != nil {
+		return fmt.Errorf("could not have '%v' save its profile, which needs `blade adb root` on the device: %v", pkg, err)
+	}
+	time.Sleep(time.Second)
+	if _, err := d.adb("shell", "pm", "dump-profiles", "--dump-classes-and-methods", pkg); err != nil {
+		return fmt.Errorf("could not dump the profile of '%v', which needs API 33 or later: %v", pkg, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0774); err != nil {
+		return fmt.Errorf("could not create directory '%v' due to error: %v", filepath.Dir(path), err)
+	}
+	if _, err := d.adb("pull", "/data/misc/profman/"+pkg+"-primary.prof.txt", path); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const statsFilepath = ".blade/stats.jsonl"
+
+// buildStats records how long a build and each of its stages took, and which
+// stages were skipped as up to date. They are appended as a line of JSON to
+// a file in the output dir and never leave the machine.
+type buildStats struct {
+	mu      sync.Mutex
+	Start   time.Time    `json:"start"`
+	Variant string       `json:"variant"`
+	Seconds float64      `json:"seconds"`
+	Failed  bool         `json:"failed"`
+	Stages  []stageStats `json:"stages"`
+}
+
+type stageStats struct {
+	Name        string  `json:"name"`
+	Seconds     float64 `json:"seconds"`
+	Incremental bool    `json:"incremental"`
+	Skipped     bool    `json:"skipped"`
+}
+
+func newBuildStats(variant string) *buildStats {
+	return &buildStats{Start: time.Now(), Variant: variant, Stages: make([]stageStats, 0)}
+}
+
+// record notes that the stage finished, having started at start.
+func (b *buildStats) record(s *stage, start time.Time, skipped bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Stages = append(b.Stages, stageStats{
+		Name:        s.name,
+		Seconds:     time.Since(start).Seconds(),
+		Incremental: s.outputs != nil,
+		Skipped:     skipped,
+	})
+}
+
+// isFull reports whether every stage that could have been skipped was run.
+func (b *buildStats) isFull() bool {
+	for _, s := range b.Stages {
+		if s.Skipped {
+			return false
+		}
+	}
+	return true
+}
+
+// hits returns how many stages were skipped of those that could have been.
+func (b *buildStats) hits() (skipped, incremental int) {
+	for _, s := range b.Stages {
+		if s.Incremental {
+			incremental++
+		}
+		if s.Skipped {
+			skipped++
+		}
+	}
+	return skipped, incremental
+}
+
+// append records the finished build at the end of the stats file in
+// outputDir.
+func (b *buildStats) append(outputDir string, err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Seconds = time.Since(b.Start).Seconds()
+	b.Failed = err != nil
+	line, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("could not encode build statistics due to error: %v", err)
+	}
+	path := filepath.Join(outputDir, statsFilepath)
+	if err := os.MkdirAll(filepath.Dir(path), 0774); err != nil {
+		return fmt.Errorf("could not create directory for build statistics due to error: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return fmt.Errorf("could not open build statistics file '%v' due to error: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s\n", line); err != nil {
+		return fmt.Errorf("could not write build statistics to '%v' due to error: %v", path, err)
+	}
+	return nil
+}
+
+// readStats returns the last n builds recorded in the stats file in
+// outputDir, oldest first, skipping any lines that cannot be parsed.
+func readStats(outputDir string, n int) ([]*buildStats, error) {
+	path := filepath.Join(outputDir, statsFilepath)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open build statistics file '%v' due to error: %v", path, err)
+	}
+	defer f.Close()
+	builds := make([]*buildStats, 0)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		b := &buildStats{}
+		if err := json.Unmarshal(sc.Bytes(), b); err != nil {
+			continue
+		}
+		builds = append(builds, b)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("could not read build statistics file '%v' due to error: %v", path, err)
+	}
+	if len(builds) > n {
+		builds = builds[len(builds)-n:]
+	}
+	return builds, nil
+}
+
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	if len(s)%2 == 0 {
+		return (s[len(s)/2-1] + s[len(s)/2]) / 2
+	}
+	return s[len(s)/2]
+}
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// stats summarizes the builds recorded in an output dir: how long full and
+// incremental builds take, how often stages are skipped as up to date, which
+// stages take longest, and whether builds are getting faster or slower.
+func stats(arguments []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	out := fs.String("out", "", outDesc)
+	n := fs.Int("n", 20, "The number of most recent builds to summarize")
+	fs.Parse(arguments)
+
+	builds, err := readStats(*out, *n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if len(builds) == 0 {
+		fmt.Println("no builds have been recorded yet")
+		return
+	}
+
+	var full, incremental, all []float64
+	failed, skipped, skippable := 0, 0, 0
+	type stageTotal struct {
+		name    string
+		seconds []float64
+	}
+	stages := make(map[string]*stageTotal)
+	for _, b := range builds {
+		if b.Failed {
+			failed++
+			continue
+		}
+		all = append(all, b.Seconds)
+		if b.isFull() {
+			full = append(full, b.Seconds)
+		} else {
+			incremental = append(incremental, b.Seconds)
+		}
+		s, i := b.hits()
+		skipped += s
+		skippable += i
+		for _, s := range b.Stages {
+			if s.Skipped {
+				continue
+			}
+			if stages[s.Name] == nil {
+				stages[s.Name] = &stageTotal{name: s.Name}
+			}
+			stages[s.Name].seconds = append(stages[s.Name].seconds, s.Seconds)
+		}
+	}
+
+	fmt.Printf("last %v builds, from %v to %v, of which %v failed\n\n", len(builds),
+		builds[0].Start.Format("2006-01-02 15:04"), builds[len(builds)-1].Start.Format("2006-01-02 15:04"), failed)
+	fmt.Printf("%-12v %6v %8v %8v\n", "", "builds", "median", "mean")
+	fmt.Printf("%-12v %6v %7.1fs %7.1fs\n", "full", len(full), median(full), mean(full))
+	fmt.Printf("%-12v %6v %7.1fs %7.1fs\n", "incremental", len(incremental), median(incremental), mean(incremental))
+	if skippable > 0 {
+		fmt.Printf("\nstages skipped as up to date: %.0f%% (%v of %v)\n", 100*float64(skipped)/float64(skippable), skipped, skippable)
+	}
+
+	totals := make([]*stageTotal, 0, len(stages))
+	for _, t := range stages {
+		totals = append(totals, t)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return mean(totals[i].seconds)*float64(len(totals[i].seconds)) > mean(totals[j].seconds)*float64(len(totals[j].seconds))
+	})
+	if len(totals) > 5 {
+		totals = totals[:5]
+	}
+	if len(totals) > 0 {
+		fmt.Printf("\nstages taking the most time in total:\n")
+		for _, t := range totals {
+			fmt.Printf("  %-24v %7.1fs mean, ran in %v of %v builds\n", t.name, mean(t.seconds), len(t.seconds), len(all))
+		}
+	}
+
+	if half := len(all) / 2; half >= 2 {
+		before, after := median(all[:half]), median(all[len(all)-half:])
+		fmt.Printf("\nmedian of the latest %v builds is %.1fs, against %.1fs for the %v before\n", half, after, before, half)
+	}
+}
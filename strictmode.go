@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	outputDirForStrictModeSources = "generated_strict_mode_sources"
+	strictModeInitializer         = "blade.debug.StrictModeInitializer"
+)
+
+// strictModePenalties are the values of strict_mode, by the StrictMode
+// penalty each applies to violations.
+var strictModePenalties = map[string]string{
+	"log":   "penaltyLog()",
+	"death": "penaltyLog().penaltyDeath()",
+}
+
+// strictModeSource is a ContentProvider that enables StrictMode, which the
+// system creates as the app starts, before Application.onCreate, so that
+// the app's own sources need not do so.
+const strictModeSource = `package blade.debug;
+
+import android.content.ContentProvider;
+import android.content.ContentValues;
+import android.database.Cursor;
+import android.net.Uri;
+import android.os.StrictMode;
+
+/** Generated by blade to enable StrictMode in builds of variants with strict_mode set. */
+public final class StrictModeInitializer extends ContentProvider {
+    @Override
+    public boolean onCreate() {
+        StrictMode.setThreadPolicy(new StrictMode.ThreadPolicy.Builder().detectAll().%[1]v.build());
+        StrictMode.setVmPolicy(new StrictMode.VmPolicy.Builder().detectAll().%[1]v.build());
+        return true;
+    }
+
+    @Override
+    public Cursor query(Uri uri, String[] projection, String selection, String[] selectionArgs, String sortOrder) {
+        return null;
+    }
+
+    @Override
+    public String getType(Uri uri) {
+        return null;
+    }
+
+    @Override
+    public Uri insert(Uri uri, ContentValues values) {
+        return null;
+    }
+
+    @Override
+    public int delete(Uri uri, String selection, String[] selectionArgs) {
+        return 0;
+    }
+
+    @Override
+    public int update(Uri uri, ContentValues values, String selection, String[] selectionArgs) {
+        return 0;
+    }
+}
+`
+
+// writeStrictModeSource writes the StrictMode initializer to dir, with the
+// penalty named by strictMode.
+func writeStrictModeSource(dir, strictMode string) error {
+	p := filepath.Join(dir, filepath.FromSlash(strings.Replace(strictModeInitializer, ".", "/", -1))+".java")
+	if err := os.MkdirAll(filepath.Dir(p), 0774); err != nil {
+		return fmt.Errorf("could not create directory for StrictMode initializer due to error: %v", err)
+	}
+	src := fmt.Sprintf(strictModeSource, strictModePenalties[strictMode])
+	if err := ioutil.WriteFile(p, []byte(src), 0664); err != nil {
+		return fmt.Errorf("could not write StrictMode initializer to '%v' due to error: %v", p, err)
+	}
+	return nil
+}
+
+var applicationEnd = regexp.MustCompile(`</application\s*>`)
+
+// addStrictModeProvider declares the StrictMode initializer as a provider of
+// the manifest's application, under an authority unique to the app.
+func addStrictModeProvider(manifest, applicationID string) (string, error) {
+	provider := fmt.Sprintf(`    <provider android:name="%v" android:authorities="%v.blade-strict-mode" android:exported="false" />
+    `, strictModeInitializer, applicationID)
+	if loc := applicationEnd.FindStringIndex(manifest); loc != nil {
+		return manifest[:loc[0]] + provider + manifest[loc[0]:], nil
+	}
+	// An application element without children is closed where it starts.
+	loc := applicationTag.FindStringIndex(manifest)
+	if loc == nil || !strings.HasSuffix(manifest[loc[0]:loc[1]], "/>") {
+		return "", fmt.Errorf("no application element found in manifest to add the StrictMode initializer to")
+	}
+	tag := strings.TrimSuffix(manifest[loc[0]:loc[1]], "/>")
+	return manifest[:loc[0]] + tag + ">\n    " + provider + "</application>" + manifest[loc[1]:], nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lastFailureFilepath is where the output of the stage that last failed is
+// kept, under the output directory, for `blade support-bundle`.
+const lastFailureFilepath = ".blade/last-failure.log"
+
+var lastFailureMu sync.Mutex
+
+// writeLastFailure records what the stage printed and how it failed.
+func writeLastFailure(outputDir, name string, output []byte, err error) error {
+	lastFailureMu.Lock()
+	defer lastFailureMu.Unlock()
+	p := filepath.Join(outputDir, lastFailureFilepath)
+	if err := os.MkdirAll(filepath.Dir(p), 0774); err != nil {
+		return err
+	}
+	s := fmt.Sprintf("stage %v failed at %v\n\n%v\n%v\n", name, time.Now().Format(time.RFC3339), strings.TrimSpace(string(output)), err)
+	return ioutil.WriteFile(p, []byte(s), 0664)
+}
+
+// secretKey matches the keys of config whose values may be credentials,
+// along with headers, which commonly carry them.
+var secretKey = regexp.MustCompile(`(?i)^(\s*"?([\w.-]*(token|secret|password|passwd|credential|auth)[\w.-]*|[\w.-]*key|headers)"?\s*=\s*)(.*)$`)
+
+// urlQuery matches the query of a URL, which presigned URLs keep their
+// signatures in.
+var urlQuery = regexp.MustCompile(`(https?://[^\s"'?]*)\?[^\s"']*`)
+
+// redactConfig returns the config with the values of keys that may hold
+// credentials, and the queries of URLs, redacted.
+func redactConfig(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if m := secretKey.FindStringSubmatch(l); m != nil && strings.TrimSpace(m[4]) != "" {
+			lines[i] = m[1] + `"<redacted>"`
+		}
+	}
+	return urlQuery.ReplaceAllString(strings.Join(lines, "\n"), "$1?<redacted>")
+}
+
+// sanitize returns s with the user's home directory abbreviated, as it
+// commonly has their name in it, and with the values of environment
+// variables that may hold credentials redacted.
+func sanitize(s string) string {
+	for _, e := range os.Environ() {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv[1]) >= 4 && secretKey.MatchString(kv[0]+"=") {
+			s = strings.Replace(s, kv[1], "<redacted>", -1)
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil && home != "/" {
+		s = strings.Replace(s, home, "~", -1)
+	}
+	return s
+}
+
+// environmentReport describes blade, the host and the toolchain the build
+// described by b would run with, along with whether it passes preflight.
+func environmentReport(b *build) string {
+	var w bytes.Buffer
+	version := "(devel)"
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		version = bi.Main.Version
+	}
+	fmt.Fprintf(&w, "blade %v built with %v on %v/%v\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&w, "args: %v\n\n", strings.Join(b.args.arguments, " "))
+	for _, v := range append(append([]string(nil), buildEnvironmentVariables...), javaOptionsVariables...) {
+		if value, ok := os.LookupEnv(v); ok {
+			fmt.Fprintf(&w, "%v=%v\n", v, value)
+		}
+	}
+
+	fmt.Fprintf(&w, "\nSDK at %v:\n", b.args.androidHome)
+	for _, dir := range []string{"build-tools", "platforms"} {
+		names, err := ioutil.ReadDir(filepath.Join(b.args.androidHome, dir))
+		if err != nil {
+			fmt.Fprintf(&w, "  %v: %v\n", dir, err)
+			continue
+		}
+		installed := make([]string, len(names))
+		for i, n := range names {
+			installed[i] = n.Name()
+		}
+		fmt.Fprintf(&w, "  %v: %v\n", dir, strings.Join(installed, ", "))
+	}
+
+	fmt.Fprintf(&w, "\ntools on PATH:\n")
+	for _, tool := range append(b.requiredTools(), "adb") {
+		p, err := exec.LookPath(tool)
+		if err != nil {
+			p = "not found"
+		}
+		fmt.Fprintf(&w, "  %v: %v\n", tool, p)
+	}
+	for _, command := range [][]string{{toolPath(b.config.tools, "javac", "javac"), "-version"}, {"java", "-version"}} {
+		out, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&w, "\n$ %v\n%v\n", strings.Join(command, " "), strings.TrimSpace(string(out)))
+	}
+
+	fmt.Fprintf(&w, "\npreflight: ")
+	if err := b.preflight(); err != nil {
+		fmt.Fprintf(&w, "%v\n", err)
+	} else {
+		fmt.Fprintf(&w, "ok\n")
+	}
+	return w.String()
+}
+
+// supportBundle writes a zip to attach to bug reports, of the environment
+// the build described by the build flags runs in, its config with anything
+// that may be a credential redacted, its build info and recent stats, and
+// the output of the stage that last failed, all with the user's home
+// directory abbreviated.
+func supportBundle(arguments []string) {
+	fs := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+	args := &buildArgs{}
+	args.register(fs)
+	out := fs.String("o", "blade-support.zip", "The location to write the zip to")
+	args.parse(fs, arguments)
+	if err := args.validate(); err != nil {
+		exitWithError(err)
+	}
+	b, err := newBuild(args)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	type entry struct{ name, content string }
+	entries := []entry{{"environment.txt", environmentReport(b)}}
+	if c, err := ioutil.ReadFile(b.config.path); err == nil {
+		entries = append(entries, entry{filepath.Base(b.config.path), redactConfig(string(c))})
+	}
+	for _, name := range []string{buildInfoFilepath, lastFailureFilepath, statsFilepath} {
+		c, err := ioutil.ReadFile(b.outputPath(name))
+		if err != nil {
+			continue
+		}
+		if name == statsFilepath {
+			// Only recent builds are of interest.
+			lines := strings.SplitAfter(strings.TrimSpace(string(c)), "\n")
+			if len(lines) > 20 {
+				lines = lines[len(lines)-20:]
+			}
+			c = []byte(strings.Join(lines, ""))
+		}
+		entries = append(entries, entry{filepath.Base(name), string(c)})
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		exitWithError(fmt.Errorf("could not create '%v' due to error: %v", *out, err))
+	}
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		h.SetModTime(time.Now())
+		zw, err := w.CreateHeader(h)
+		if err == nil {
+			_, err = zw.Write([]byte(sanitize(e.content)))
+		}
+		if err != nil {
+			exitWithError(fmt.Errorf("could not write '%v' to '%v' due to error: %v", e.name, *out, err))
+		}
+	}
+	if err := w.Close(); err != nil {
+		exitWithError(fmt.Errorf("could not write '%v' due to error: %v", *out, err))
+	}
+	if err := f.Close(); err != nil {
+		exitWithError(fmt.Errorf("could not write '%v' due to error: %v", *out, err))
+	}
+	fmt.Printf("wrote %v with what looks like credentials redacted, but check it before attaching it to a public issue\n", *out)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// coreForSystemModulesFilename is the jar of the platform's java.*
+	// classes, which platforms from android-30 on ship beside android.jar.
+	coreForSystemModulesFilename = "core-for-system-modules.jar"
+	systemImagesDir              = ".blade/system-images"
+)
+
+// javaReleaseVersion returns the feature version of a Java release given
+// as, say, 8, 1.8 or 17.
+func javaReleaseVersion(release string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimPrefix(release, "1."))
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("Java release '%v' must be a version such as 8, 11 or 17", release)
+	}
+	return v, nil
+}
+
+// javacPlatformArgs returns the arguments that make javac compile the Java
+// release against the platform's java.* APIs rather than the running JDK's,
+// so that APIs Android lacks, such as String.isBlank on older platforms,
+// fail to compile rather than crash at runtime. Releases up to 8 take
+// android.jar as their bootclasspath, while later releases, which have
+// modules and reject -bootclasspath, take a system image built from the
+// platform's core-for-system-modules.jar, as the Android Gradle plugin does,
+// along with android.jar on the classpath for the android.* APIs.
+func (t toolchain) javacPlatformArgs(javaRelease string) (args, classpath []string, err error) {
+	v, err := javaReleaseVersion(javaRelease)
+	if err != nil {
+		return nil, nil, err
+	}
+	args = []string{"-source", javaRelease, "-target", javaRelease}
+	if v <= 8 {
+		return append(args, "-bootclasspath", t.androidLib), nil, nil
+	}
+	image, err := t.systemImage()
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(args, "--system", image), []string{t.androidLib}, nil
+}
+
+// systemImage returns the system image of the platform's java.base module,
+// building it the first time, under a directory named for the digest of
+// core-for-system-modules.jar. In a container, whose SDK the platform is
+// of, the jar is first copied out of it into the output directory, which it
+// mounts.
+func (t toolchain) systemImage() (string, error) {
+	if err := os.MkdirAll(systemImagesDir, 0774); err != nil {
+		return "", fmt.Errorf("could not create directory for system images due to error: %v", err)
+	}
+	jar := filepath.Join(t.platform, coreForSystemModulesFilename)
+	if t.container != nil {
+		copied := absPath(filepath.Join(systemImagesDir, coreForSystemModulesFilename))
+		if err := t.run("cp", jar, copied); err != nil {
+			return "", fmt.Errorf("could not copy '%v', which Java releases after 8 are compiled against, out of the container, so install platforms;android-30 or later in its image, or compile with -java-release 8, due to error: %v", jar, err)
+		}
+		jar = copied
+	}
+	sum, err := sha256File(jar)
+	if err != nil {
+		return "", fmt.Errorf("could not read '%v', which Java releases after 8 are compiled against, so install platforms;android-30 or later, or compile with -java-release 8, due to error: %v", jar, err)
+	}
+	image := absPath(filepath.Join(systemImagesDir, sum[:16]))
+	if exist(filepath.Join(image, "lib", "modules")) {
+		return image, nil
+	}
+	tmp, err := ioutil.TempDir(systemImagesDir, "tmp")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary directory due to error: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp = absPath(tmp)
+
+	packages, err := classPackages(jar)
+	if err != nil {
+		return "", err
+	}
+	moduleInfo := filepath.Join(tmp, "module-info.java")
+	s := "module java.base {\n"
+	for _, p := range packages {
+		s += "    exports " + p + ";\n"
+	}
+	if err := ioutil.WriteFile(moduleInfo, []byte(s+"}\n"), 0664); err != nil {
+		return "", fmt.Errorf("could not write '%v' due to error: %v", moduleInfo, err)
+	}
+	classes := filepath.Join(tmp, "classes")
+	if err := t.run(t.bin("javac"), "--system=none", "--patch-module=java.base="+jar, "-d", classes, moduleInfo); err != nil {
+		return "", fmt.Errorf("could not compile module-info of java.base due to error: %v", err)
+	}
+	module := filepath.Join(tmp, "java.base.jar")
+	if err := addToJar(jar, module, filepath.Join(classes, "module-info.class")); err != nil {
+		return "", err
+	}
+	jmod := filepath.Join(tmp, "java.base.jmod")
+	if err := t.run(t.jdkBin("jmod"), "create", "--target-platform", "LINUX-X86_64", "--class-path", module, jmod); err != nil {
+		return "", fmt.Errorf("could not create module of java.base due to error: %v", err)
+	}
+	out := filepath.Join(tmp, "image")
+	if err := t.run(t.jdkBin("jlink"), "--module-path", jmod, "--add-modules", "java.base", "--output", out, "--disable-plugin", "system-modules"); err != nil {
+		return "", fmt.Errorf("could not link system image of java.base due to error: %v", err)
+	}
+	// javac reads the image through the file system of the running JDK,
+	// which jlink leaves out of images of a java.base of its own.
+	if err := t.copyJDKFile("lib/jrt-fs.jar", filepath.Join(out, "lib", "jrt-fs.jar")); err != nil {
+		return "", err
+	}
+	if err := os.Rename(out, image); err != nil && !exist(filepath.Join(image, "lib", "modules")) {
+		return "", fmt.Errorf("could not move system image to '%v' due to error: %v", image, err)
+	}
+	return image, nil
+}
+
+// classPackages returns the packages of the classes in the jar.
+func classPackages(jar string) ([]string, error) {
+	zr, err := zip.OpenReader(jar)
+	if err != nil {
+		return nil, fmt.Errorf("could not open '%v' due to error: %v", jar, err)
+	}
+	defer zr.Close()
+	seen := make(map[string]bool)
+	for _, f := range zr.File {
+		dir := path.Dir(f.Name)
+		if strings.HasSuffix(f.Name, ".class") && dir != "." && !strings.HasPrefix(dir, "META-INF") {
+			seen[strings.Replace(dir, "/", ".", -1)] = true
+		}
+	}
+	packages := make([]string, 0, len(seen))
+	for p := range seen {
+		packages = append(packages, p)
+	}
+	sort.Strings(packages)
+	return packages, nil
+}
+
+// addToJar writes the entries of the jar at in, and the file at extra at its
+// root, to a jar at out.
+func addToJar(in, out, extra string) error {
+	zr, err := zip.OpenReader(in)
+	if err != nil {
+		return fmt.Errorf("could not open '%v' due to error: %v", in, err)
+	}
+	defer zr.Close()
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("could not create '%v' due to error: %v", out, err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, e := range zr.File {
+		h := e.FileHeader
+		zw, err := w.CreateHeader(&h)
+		if err != nil {
+			return fmt.Errorf("could not copy '%v' to '%v' due to error: %v", e.Name, out, err)
+		}
+		r, err := e.Open()
+		if err == nil {
+			_, err = io.Copy(zw, r)
+			r.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("could not copy '%v' to '%v' due to error: %v", e.Name, out, err)
+		}
+	}
+	b, err := ioutil.ReadFile(extra)
+	if err != nil {
+		return fmt.Errorf("could not read '%v' due to error: %v", extra, err)
+	}
+	zw, err := w.Create(filepath.Base(extra))
+	if err == nil {
+		_, err = zw.Write(b)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("could not write '%v' due to error: %v", out, err)
+	}
+	return nil
+}
+
+// jdkBin returns the path of a tool of the JDK that javac is from, which is
+// beside javac, or else on PATH.
+func (t toolchain) jdkBin(name string) string {
+	javac := t.bin("javac")
+	if strings.ContainsRune(javac, filepath.Separator) {
+		return filepath.Join(filepath.Dir(javac), name)
+	}
+	return name
+}
+
+// copyJDKFile copies the file at rel in the home of the JDK that javac is
+// from to dst, which in a container is that of the JDK inside it.
+func (t toolchain) copyJDKFile(rel, dst string) error {
+	if t.container != nil {
+		script := `cp "$(dirname "$(dirname "$(readlink -f "$(command -v "$1")")")")/$2" "$3"`
+		if err := t.run("sh", "-c", script, "sh", t.bin("javac"), rel, dst); err != nil {
+			return fmt.Errorf("could not copy %v of the JDK of javac out of the container due to error: %v", rel, err)
+		}
+		return nil
+	}
+	javac, err := exec.LookPath(t.bin("javac"))
+	if err == nil {
+		javac, err = filepath.EvalSymlinks(javac)
+	}
+	if err != nil {
+		return fmt.Errorf("could not locate the JDK of javac due to error: %v", err)
+	}
+	return copyTree(filepath.Join(filepath.Dir(filepath.Dir(javac)), filepath.FromSlash(rel)), dst, nil)
+}
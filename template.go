@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The names and paths that config and flags declare for a build's artifacts,
+// namely output_name, the path of [archive] entries, -report, and the url or
+// command of each [[publish]], are templated alike, so that a naming policy
+// is declared with the same placeholders wherever it applies:
+//
+//	{application_id}  the application ID built, with any suffix
+//	{variant}         the variant built
+//	{version_code}    the manifest's version code
+//	{version_name}    the manifest's version name
+//	{date}            when the build started, such as 20240501-153000
+//	{git_sha}         the short SHA of the commit checked out
+//
+// Publishers also have {file} and {path}, the name and absolute path of the
+// artifact being published.
+var templatePlaceholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// templateDateFormat formats {date} so that names sort by it.
+const templateDateFormat = "20060102-150405"
+
+// templateVars returns the values of the placeholders of every template but
+// {git_sha}, which is only looked up when used.
+func (b *build) templateVars() (map[string]string, error) {
+	versionCode, err := b.manifest.versionCode()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"application_id": b.applicationID,
+		"variant":        b.variant.name,
+		"version_code":   strconv.Itoa(versionCode),
+		"version_name":   b.manifest.VersionName,
+		"date":           b.started.Format(templateDateFormat),
+	}, nil
+}
+
+// expand replaces the placeholders in s, the template declared as what, with
+// their values, along with those of extra, failing on any placeholder that
+// has no value, such as a misspelt one.
+func (b *build) expand(what, s string, extra map[string]string) (string, error) {
+	vars, err := b.templateVars()
+	if err != nil {
+		return "", err
+	}
+	for k, v := range extra {
+		vars[k] = v
+	}
+	for _, m := range templatePlaceholder.FindAllStringSubmatch(s, -1) {
+		if _, ok := vars[m[1]]; ok {
+			continue
+		}
+		if m[1] != "git_sha" {
+			known := make([]string, 0, len(vars)+1)
+			for k := range vars {
+				known = append(known, "{"+k+"}")
+			}
+			known = append(known, "{git_sha}")
+			sort.Strings(known)
+			return "", fmt.Errorf("%v has the unknown placeholder {%v}, where it may have %v", what, m[1], strings.Join(known, ", "))
+		}
+		out, err := gitIn(b.sourceDir, "rev-parse", "--short", "HEAD")
+		if err != nil {
+			return "", fmt.Errorf("could not expand {git_sha} in %v due to error: %v", what, err)
+		}
+		vars["git_sha"] = strings.TrimSpace(out)
+	}
+	return templatePlaceholder.ReplaceAllStringFunc(s, func(p string) string {
+		return vars[p[1:len(p)-1]]
+	}), nil
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML that blade.toml files are expected to
+// use: comments, [tables], [[arrays.of.tables]], dotted and quoted keys,
+// basic and literal strings, integers, floats, booleans, (multi-line) arrays
+// and inline tables. Dates and multi-line strings are not supported.
+//
+// Tables are returned as map[string]interface{}, arrays as []interface{},
+// and scalars as string, int64, float64 or bool.
+func parseTOML(data string) (map[string]interface{}, error) {
+	p := &tomlParser{src: data, line: 1}
+	root := make(map[string]interface{})
+	current := root
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(p.rest(), "[["):
+			p.pos += 2
+			var keys []string
+			if keys, err = p.key(); err != nil {
+				return nil, err
+			}
+			if err = p.expect("]]"); err != nil {
+				return nil, err
+			}
+			if current, err = appendTableAt(root, keys); err != nil {
+				return nil, p.errorf("%v", err)
+			}
+		case p.peek() == '[':
+			p.pos++
+			var keys []string
+			if keys, err = p.key(); err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			if current, err = tableAt(root, keys); err != nil {
+				return nil, p.errorf("%v", err)
+			}
+		default:
+			if err = p.keyValue(current); err != nil {
+				return nil, err
+			}
+		}
+		if err = p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("line %v: %v", p.line, fmt.Sprintf(format, a...))
+}
+
+func (p *tomlParser) eof() bool    { return p.pos >= len(p.src) }
+func (p *tomlParser) rest() string { return p.src[p.pos:] }
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipSpace skips spaces and tabs on the current line.
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.line++
+			p.pos++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) expect(s string) error {
+	p.skipSpace()
+	if !strings.HasPrefix(p.rest(), s) {
+		return p.errorf("expected '%v'", s)
+	}
+	p.pos += len(s)
+	return nil
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	if !p.eof() && p.peek() != '\n' {
+		return p.errorf("unexpected '%c' after value", p.peek())
+	}
+	return nil
+}
+
+// key parses a possibly dotted key such as a."b.c".d into its parts.
+func (p *tomlParser) key() ([]string, error) {
+	keys := make([]string, 0)
+	for {
+		p.skipSpace()
+		var k string
+		var err error
+		switch c := p.peek(); {
+		case c == '"':
+			k, err = p.basicString()
+		case c == '\'':
+			k, err = p.literalString()
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected a key")
+			}
+			k = p.src[start:p.pos]
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		p.skipSpace()
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) keyValue(t map[string]interface{}) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := tableAt(t, keys[:len(keys)-1])
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	k := keys[len(keys)-1]
+	if _, exists := parent[k]; exists {
+		return p.errorf("key '%v' is defined more than once", strings.Join(keys, "."))
+	}
+	parent[k] = v
+	return nil
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '"':
+		return p.basicString()
+	case c == '\'':
+		return p.literalString()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case strings.HasPrefix(p.rest(), "true"):
+		p.pos += len("true")
+		return true, nil
+	case strings.HasPrefix(p.rest(), "false"):
+		p.pos += len("false")
+		return false, nil
+	default:
+		start := p.pos
+		for !p.eof() && strings.IndexByte("+-0123456789._xoabcdefABCDEF", p.peek()) >= 0 {
+			p.pos++
+		}
+		s := strings.Replace(p.src[start:p.pos], "_", "", -1)
+		if s == "" {
+			return nil, p.errorf("expected a value")
+		}
+		return p.number(s)
+	}
+}
+
+// number parses s as an integer, which may be hexadecimal, octal or binary
+// with a 0x, 0o or 0b prefix, or else as a float. Unlike strconv, it rejects
+// the leading zeros that TOML does, rather than taking 021 as octal.
+func (p *tomlParser) number(s string) (interface{}, error) {
+	digits := s
+	if s[0] == '+' || s[0] == '-' {
+		digits = s[1:]
+	}
+	if len(digits) > 2 && digits[0] == '0' && strings.IndexByte("xob", digits[1]) >= 0 {
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[digits[1]]
+		if i, err := strconv.ParseUint(digits[2:], base, 63); err == nil && digits == s {
+			return int64(i), nil
+		}
+		return nil, p.errorf("invalid value '%v'", s)
+	}
+	if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+		return nil, p.errorf("invalid value '%v', as numbers cannot have leading zeros", s)
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("invalid value '%v'", s)
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			e := p.peek()
+			p.pos++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.src) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid unicode escape: %v", err)
+				}
+				b.WriteRune(rune(r))
+				p.pos += n
+			default:
+				return "", p.errorf("invalid escape '\\%c'", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) literalString() (string, error) {
+	p.pos++
+	i := strings.IndexAny(p.rest(), "'\n")
+	if i < 0 || p.src[p.pos+i] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+i]
+	p.pos += i + 1
+	return s, nil
+}
+
+func (p *tomlParser) array() ([]interface{}, error) {
+	p.pos++
+	a := make([]interface{}, 0)
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.pos++
+			return a, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable() (map[string]interface{}, error) {
+	p.pos++
+	t := make(map[string]interface{})
+	for {
+		p.skipSpace()
+		if p.peek() == '}' {
+			p.pos++
+			return t, nil
+		}
+		if err := p.keyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
+
+// tableAt returns the table found by following keys from t, creating any
+// tables that do not exist yet. A key naming an array of tables resolves to
+// the last table in that array.
+func tableAt(t map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for i, k := range keys {
+		switch v := t[k].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			t[k] = next
+			t = next
+		case map[string]interface{}:
+			t = v
+		case []interface{}:
+			last, ok := lastTable(v)
+			if !ok {
+				return nil, fmt.Errorf("key '%v' is not a table", strings.Join(keys[:i+1], "."))
+			}
+			t = last
+		default:
+			return nil, fmt.Errorf("key '%v' is not a table", strings.Join(keys[:i+1], "."))
+		}
+	}
+	return t, nil
+}
+
+// appendTableAt appends a new table to the array of tables found by
+// following keys from root, and returns the new table.
+func appendTableAt(root map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	parent, err := tableAt(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	k := keys[len(keys)-1]
+	next := make(map[string]interface{})
+	switch v := parent[k].(type) {
+	case nil:
+		parent[k] = []interface{}{next}
+	case []interface{}:
+		parent[k] = append(v, next)
+	default:
+		return nil, fmt.Errorf("key '%v' is not an array of tables", strings.Join(keys, "."))
+	}
+	return next, nil
+}
+
+func lastTable(a []interface{}) (map[string]interface{}, bool) {
+	if len(a) == 0 {
+		return nil, false
+	}
+	t, ok := a[len(a)-1].(map[string]interface{})
+	return t, ok
+}
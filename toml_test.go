@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]interface{}
+	}{
+		{"empty", "", map[string]interface{}{}},
+		{"comments", "# a comment\na = 1 # another\n\n", map[string]interface{}{"a": int64(1)}},
+		{"bare keys", "a-b_c = true\n1 = false\n", map[string]interface{}{"a-b_c": true, "1": false}},
+		{"quoted keys", `"a.b" = 1` + "\n'c d' = 2\n", map[string]interface{}{"a.b": int64(1), "c d": int64(2)}},
+		{"dotted keys", `a.b."c.d" = "x"` + "\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": map[string]interface{}{"c.d": "x"}},
+		}},
+		{"tables", "[a]\nx = 1\n[a.b]\ny = 2\n[c]\n", map[string]interface{}{
+			"a": map[string]interface{}{"x": int64(1), "b": map[string]interface{}{"y": int64(2)}},
+			"c": map[string]interface{}{},
+		}},
+		{"arrays of tables", "[[g]]\nname = \"a\"\n[[g]]\nname = \"b\"\n[g.opts]\nx = 1\n", map[string]interface{}{
+			"g": []interface{}{
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "b", "opts": map[string]interface{}{"x": int64(1)}},
+			},
+		}},
+		{"inline tables", "t = { a = 1, b.c = 'x', d = {} }\n", map[string]interface{}{
+			"t": map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": "x"}, "d": map[string]interface{}{}},
+		}},
+		{"arrays", "a = [\n  1,\n  2, # two\n]\nb = [[\"x\"], []]\n", map[string]interface{}{
+			"a": []interface{}{int64(1), int64(2)},
+			"b": []interface{}{[]interface{}{"x"}, []interface{}{}},
+		}},
+		{"escapes", `s = "a\tb\nc\"d\\e\u00e9\U0001F600"` + "\n", map[string]interface{}{"s": "a\tb\nc\"d\\eé😀"}},
+		{"literal strings", `s = 'C:\path\n'` + "\n", map[string]interface{}{"s": `C:\path\n`}},
+		{"integers", "a = 0\nb = -17\nc = +1_000\nd = 0x1f\ne = 0o17\nf = 0b101\n", map[string]interface{}{
+			"a": int64(0), "b": int64(-17), "c": int64(1000), "d": int64(31), "e": int64(15), "f": int64(5),
+		}},
+		{"floats", "a = 0.5\nb = -1e3\nc = 6.25E-1\n", map[string]interface{}{"a": 0.5, "b": -1000.0, "c": 0.625}},
+		{"crlf", "a = 1\r\nb = 2\r\n", map[string]interface{}{"a": int64(1), "b": int64(2)}},
+	}
+	for _, tt := range tests {
+		got, err := parseTOML(tt.src)
+		if err != nil {
+			t.Errorf("%v: parseTOML(%q) returned error: %v", tt.name, tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: parseTOML(%q) = %#v, want %#v", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseTOMLInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// err is part of the error expected.
+		err string
+	}{
+		{"leading zero", "a = 021\n", "line 1: invalid value '021'"},
+		{"signed leading zero", "a = -01\n", "leading zeros"},
+		{"float leading zero", "a = 01.5\n", "leading zeros"},
+		{"signed prefixed integer", "a = -0x1\n", "invalid value"},
+		{"invalid number", "a = 1.2.3\n", "invalid value"},
+		{"missing value", "a =\n", "expected a value"},
+		{"missing equals", "a 1\n", "expected '='"},
+		{"missing key", "= 1\n", "expected a key"},
+		{"duplicate key", "a = 1\na = 2\n", "line 2: key 'a' is defined more than once"},
+		{"duplicate inline key", "t = { a = 1, a = 2 }\n", "defined more than once"},
+		{"text after value", "a = 1 b\n", "unexpected 'b' after value"},
+		{"unterminated string", "a = \"x\n", "unterminated string"},
+		{"unterminated literal string", "a = 'x\n", "unterminated string"},
+		{"invalid escape", `a = "\q"` + "\n", `invalid escape '\q'`},
+		{"invalid unicode escape", `a = "\u12"` + "\n", "invalid unicode escape"},
+		{"unterminated array", "a = [1 2]\n", "expected ',' or ']'"},
+		{"unterminated inline table", "t = { a = 1\n", "expected ',' or '}'"},
+		{"unterminated table header", "[a\n", "expected ']'"},
+		{"table over value", "a = 1\n[a]\n", "key 'a' is not a table"},
+		{"array of tables over table", "[a]\n[[a]]\n", "key 'a' is not an array of tables"},
+	}
+	for _, tt := range tests {
+		_, err := parseTOML(tt.src)
+		if err == nil {
+			t.Errorf("%v: parseTOML(%q) returned no error", tt.name, tt.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: parseTOML(%q) returned error %q, want one containing %q", tt.name, tt.src, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// javaOptionsVariables are the environment variables the JVM reads options
+// from, which are ignored by tools when config sets isolate_java_options.
+var javaOptionsVariables = []string{"_JAVA_OPTIONS", "JAVA_TOOL_OPTIONS", "JDK_JAVA_OPTIONS"}
+
+// tool holds the settings of an external tool declared in blade.toml as:
+//
+//	[tools]
+//	isolate_java_options = true
+//
+//	[tools.javac]
+//	jvm_options = ["-Xmx4g"]
+//	options = ["-Xlint:deprecation"]
+//
+//	[tools.d8]
+//	path = "/opt/r8/bin/d8"
+//
+// jvm_options are passed to the JVM running the tool, in the form the tool
+// expects them (e.g. -J-Xmx4g for javac and -JXmx4g for d8), while options
+// are passed to the tool itself. With isolate_java_options set, options in
+// the environment such as _JAVA_OPTIONS do not reach any tool. path runs
+// another build of the tool, such as a patched or newer standalone one, in
+// lieu of that in build-tools or on PATH, resolved against the config
+// file's directory.
+type tool struct {
+	path       string
+	jvmOptions []string
+	options    []string
+}
+
+// toolNames are the tools that config may declare settings of, which are
+// those blade runs.
+var toolNames = []string{"aapt", "adb", "apksigner", "d8", "javac", "protoc", "zipalign", "zopfli", "zopflipng"}
+
+// jvmTools are the tools that run on a JVM, by name, with the function
+// that turns a JVM option into the argument the tool passes on to its JVM.
+var jvmTools = map[string]func(option string) string{
+	"javac":     func(o string) string { return "-J" + o },
+	"apksigner": func(o string) string { return "-J" + o },
+	// The d8 wrapper script prepends the dash to options following -J.
+	"d8": func(o string) string { return "-J" + strings.TrimPrefix(o, "-") },
+}
+
+func newTools(c *config, t map[string]interface{}) (map[string]tool, bool, error) {
+	tools := make(map[string]tool)
+	isolate := false
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "isolate_java_options" {
+			var err error
+			if isolate, err = boolValue(t, name); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		known := false
+		for _, n := range toolNames {
+			known = known || n == name
+		}
+		if !known {
+			return nil, false, fmt.Errorf("unknown tool '%v' in config, expected one of: %v", name, strings.Join(toolNames, ", "))
+		}
+		tt, err := table(t, name)
+		if err != nil {
+			return nil, false, err
+		}
+		wrap := func(err error) error {
+			return fmt.Errorf("invalid settings for tool '%v': %v", name, err)
+		}
+		var x tool
+		if x.path, err = stringValue(tt, "path"); err != nil {
+			return nil, false, wrap(err)
+		}
+		if x.path != "" {
+			x.path = c.resolve(x.path)
+		}
+		if x.jvmOptions, err = stringList(tt, "jvm_options"); err != nil {
+			return nil, false, wrap(err)
+		}
+		if x.options, err = stringList(tt, "options"); err != nil {
+			return nil, false, wrap(err)
+		}
+		if _, ok := jvmTools[name]; !ok && len(x.jvmOptions)+len(x.options) > 0 {
+			return nil, false, wrap(fmt.Errorf("only path may be set, as options may only be set for %v", strings.Join(jvmToolNames(), ", ")))
+		}
+		tools[name] = x
+	}
+	return tools, isolate, nil
+}
+
+func jvmToolNames() []string {
+	names := make([]string, 0, len(jvmTools))
+	for name := range jvmTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// toolPath returns the path of the named tool that tools override, if any,
+// or else fallback.
+func toolPath(tools map[string]tool, name, fallback string) string {
+	if x, ok := tools[name]; ok && x.path != "" {
+		return x.path
+	}
+	return fallback
+}
+
+// useTools sets the configured tools that the toolchain runs, and the
+// arguments it runs them with.
+func (t *toolchain) useTools(tools map[string]tool, isolateJavaOptions bool) {
+	t.tools = tools
+	t.isolateJavaOptions = isolateJavaOptions
+	t.aaptBin = toolPath(tools, "aapt", t.aaptBin)
+	t.d8Bin = toolPath(tools, "d8", t.d8Bin)
+}
+
+// bin returns the path of the named tool that is not run from build-tools
+// but from PATH, unless tools override it.
+func (t toolchain) bin(name string) string {
+	return toolPath(t.tools, name, name)
+}
+
+// apksignerBin returns the path of apksigner.
+func (t toolchain) apksignerBin() string {
+	return toolPath(t.tools, "apksigner", t.buildTools+"/apksigner")
+}
+
+// zipalignBin returns the path of zipalign.
+func (t toolchain) zipalignBin() string {
+	return toolPath(t.tools, "zipalign", t.buildTools+"/zipalign")
+}
+
+// toolArgs returns the arguments configured for the named tool, to precede
+// those blade passes it.
+func (t toolchain) toolArgs(name string) []string {
+	x, ok := t.tools[name]
+	if !ok {
+		return nil
+	}
+	args := make([]string, 0, len(x.jvmOptions)+len(x.options))
+	for _, o := range x.jvmOptions {
+		args = append(args, jvmTools[name](o))
+	}
+	return append(args, x.options...)
+}
+
+// environ returns the environment to run tools with.
+func (t toolchain) environ() []string {
+	env := os.Environ()
+	if !t.isolateJavaOptions {
+		return env
+	}
+	isolated := make([]string, 0, len(env))
+	for _, e := range env {
+		name := strings.SplitN(e, "=", 2)[0]
+		keep := true
+		for _, v := range javaOptionsVariables {
+			keep = keep && name != v
+		}
+		if keep {
+			isolated = append(isolated, e)
+		}
+	}
+	return isolated
+}
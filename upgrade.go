@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// upgradeTest tests that the app survives being upgraded from an older
+// version, as users' installs are, by installing the older APK given, running
+// the app and any setup script to create data for it, then installing the APK
+// of the build described by the build flags over it and launching that. It
+// fails if the app crashes within a while of the launch, which is when data
+// and schema migrations run, printing the crash from logcat.
+func upgradeTest(arguments []string) {
+	fs := flag.NewFlagSet("upgrade-test", flag.ExitOnError)
+	args := &buildArgs{}
+	args.register(fs)
+	serial := fs.String("device", "", deviceDesc)
+	setup := fs.String("setup", "", "A script to run once the old version is launched, such as one driving it with `adb shell input`, to create the data to migrate; it is run with $ANDROID_SERIAL and $BLADE_PACKAGE set")
+	grant := fs.Bool("grant-permissions", false, grantDesc)
+	wait := fs.Duration("wait", 5*time.Second, "How long to watch for crashes after launching the new version")
+	color := registerColor(fs)
+	args.parse(fs, arguments)
+	applyColor(fs, *color)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: blade upgrade-test [flags] <old.apk>\n")
+		os.Exit(2)
+	}
+	old := fs.Arg(0)
+
+	b, err := newBuild(args)
+	if err != nil {
+		exitWithError(err)
+	}
+	pkg := b.applicationID
+	g, err := readBadging(old)
+	if err != nil {
+		exitWithError(err)
+	}
+	if g.Package != pkg {
+		exitWithError(fmt.Errorf("'%v' is of package '%v' but the build is of '%v', so would not upgrade it", old, g.Package, pkg))
+	}
+	oldVersion, err := badgingVersionCode(g)
+	if err != nil {
+		exitWithError(err)
+	}
+	newVersion, err := b.manifest.versionCode()
+	if err != nil {
+		exitWithError(withCode(errInvalidManifest, err))
+	}
+	apk := b.outputPath(b.outputs[0].filepath)
+	if _, err := os.Stat(apk); err != nil {
+		exitWithError(fmt.Errorf("no APK was found at '%v', so build it first by running blade with the same build flags", apk))
+	}
+	d, err := newDevice(args.androidHome, *serial)
+	if err != nil {
+		exitWithError(err)
+	}
+	for _, p := range []string{old, apk} {
+		if err := d.checkCompatible(p); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	// The old version is installed afresh, so that it creates its data as
+	// it would on a new user's device.
+	d.adb("uninstall", pkg)
+	fmt.Printf("installing old version %v (%v)\n", g.VersionName, oldVersion)
+	if err := d.run("install", old); err != nil {
+		exitWithStatus(err)
+	}
+	if *grant {
+		if err := grantPermissions(d, pkg, old); err != nil {
+			exitWithError(err)
+		}
+	}
+	component, err := launchableComponent(old)
+	if err != nil {
+		exitWithError(err)
+	}
+	if _, err := d.adb("shell", "am", "start", "-W", "-n", component); err != nil {
+		exitWithError(err)
+	}
+	if *setup != "" {
+		cmd := exec.Command(*setup)
+		cmd.Env = append(os.Environ(), "BLADE_PACKAGE="+pkg)
+		if d.serial != "" {
+			cmd.Env = append(cmd.Env, "ANDROID_SERIAL="+d.serial)
+		}
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			exitWithError(fmt.Errorf("error when running setup script %v : %v", *setup, err))
+		}
+	}
+
+	fmt.Printf("upgrading to %v (%v)\n", b.manifest.VersionName, newVersion)
+	if _, err := d.adb("shell", "am", "force-stop", pkg); err != nil {
+		exitWithError(err)
+	}
+	if err := d.run("install", "-r", apk); err != nil {
+		exitWithStatus(err)
+	}
+	if *grant {
+		if err := grantPermissions(d, pkg, apk); err != nil {
+			exitWithError(err)
+		}
+	}
+	if _, err := d.adb("logcat", "-b", "crash", "-c"); err != nil {
+		exitWithError(err)
+	}
+	// The new version may start from another activity.
+	if b.manifest.LaunchableActivity != "" {
+		component = pkg + "/" + b.manifest.LaunchableActivity
+	}
+	if _, err := d.adb("shell", "am", "start", "-W", "-n", component); err != nil {
+		exitWithError(err)
+	}
+	time.Sleep(*wait)
+
+	out, err := d.adb("logcat", "-b", "crash", "-d")
+	if err != nil {
+		exitWithError(err)
+	}
+	// The runtime logs crashes as "Process: <package>, PID: <pid>".
+	if strings.Contains(out, "Process: "+pkg+",") {
+		fmt.Fprintf(os.Stderr, "%v\n", red(strings.TrimSpace(out)))
+		fmt.Fprintf(os.Stderr, "%v crashed after upgrading from %v (%v)\n", pkg, g.VersionName, oldVersion)
+		os.Exit(1)
+	}
+	fmt.Printf("%v upgraded from %v (%v) without crashing\n", pkg, g.VersionName, oldVersion)
+}
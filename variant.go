@@ -0,0 +1,432 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	outputDirForVariantResources = "generated_variant_resources"
+	processedManifestFilepath    = "AndroidManifest.variant.xml"
+	variantLabelResourceName     = "blade_variant_label"
+)
+
+// variant holds the settings of a build variant, declared in blade.toml as:
+//
+//	[variant.debug]
+//	application_id_suffix = ".debug"
+//	label = "My App (debug)"
+//	icon = "@mipmap/ic_launcher_debug"
+//
+//	crunch_pngs = false
+//	strict_mode = "log"
+//	debug_info = "full"
+//	sign_command = ["sign-apk", "--in", "{in}", "--out", "{out}"]
+//	java_overlays = ["src/debug/java"]
+//	res_overlays = ["src/debug/res"]
+//
+//	[variant.debug.placeholders]
+//	hostName = "staging.example.com"
+//
+//	[variant.debug.res_values.string]
+//	api_base_url = "https://staging.example.com/api"
+//
+//	[variant.debug.build_config]
+//	FEATURE_X = true
+//	ENDPOINT = "https://staging.example.com"
+//
+// Placeholders replace ${name} in the manifest, as does ${applicationId}.
+//
+// A variant with a build_config table gets a BuildConfig class generated in
+// the manifest's package, with the fields that AGP generates as well as those
+// of the table, typed after their values.
+//
+// Resource values are generated as resources of the type of their table,
+// which is one of bool, color, dimen, integer or string, so that the variant
+// can define resources such as R.string.api_base_url without an overlay.
+//
+// PNGs are crunched by aapt unless crunch_pngs is false, which makes builds
+// faster, or zopfli_pngs is true, in which case they are instead recompressed
+// losslessly with zopflipng, which makes them smaller but builds much slower.
+//
+// With strict_mode set to "log" or "death", StrictMode is enabled as the app
+// starts, with that penalty for violations, by a provider that blade adds.
+//
+// APKs are signed with the debug key unless sign_command is set, in which case
+// the command is run to sign the aligned APK at {in} to {out} in its stead.
+//
+// debug_info is either "full", which keeps the local variables that debuggers
+// show, or "lines", which keeps only the source files and line numbers that
+// stack traces show, and lets d8 optimize the dex. Release builds default to
+// lines and all others to full.
+type variant struct {
+	name                string
+	applicationIDSuffix string
+	label               string
+	icon                string
+	placeholders        map[string]string
+	resValues           []resValue
+	// buildConfigFields are nil unless BuildConfig is generated.
+	buildConfigFields []buildConfigField
+	noCrunch          bool
+	zopfliPNGs        bool
+	strictMode        string
+	debugInfo         string
+	signCommand       []string
+	// javaOverlays and resOverlays are merged on top of the main Java
+	// sources and resources, in lieu of the conventional overlays.
+	javaOverlays []string
+	resOverlays  []string
+}
+
+// variant returns the settings of the named variant. The debug and release
+// variants always exist, while any others must be declared in the config.
+func (c *config) variant(name string) (variant, error) {
+	if v, ok := c.variants[name]; ok {
+		return v, nil
+	}
+	switch name {
+	case "debug", "release":
+		return variant{name: name, debugInfo: defaultDebugInfo(name)}, nil
+	}
+	return variant{}, withCode(errUnknownVariant, fmt.Errorf("no variant named '%v' is declared in config as [variant.%v]", name, name))
+}
+
+func newVariant(name string, t map[string]interface{}) (variant, error) {
+	v := variant{name: name}
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid variant '%v': %v", name, err)
+	}
+	var err error
+	if v.applicationIDSuffix, err = stringValue(t, "application_id_suffix"); err != nil {
+		return v, wrap(err)
+	}
+	if v.label, err = stringValue(t, "label"); err != nil {
+		return v, wrap(err)
+	}
+	if v.icon, err = stringValue(t, "icon"); err != nil {
+		return v, wrap(err)
+	}
+	if v.placeholders, err = stringMap(t, "placeholders"); err != nil {
+		return v, wrap(err)
+	}
+	if v.resValues, err = newResValues(t); err != nil {
+		return v, wrap(err)
+	}
+	if v.buildConfigFields, err = newBuildConfigFields(t); err != nil {
+		return v, wrap(err)
+	}
+	crunch := true
+	if _, ok := t["crunch_pngs"]; ok {
+		if crunch, err = boolValue(t, "crunch_pngs"); err != nil {
+			return v, wrap(err)
+		}
+	}
+	if v.zopfliPNGs, err = boolValue(t, "zopfli_pngs"); err != nil {
+		return v, wrap(err)
+	}
+	if v.strictMode, err = stringValue(t, "strict_mode"); err != nil {
+		return v, wrap(err)
+	}
+	if _, ok := strictModePenalties[v.strictMode]; v.strictMode != "" && !ok {
+		return v, wrap(fmt.Errorf("strict_mode must be log or death, not '%v'", v.strictMode))
+	}
+	if v.debugInfo, err = stringValue(t, "debug_info"); err != nil {
+		return v, wrap(err)
+	}
+	switch v.debugInfo {
+	case "":
+		v.debugInfo = defaultDebugInfo(name)
+	case "full", "lines":
+	default:
+		return v, wrap(fmt.Errorf("debug_info must be full or lines, not '%v'", v.debugInfo))
+	}
+	if v.signCommand, err = stringList(t, "sign_command"); err != nil {
+		return v, wrap(err)
+	}
+	if _, ok := t["sign_command"]; ok && len(v.signCommand) == 0 {
+		return v, wrap(fmt.Errorf("sign_command must not be empty"))
+	}
+	if v.javaOverlays, err = stringList(t, "java_overlays"); err != nil {
+		return v, wrap(err)
+	}
+	if v.resOverlays, err = stringList(t, "res_overlays"); err != nil {
+		return v, wrap(err)
+	}
+	// Crunching zopfli's output would undo the recompression.
+	v.noCrunch = !crunch || v.zopfliPNGs
+	return v, nil
+}
+
+// resValue is a resource generated for a variant.
+type resValue struct {
+	typ   string
+	name  string
+	value string
+}
+
+func newResValues(t map[string]interface{}) ([]resValue, error) {
+	tt, err := table(t, "res_values")
+	if err != nil {
+		return nil, err
+	}
+	values := make([]resValue, 0)
+	for typ := range tt {
+		switch typ {
+		case "bool", "color", "dimen", "integer", "string":
+		default:
+			return nil, fmt.Errorf("res_values can only be of types bool, color, dimen, integer and string, not '%v'", typ)
+		}
+		names, err := table(tt, typ)
+		if err != nil {
+			return nil, err
+		}
+		for name, val := range names {
+			r := resValue{typ: typ, name: name}
+			switch v := val.(type) {
+			case bool:
+				if typ != "bool" {
+					return nil, fmt.Errorf("res_values.%v.%v must not be a boolean", typ, name)
+				}
+				r.value = fmt.Sprint(v)
+			case int64:
+				if typ != "integer" {
+					return nil, fmt.Errorf("res_values.%v.%v must not be an integer", typ, name)
+				}
+				r.value = fmt.Sprint(v)
+			case string:
+				switch typ {
+				case "string":
+					r.value = escapeStringResource(v)
+				case "color", "dimen":
+					r.value = v
+				default:
+					return nil, fmt.Errorf("res_values.%v.%v must not be a string", typ, name)
+				}
+			default:
+				return nil, fmt.Errorf("res_values.%v.%v must be a boolean, integer or string but was '%v'", typ, name, val)
+			}
+			values = append(values, r)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].typ != values[j].typ {
+			return values[i].typ < values[j].typ
+		}
+		return values[i].name < values[j].name
+	})
+	return values, nil
+}
+
+// hasResources reports whether resources are generated for the variant.
+func (v variant) hasResources() bool {
+	return v.label != "" || len(v.resValues) > 0
+}
+
+func defaultDebugInfo(variant string) string {
+	if variant == "release" {
+		return "lines"
+	}
+	return "full"
+}
+
+// javacArgs returns the arguments that make javac emit the variant's debug
+// info, of which javac emits only source files and line numbers by default.
+func (v variant) javacArgs() []string {
+	if v.debugInfo == "full" {
+		return []string{"-g"}
+	}
+	return []string{"-g:source,lines"}
+}
+
+// d8Mode returns the d8 flag of the variant's debug info, as d8 keeps local
+// variables and skips optimizations in its debug mode.
+func (v variant) d8Mode() string {
+	if v.debugInfo == "full" {
+		return "--debug"
+	}
+	return "--release"
+}
+
+// overlays returns the directories of Java sources and of resources that are
+// merged on top of the main ones in javaDir and resDir for the variant. Unless
+// configured as java_overlays and res_overlays, which must exist, these are
+// the directories beside the main ones suffixed with the variant's name, such
+// as java-debug and xml-debug, if they exist.
+func (v variant) overlays(c *config, javaDir, resDir string) ([]string, []string, error) {
+	dirs := func(configured []string, key, mainDir string) ([]string, error) {
+		if configured == nil {
+			conventional := filepath.Clean(mainDir) + "-" + v.name
+			if fi, err := os.Stat(conventional); err == nil && fi.IsDir() {
+				return []string{conventional}, nil
+			}
+			return nil, nil
+		}
+		dd := make([]string, len(configured))
+		for i, d := range configured {
+			dd[i] = c.resolve(d)
+			if fi, err := os.Stat(dd[i]); err != nil || !fi.IsDir() {
+				return nil, fmt.Errorf("no directory found at '%v' as given in %v of [variant.%v]", dd[i], key, v.name)
+			}
+		}
+		return dd, nil
+	}
+	java, err := dirs(v.javaOverlays, "java_overlays", javaDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := dirs(v.resOverlays, "res_overlays", resDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return java, res, nil
+}
+
+var placeholder = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// processManifest writes a copy of the manifest at src to dst with
+// placeholders substituted, the application's label and icon overridden
+// as configured for the variant, and the version and SDK levels set as
+// configured for the app. An overridden label is defined as a string
+// resource in an overlay resource directory at resDir, so that it is
+// compiled like any other app label, along with the variant's resource
+// values.
+func (v variant) processManifest(src, dst, resDir, applicationID string, app appConfig) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("could not read manifest at '%v' due to error: %v", src, err)
+	}
+	s := string(b)
+
+	values := map[string]string{"applicationId": applicationID}
+	for k, val := range v.placeholders {
+		values[k] = val
+	}
+	unresolved := make(map[string]bool)
+	s = placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		k := placeholder.FindStringSubmatch(m)[1]
+		if val, ok := values[k]; ok {
+			return val
+		}
+		unresolved[k] = true
+		return m
+	})
+	if len(unresolved) > 0 {
+		names := make([]string, 0, len(unresolved))
+		for k := range unresolved {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return withCode(errUnresolvedManifest, fmt.Errorf("no value for manifest placeholders %v in [variant.%v.placeholders]", strings.Join(names, ", "), v.name))
+	}
+
+	if v.label != "" {
+		s, err = setApplicationAttribute(s, "android:label", "@string/"+variantLabelResourceName)
+		if err != nil {
+			return err
+		}
+	}
+	if v.hasResources() {
+		if err := v.writeResources(resDir); err != nil {
+			return err
+		}
+	}
+	if v.icon != "" {
+		if s, err = setApplicationAttribute(s, "android:icon", v.icon); err != nil {
+			return err
+		}
+	}
+	if s, err = app.apply(s); err != nil {
+		return err
+	}
+	if v.strictMode != "" {
+		if s, err = addStrictModeProvider(s, applicationID); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(dst, []byte(s), 0664); err != nil {
+		return fmt.Errorf("could not write processed manifest to '%v' due to error: %v", dst, err)
+	}
+	return nil
+}
+
+var applicationTag = elementTag("application")
+
+// setApplicationAttribute sets the attribute on the manifest's application
+// element, replacing any existing value.
+func setApplicationAttribute(manifest, attr, value string) (string, error) {
+	return setElementAttribute(manifest, "application", attr, value)
+}
+
+// elementTag returns a pattern matching the start tag of the named element.
+func elementTag(element string) *regexp.Regexp {
+	return regexp.MustCompile(`<` + regexp.QuoteMeta(element) + `(\s[^>]*)?/?>`)
+}
+
+// attribute returns a pattern matching the attribute and its value, along
+// with the whitespace before it.
+func attribute(attr string) *regexp.Regexp {
+	return regexp.MustCompile(`\s+` + regexp.QuoteMeta(attr) + `\s*=\s*("[^"]*"|'[^']*')`)
+}
+
+// setElementAttribute sets the attribute on the first of the manifest's
+// elements of the given name, replacing any existing value.
+func setElementAttribute(manifest, element, attr, value string) (string, error) {
+	loc := elementTag(element).FindStringIndex(manifest)
+	if loc == nil {
+		return "", fmt.Errorf("no %v element found in manifest to set %v on", element, attr)
+	}
+	tag := manifest[loc[0]:loc[1]]
+	existing := attribute(attr)
+	if existing.MatchString(tag) {
+		tag = existing.ReplaceAllLiteralString(tag, fmt.Sprintf(` %v="%v"`, attr, value))
+	} else {
+		tag = strings.Replace(tag, "<"+element, fmt.Sprintf(`<%v %v="%v"`, element, attr, value), 1)
+	}
+	return manifest[:loc[0]] + tag + manifest[loc[1]:], nil
+}
+
+// writeResources writes the variant's label and resource values as resources
+// in resDir.
+func (v variant) writeResources(resDir string) error {
+	dir := filepath.Join(resDir, "values")
+	if err := os.MkdirAll(dir, 0774); err != nil {
+		return fmt.Errorf("could not create variant resources directory due to error: %v", err)
+	}
+	var b strings.Builder
+	b.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<resources>\n")
+	if v.label != "" {
+		fmt.Fprintf(&b, "    <string name=\"%v\">%v</string>\n", variantLabelResourceName, escapeStringResource(v.label))
+	}
+	for _, r := range v.resValues {
+		fmt.Fprintf(&b, "    <%v name=\"%v\">%v</%v>\n", r.typ, r.name, r.value, r.typ)
+	}
+	b.WriteString("</resources>\n")
+	p := filepath.Join(dir, "blade_variant.xml")
+	if err := ioutil.WriteFile(p, []byte(b.String()), 0664); err != nil {
+		return fmt.Errorf("could not write variant resources to '%v' due to error: %v", p, err)
+	}
+	return nil
+}
+
+var stringResourceEscapes = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`'`, `\'`,
+	`"`, `\"`,
+	`@`, `\@`,
+	`?`, `\?`,
+)
+
+// escapeStringResource escapes s for use as the text of an Android string
+// resource, where XML's special characters as well as apostrophes, quotes
+// and leading @ or ? are significant to aapt.
+func escapeStringResource(s string) string {
+	return stringResourceEscapes.Replace(s)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+const defaultPolicyFilepath = "blade-policy.toml"
+
+// policy holds the assertions that a release APK must satisfy, which are
+// declared in a policy file such as:
+//
+//	forbidden_permissions = ["android.permission.READ_SMS"]
+//	debuggable = false
+//	abis = ["arm64-v8a"]
+//	version_code_increases = true
+type policy struct {
+	forbiddenPermissions []string
+	// debuggable is nil if the policy does not assert it either way.
+	debuggable *bool
+	// abis are the only ABIs whose native libraries may be packaged, or nil
+	// if any may be.
+	abis []string
+	// versionCodeIncreases asserts that the version code is greater than
+	// that of the previous release.
+	versionCodeIncreases bool
+}
+
+func loadPolicy(path string) (*policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read policy file at '%v' due to error: %v", path, err)
+	}
+	t, err := parseTOML(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse policy file at '%v' due to error: %v", path, err)
+	}
+	p := &policy{}
+	if p.forbiddenPermissions, err = stringList(t, "forbidden_permissions"); err != nil {
+		return nil, err
+	}
+	if _, ok := t["debuggable"]; ok {
+		debuggable, err := boolValue(t, "debuggable")
+		if err != nil {
+			return nil, err
+		}
+		p.debuggable = &debuggable
+	}
+	if _, ok := t["abis"]; ok {
+		if p.abis, err = stringList(t, "abis"); err != nil {
+			return nil, err
+		}
+	}
+	if p.versionCodeIncreases, err = boolValue(t, "version_code_increases"); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// violations returns a description of each assertion of the policy that
+// the APK with badging g violates, given the badging of the previous
+// release if there is one.
+func (p *policy) violations(g, previous *badging) ([]string, error) {
+	vv := make([]string, 0)
+	requested := make(map[string]bool)
+	for _, perm := range g.Permissions {
+		requested[perm] = true
+	}
+	for _, perm := range p.forbiddenPermissions {
+		if requested[perm] {
+			vv = append(vv, fmt.Sprintf("requests forbidden permission %v", perm))
+		}
+	}
+	if p.debuggable != nil && g.Debuggable != *p.debuggable {
+		vv = append(vv, fmt.Sprintf("is debuggable=%v but must be debuggable=%v", g.Debuggable, *p.debuggable))
+	}
+	if p.abis != nil {
+		allowed := make(map[string]bool)
+		for _, abi := range p.abis {
+			allowed[abi] = true
+		}
+		for _, abi := range g.NativeCode {
+			if !allowed[abi] {
+				vv = append(vv, fmt.Sprintf("packages native libraries for ABI %v, which is not among %v", abi, p.abis))
+			}
+		}
+	}
+	if p.versionCodeIncreases {
+		if previous == nil {
+			return nil, withCode(errInvalidFlags, fmt.Errorf("the policy asserts that the version code increases, which requires the previous release to be given with -previous"))
+		}
+		current, err := badgingVersionCode(g)
+		if err != nil {
+			return nil, err
+		}
+		last, err := badgingVersionCode(previous)
+		if err != nil {
+			return nil, err
+		}
+		if current <= last {
+			vv = append(vv, fmt.Sprintf("has version code %v, which is not greater than %v of the previous release", current, last))
+		}
+	}
+	return vv, nil
+}
+
+// badgingVersionCode returns the APK's version code, which defaults to 1
+// as it does on devices when none is declared.
+func badgingVersionCode(g *badging) (int, error) {
+	if g.VersionCode == "" {
+		return 1, nil
+	}
+	i, err := strconv.Atoi(g.VersionCode)
+	if err != nil {
+		return 0, fmt.Errorf("version code '%v' of '%v' is not an integer", g.VersionCode, g.Package)
+	}
+	return i, nil
+}
+
+// verify checks an APK against the assertions of a policy file, failing if
+// it violates any, so that CI can keep releases that do from shipping.
+func verify(arguments []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	policyPath := fs.String("policy", defaultPolicyFilepath, "The policy file declaring the assertions that the APK must satisfy")
+	previousPath := fs.String("previous", "", "The APK of the previous release, for the version_code_increases assertion")
+	color := registerColor(fs)
+	fs.Parse(arguments)
+	applyColor(fs, *color)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: blade verify [flags] <app.apk>\n")
+		os.Exit(2)
+	}
+	apk := fs.Arg(0)
+	p, err := loadPolicy(*policyPath)
+	if err != nil {
+		exitWithError(withCode(errInvalidConfig, err))
+	}
+	g, err := readBadging(apk)
+	if err != nil {
+		exitWithError(err)
+	}
+	var previous *badging
+	if *previousPath != "" {
+		if previous, err = readBadging(*previousPath); err != nil {
+			exitWithError(err)
+		}
+	}
+	vv, err := p.violations(g, previous)
+	if err != nil {
+		exitWithError(err)
+	}
+	if len(vv) > 0 {
+		for _, v := range vv {
+			fmt.Fprintf(os.Stderr, "%v %v\n", apk, v)
+		}
+		exitWithError(withCode(errPolicy, fmt.Errorf("'%v' violates %v assertions of policy '%v'", apk, len(vv), *policyPath)))
+	}
+	fmt.Printf("%v satisfies policy '%v'\n", apk, *policyPath)
+}
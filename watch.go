@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// watch builds the app described by the build flags whenever what it builds
+// from changes, as found by polling the fingerprint of its inputs. With -run,
+// each successful build is reinstalled and relaunched on the device with
+// `blade run`, and with -scrcpy the device's screen is mirrored with scrcpy
+// meanwhile, which together make a development loop close to hot reloading.
+func watch(arguments []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	args := &buildArgs{}
+	args.register(fs)
+	run := fs.Bool("run", false, "Reinstall and relaunch the app on the device after each successful build")
+	serial := fs.String("device", "", deviceDesc)
+	mirror := fs.Bool("scrcpy", false, "Mirror the device's screen with scrcpy, which must be installed, while watching")
+	interval := fs.Duration("interval", time.Second, "How often to check whether what the app is built from has changed")
+	// -color is passed on to the builds and runs as well.
+	color := registerColor(fs)
+	own := map[string]bool{"run": true, "device": true, "scrcpy": true, "interval": true}
+	args.parse(fs, arguments)
+	applyColor(fs, *color)
+
+	exe, err := os.Executable()
+	if err != nil {
+		exitWithError(fmt.Errorf("could not locate blade executable due to error: %v", err))
+	}
+	flags := make([]string, 0)
+	fs.Visit(func(f *flag.Flag) {
+		if !own[f.Name] {
+			flags = append(flags, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	blade := func(arguments ...string) error {
+		cmd := exec.Command(exe, arguments...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+
+	if *mirror {
+		cmd := exec.Command("scrcpy")
+		if *serial != "" {
+			cmd = exec.Command("scrcpy", "--serial", *serial)
+		}
+		if err := cmd.Start(); err != nil {
+			exitWithError(fmt.Errorf("could not run scrcpy, which is installed as described at https://github.com/Genymobile/scrcpy, due to error: %v", err))
+		}
+		defer cmd.Process.Kill()
+	}
+
+	last, built := "", false
+	for {
+		// The build is described anew each time, so that files added since
+		// and changes to the config are picked up.
+		fp := ""
+		b, err := newBuild(args)
+		if err == nil {
+			fp, err = b.inputsFingerprint()
+		}
+		state := fp
+		if err != nil {
+			state = err.Error()
+		}
+		// A failed build is retried once its inputs change, not before.
+		if state == last {
+			time.Sleep(*interval)
+			continue
+		}
+		last = state
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", red(err.Error()))
+			continue
+		}
+		if built {
+			fmt.Fprintf(os.Stderr, "%v\n", yellow("rebuilding as the app's sources changed"))
+		}
+		built = true
+		err = blade(flags...)
+		if err == nil && *run {
+			runArgs := append([]string{"run"}, flags...)
+			if *serial != "" {
+				runArgs = append(runArgs, "-device", *serial)
+			}
+			err = blade(runArgs...)
+		}
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "%v\n", green("watching for changes"))
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// logcatLine matches a line of logcat's brief format, such as
+// "E/AndroidRuntime( 4321): FATAL EXCEPTION: main".
+var logcatLine = regexp.MustCompile(`^[VDIWEF]/(.+?)\(\s*(\d+)\): (.*)$`)
+
+// logcatPriority matches the priority of a line of logcat in either the brief
+// format or the threadtime format that logcat prints in by default, such as
+// "10-15 09:35:30.123  4321  4321 E AndroidRuntime: FATAL EXCEPTION: main".
+var logcatPriority = regexp.MustCompile(`^([VDIWEF])/|^\S+ \S+\s+\d+\s+\d+ ([VDIWEF]) `)
+
+// logcatColors are the colors of the lines of logcat by priority, leaving
+// those of the verbose, debug and info priorities uncolored.
+var logcatColors = map[string]string{"W": "33", "E": "31", "F": "1;31"}
+
+// logcatWriter writes the lines of logcat written to it to w, each colored
+// by its priority.
+type logcatWriter struct {
+	w    io.Writer
+	line []byte
+}
+
+func (l *logcatWriter) Write(p []byte) (int, error) {
+	l.line = append(l.line, p...)
+	for {
+		i := bytes.IndexByte(l.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(l.line[:i])
+		if m := logcatPriority.FindStringSubmatch(line); m != nil {
+			if code, ok := logcatColors[m[1]+m[2]]; ok {
+				line = escape(code, line)
+			}
+		}
+		if _, err := fmt.Fprintln(l.w, line); err != nil {
+			return 0, err
+		}
+		l.line = l.line[i+1:]
+	}
+}
+
+// logcatEntry is a message logged along with those that continue it, such as
+// the lines of a stack trace.
+type logcatEntry struct {
+	tag   string
+	pid   string
+	lines []string
+}
+
+// isFailureOf reports whether the entry is a crash or ANR of pkg, as logged by
+// the runtime or, for native crashes, debuggerd in the crash buffer, and by
+// the activity manager in the system buffer respectively.
+func (e *logcatEntry) isFailureOf(pkg string) bool {
+	switch {
+	case e.tag == "DEBUG":
+		for _, l := range e.lines {
+			if strings.Contains(l, ">>> "+pkg+" <<<") {
+				return true
+			}
+		}
+	case e.tag == "AndroidRuntime" && strings.HasPrefix(e.lines[0], "FATAL EXCEPTION"):
+		for _, l := range e.lines {
+			if strings.HasPrefix(l, "Process: "+pkg+",") {
+				return true
+			}
+		}
+	case e.tag == "ActivityManager":
+		return strings.HasPrefix(e.lines[0], "ANR in "+pkg+" ") || e.lines[0] == "ANR in "+pkg
+	}
+	return false
+}
+
+// watchForFailures follows logcat on the device for crashes and ANRs of pkg,
+// sending each to failures as the lines logged about it, until the returned
+// command is killed. Those logged before it started are found too, as far
+// back as logcat was last cleared.
+func watchForFailures(d device, pkg string, failures chan<- []string) (*exec.Cmd, error) {
+	cmd := exec.Command(d.adbPath(), d.adbArgs([]string{"logcat", "-b", "crash,system", "-v", "brief"})...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not run adb logcat due to error: %v", err)
+	}
+	lines := make(chan string)
+	go func() {
+		s := bufio.NewScanner(out)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+	go func() {
+		var e *logcatEntry
+		flush := func() {
+			if e != nil && e.isFailureOf(pkg) {
+				failures <- e.lines
+			}
+			e = nil
+		}
+		for {
+			// An entry ends with the next of another tag or process, or once
+			// logcat goes quiet, as a stack trace is logged all at once.
+			select {
+			case l, ok := <-lines:
+				if !ok {
+					flush()
+					return
+				}
+				m := logcatLine.FindStringSubmatch(l)
+				if m == nil {
+					continue
+				}
+				tag, pid, msg := strings.TrimSpace(m[1]), m[2], m[3]
+				if e != nil && e.tag == tag && e.pid == pid && !strings.HasPrefix(msg, "FATAL EXCEPTION") {
+					e.lines = append(e.lines, msg)
+					continue
+				}
+				flush()
+				e = &logcatEntry{tag: tag, pid: pid, lines: []string{msg}}
+			case <-time.After(500 * time.Millisecond):
+				flush()
+			}
+		}
+	}()
+	return cmd, nil
+}
+
+// isNativeCrash reports whether the lines logged about a failure are of a
+// native crash, which debuggerd writes a tombstone for.
+func isNativeCrash(lines []string) bool {
+	for _, l := range lines {
+		if strings.HasPrefix(l, "*** *** ***") {
+			return true
+		}
+	}
+	return false
+}
+
+// pullTombstones copies the tombstones of native crashes of pkg written since
+// the given time from the device to dir, returning their paths. Tombstones
+// can only be read where adb runs as root, such as on emulators without
+// Google Play after `adb root`.
+func pullTombstones(d device, pkg string, since time.Time, dir string) ([]string, error) {
+	minutes := int(time.Since(since).Minutes()) + 1
+	find := fmt.Sprintf("find /data/tombstones -name 'tombstone_*' ! -name '*.pb' -mmin -%v -exec grep -l %v {} +", minutes, shellQuote(">>> "+pkg+" <<<"))
+	out, err := d.adb("shell", find)
+	if err != nil {
+		return nil, fmt.Errorf("could not list tombstones, which needs `blade adb root` on the device, or else see them with `adb bugreport`: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0774); err != nil {
+		return nil, fmt.Errorf("could not create directory '%v' due to error: %v", dir, err)
+	}
+	paths := make([]string, 0)
+	for _, t := range strings.Fields(out) {
+		p := filepath.Join(dir, filepath.Base(t))
+		if _, err := d.adb("pull", t, p); err != nil {
+			return paths, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// symbolize writes the tombstone at path with its native frames resolved to
+// functions and lines, using the NDK's ndk-stack and the unstripped shared
+// libraries under symbols, to path + ".txt".
+func symbolize(path, symbols string) (string, error) {
+	ndk := os.Getenv("ANDROID_NDK_HOME")
+	if ndk == "" {
+		return "", fmt.Errorf("ANDROID_NDK_HOME must be set to symbolize tombstones with the NDK's ndk-stack")
+	}
+	out := path + ".txt"
+	f, err := os.Create(out)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cmd := exec.Command(filepath.Join(ndk, "ndk-stack"), "-sym", symbols, "-i", path)
+	cmd.Stdout = f
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error when running command ndk-stack -sym %v -i %v : %v", symbols, path, err)
+	}
+	return out, nil
+}
+
+// reportFailure prints the lines logged about a crash or ANR of pkg.
+func reportFailure(w io.Writer, pkg string, lines []string) {
+	what := "crashed"
+	if strings.HasPrefix(lines[0], "ANR") {
+		what = "stopped responding"
+	}
+	fmt.Fprintf(w, "%v\n", red(fmt.Sprintf("%v %v:", pkg, what)))
+	for _, l := range lines {
+		fmt.Fprintf(w, "  %v\n", l)
+	}
+}
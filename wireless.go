@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const knownDevicesFilename = "devices.toml"
+
+// userConfigDir returns the directory of blade's settings that are the
+// user's own rather than a project's, which follows the conventions of each
+// operating system as os.UserConfigDir does in newer releases of Go.
+func userConfigDir() (string, error) {
+	var dir string
+	switch runtime.GOOS {
+	case "windows":
+		dir = os.Getenv("AppData")
+	case "darwin":
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, "Library", "Application Support")
+		}
+	default:
+		dir = os.Getenv("XDG_CONFIG_HOME")
+		if dir == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				dir = filepath.Join(home, ".config")
+			}
+		}
+	}
+	if dir == "" {
+		return "", fmt.Errorf("could not locate the user's config directory")
+	}
+	return filepath.Join(dir, "blade"), nil
+}
+
+// knownDevices returns the addresses of the devices connected to over
+// wireless debugging, by the names they were given, which blade keeps in
+// the user's config as:
+//
+//	[device.pixel]
+//	address = "192.168.1.20:37199"
+func knownDevices() (map[string]string, error) {
+	devices := make(map[string]string)
+	dir, err := userConfigDir()
+	if err != nil {
+		return devices, err
+	}
+	p := filepath.Join(dir, knownDevicesFilename)
+	b, err := ioutil.ReadFile(p)
+	switch {
+	case os.IsNotExist(err):
+		return devices, nil
+	case err != nil:
+		return devices, fmt.Errorf("could not read known devices at '%v' due to error: %v", p, err)
+	}
+	t, err := parseTOML(string(b))
+	if err != nil {
+		return devices, fmt.Errorf("could not parse known devices at '%v' due to error: %v", p, err)
+	}
+	dd, err := table(t, "device")
+	if err != nil {
+		return devices, err
+	}
+	for name := range dd {
+		d, err := table(dd, name)
+		if err != nil {
+			return devices, err
+		}
+		if devices[name], err = stringValue(d, "address"); err != nil {
+			return devices, err
+		}
+	}
+	return devices, nil
+}
+
+func writeKnownDevices(devices map[string]string) error {
+	dir, err := userConfigDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return fmt.Errorf("could not create config directory '%v' due to error: %v", dir, err)
+	}
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var w strings.Builder
+	w.WriteString("# Devices connected to with `blade connect -name`, written by blade.\n")
+	for _, name := range names {
+		fmt.Fprintf(&w, "\n[device.%v]\naddress = %v\n", strconv.Quote(name), strconv.Quote(devices[name]))
+	}
+	p := filepath.Join(dir, knownDevicesFilename)
+	if err := ioutil.WriteFile(p, []byte(w.String()), 0664); err != nil {
+		return fmt.Errorf("could not write known devices to '%v' due to error: %v", p, err)
+	}
+	return nil
+}
+
+// connect connects adb to the device at address over wireless debugging,
+// which adb reports the failure of in its output rather than its status.
+func (d device) connect(address string) error {
+	out, err := d.adb("connect", address)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "connected to") {
+		return fmt.Errorf("could not connect to '%v': %v", address, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// pair pairs adb with a device over wireless debugging, given the address
+// and code shown under "Pair device with pairing code" on the device.
+func pair(arguments []string) {
+	fs := flag.NewFlagSet("pair", flag.ExitOnError)
+	sdk := fs.String("sdk", "", sdkDesc)
+	color := registerColor(fs)
+	fs.Parse(arguments)
+	applyColor(fs, *color)
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: blade pair [flags] <ip:port> <code>\n")
+		os.Exit(2)
+	}
+	d, err := newDevice(*sdk, "")
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := d.run("pair", fs.Arg(0), fs.Arg(1)); err != nil {
+		exitWithStatus(err)
+	}
+}
+
+// connectCommand connects to a device over wireless debugging, given the
+// address shown under "Wireless debugging" on the device once paired, and
+// with -name remembers it so that -device can refer to it by that name.
+func connectCommand(arguments []string) {
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	sdk := fs.String("sdk", "", sdkDesc)
+	name := fs.String("name", "", "The name to remember the device by, for use as -device in lieu of its address")
+	color := registerColor(fs)
+	fs.Parse(arguments)
+	applyColor(fs, *color)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: blade connect [flags] <ip:port>\n")
+		os.Exit(2)
+	}
+	address := fs.Arg(0)
+	d, err := newDevice(*sdk, "")
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := d.connect(address); err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf("connected to %v\n", address)
+	if *name == "" {
+		return
+	}
+	devices, err := knownDevices()
+	if err != nil {
+		exitWithError(err)
+	}
+	devices[*name] = address
+	if err := writeKnownDevices(devices); err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf("saved as -device %v\n", *name)
+}
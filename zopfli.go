@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// recompressAPK recompresses each deflated entry of the APK at path with
+// zopfli, which produces deflate streams a few percent smaller than zlib's
+// at a far greater cost in time. Stored entries are copied as they are, so
+// the APK stays readable by anything that reads zip files, including
+// Android. Files for zopfli to compress are written to tmpDir.
+func (t toolchain) recompressAPK(path, tmpDir string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("could not open APK '%v' to recompress due to error: %v", path, err)
+	}
+	defer r.Close()
+	if err := os.MkdirAll(tmpDir, 0774); err != nil {
+		return fmt.Errorf("could not create directory for recompression due to error: %v", err)
+	}
+	tmp := path + ".zopfli"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("could not create '%v' due to error: %v", tmp, err)
+	}
+	defer os.Remove(tmp)
+	w := zip.NewWriter(f)
+	w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return &zopfliWriter{t: t, out: out, tmpDir: tmpDir}, nil
+	})
+	for _, e := range r.File {
+		if err := copyZipEntry(w, e); err != nil {
+			f.Close()
+			return fmt.Errorf("could not recompress '%v' of APK '%v' due to error: %v", e.Name, path, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write recompressed APK '%v' due to error: %v", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write recompressed APK '%v' due to error: %v", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func copyZipEntry(w *zip.Writer, e *zip.File) error {
+	h := e.FileHeader
+	// The writer computes these anew for the recompressed data.
+	h.CompressedSize64, h.UncompressedSize64, h.CRC32 = 0, 0, 0
+	h.CompressedSize, h.UncompressedSize = 0, 0
+	dst, err := w.CreateHeader(&h)
+	if err != nil {
+		return err
+	}
+	src, err := e.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// zopfliWriter compresses what is written to it with zopfli once closed,
+// writing the raw deflate stream to out.
+type zopfliWriter struct {
+	t      toolchain
+	out    io.Writer
+	tmpDir string
+	buf    bytes.Buffer
+}
+
+func (z *zopfliWriter) Write(p []byte) (int, error) {
+	return z.buf.Write(p)
+}
+
+func (z *zopfliWriter) Close() error {
+	f, err := ioutil.TempFile(z.tmpDir, "entry")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(z.buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	b, err := z.t.output(z.t.bin("zopfli"), "--deflate", "-c", f.Name())
+	if err != nil {
+		return err
+	}
+	_, err = z.out.Write(b)
+	return err
+}
+
+// output runs the command given by args, inside the container if the
+// toolchain has one, and returns what it printed.
+func (t toolchain) output(args ...string) ([]byte, error) {
+	if t.container != nil {
+		s, err := t.container.output(args...)
+		return []byte(s), err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = t.environ()
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error when running command %v : %v\n%v", strings.Join(args, " "), err, stderr.String())
+	}
+	return b, nil
+}
+
+// zopfliTmpDir returns where files are written for zopfli to compress.
+func zopfliTmpDir(outputDir string) string {
+	return filepath.Join(outputDir, ".blade", "zopfli")
+}